@@ -0,0 +1,82 @@
+// Command pdnsgslb-export writes the LUA records of a zone as terraform
+// resources of the provider with their import blocks.
+//
+// The connection to the DNS server is configured with the environment
+// variables of the provider, PDNSGLSB_DNSUPDATE_SERVER,
+// PDNSGLSB_DNSUPDATE_KEYNAME, PDNSGLSB_DNSUPDATE_KEYSECRET, ... The flags
+// override the environment variables, the TSIG secret is only read from the
+// environment or from a key file.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/dmachard/terraform-provider-powerdns-gslb/internal/cmdutil"
+	"github.com/dmachard/terraform-provider-powerdns-gslb/pdnsgslb"
+)
+
+func main() {
+	zone := flag.String("zone", "", "zone to export (required)")
+	out := flag.String("out", "", "file of the HCL output, the standard output by default")
+	server := flag.String("server", "", "DNS server, PDNSGLSB_DNSUPDATE_SERVER by default")
+	port := flag.Int("port", 0, "port of the DNS server, PDNSGLSB_DNSUPDATE_PORT by default")
+	transport := flag.String("transport", "", "transport to the DNS server, PDNSGLSB_DNSUPDATE_TRANSPORT by default")
+	keyName := flag.String("key-name", "", "TSIG key name, PDNSGLSB_DNSUPDATE_KEYNAME by default")
+	keyAlgo := flag.String("key-algo", "", "TSIG key algorithm, PDNSGLSB_DNSUPDATE_KEYALGORITHM by default")
+	keyFile := flag.String("key-file", "", "TSIG key file, PDNSGLSB_DNSUPDATE_KEYFILE by default")
+	flag.Parse()
+
+	if *zone == "" {
+		fmt.Fprintln(os.Stderr, "Error the -zone flag is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// the flags set override the environment variables
+	config := map[string]interface{}{}
+	for attribute, value := range map[string]string{
+		"server":    *server,
+		"transport": *transport,
+		"key_name":  *keyName,
+		"key_algo":  *keyAlgo,
+		"key_file":  *keyFile,
+	} {
+		if value != "" {
+			config[attribute] = value
+		}
+	}
+	if *port != 0 {
+		config["port"] = *port
+	}
+
+	if err := run(context.Background(), config, *zone, *out); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, config map[string]interface{}, zone string, out string) error {
+	c, err := cmdutil.Client(ctx, config, os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if out != "" {
+		f, err := os.Create(out)
+		if err != nil {
+			return fmt.Errorf("Error creating %s: %w", out, err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if err := pdnsgslb.ExportZone(ctx, c, zone, w); err != nil {
+		return fmt.Errorf("Error exporting the zone %s: %w", zone, err)
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+// Command pdnsgslb lists, diffs and applies the LUA records of a PowerDNS
+// server without terraform, with the record functions of the provider.
+//
+//	pdnsgslb list <zone>
+//	pdnsgslb get <record>
+//	pdnsgslb diff [flags] <record>
+//	pdnsgslb set [flags] <record>
+//	pdnsgslb delete <record>
+//
+// A record is the fqdn of the name, zone/name or zone/name/rrtype. The
+// connection to the DNS server is configured with the environment variables
+// of the provider, PDNSGLSB_DNSUPDATE_SERVER, PDNSGLSB_DNSUPDATE_KEYNAME,
+// PDNSGLSB_DNSUPDATE_KEYSECRET, ...
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/dmachard/terraform-provider-powerdns-gslb/internal/cmdutil"
+	"github.com/dmachard/terraform-provider-powerdns-gslb/pdnsgslb"
+)
+
+const usage = `Usage: pdnsgslb <command> [flags] <argument>
+
+Commands:
+  list <zone>             list the LUA records of the zone
+  get <record>            show the LUA records of a name
+  diff [flags] <record>   show the changes of set without applying them
+  set [flags] <record>    replace the LUA records of a name
+  delete <record>         delete the LUA records of a name
+
+A record is the fqdn of the name, zone/name or zone/name/rrtype, set, diff
+and delete only change the records of the rrtype when given. The DNS server
+is configured with the PDNSGLSB_DNSUPDATE_* environment variables.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err := run(context.Background(), os.Args[1], os.Args[2:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, command string, args []string, w io.Writer) error {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	var spec *recordFlags
+	switch command {
+	case "list", "get", "delete":
+	case "set", "diff":
+		spec = newRecordFlags(flags)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(w, usage)
+		return nil
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("Error unknown command %s", command)
+	}
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: pdnsgslb %s [flags] <argument>\n", command)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("Error %s expects one argument", command)
+	}
+	argument := flags.Arg(0)
+
+	c, err := cmdutil.Client(ctx, map[string]interface{}{}, os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	if command == "list" {
+		records, err := pdnsgslb.ListRecords(ctx, c, argument)
+		if err != nil {
+			return err
+		}
+		printRecords(w, records)
+		return nil
+	}
+
+	name, rrtype, err := pdnsgslb.ParseRecordName(argument)
+	if err != nil {
+		return err
+	}
+	if command == "get" {
+		records, err := pdnsgslb.GetRecords(ctx, c, name, rrtype)
+		if err != nil {
+			return err
+		}
+		printRecords(w, records)
+		return nil
+	}
+
+	// the records of the other rrtypes are kept
+	current, err := pdnsgslb.GetRecords(ctx, c, name, "")
+	if err != nil {
+		return err
+	}
+
+	var desired []pdnsgslb.Record
+	switch command {
+	case "delete":
+		desired = pdnsgslb.ReplaceRecords(current, rrtype, nil)
+	case "set", "diff":
+		// the rrtype of the record name, A by default
+		switch {
+		case spec.rrtype == "" && rrtype == "":
+			spec.rrtype = "A"
+		case spec.rrtype == "":
+			spec.rrtype = rrtype
+		case rrtype != "" && !strings.EqualFold(rrtype, spec.rrtype):
+			return fmt.Errorf("Error the rrtype %s of the record differs from the -rrtype flag %s", rrtype, spec.rrtype)
+		}
+		spec.rrtype = strings.ToUpper(spec.rrtype)
+
+		records, err := spec.render(name)
+		if err != nil {
+			return err
+		}
+		desired = pdnsgslb.ReplaceRecords(current, spec.rrtype, records)
+	}
+
+	changes := pdnsgslb.DiffRecords(current, desired)
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "No changes.")
+		return nil
+	}
+	for _, change := range changes {
+		fmt.Fprintln(w, change)
+	}
+	if command == "diff" {
+		return nil
+	}
+	return pdnsgslb.SetRecords(ctx, c, name, desired)
+}
+
+// recordFlags are the fields of the record of a function
+type recordFlags struct {
+	function    string
+	rrtype      string
+	ttl         int
+	snippet     string
+	addresses   string
+	backup      string
+	weights     string
+	port        int
+	timeout     int
+	url         string
+	stringmatch string
+}
+
+func newRecordFlags(flags *flag.FlagSet) *recordFlags {
+	f := &recordFlags{}
+	flags.StringVar(&f.function, "function", "lua", "function of the record, ifportup, ifurlup, pickrandom, pickwrandom or lua")
+	flags.StringVar(&f.rrtype, "rrtype", "", "rrtype of the record, A+AAAA for the addresses of both families, the rrtype of the record name or A by default")
+	flags.IntVar(&f.ttl, "ttl", 0, "ttl of the record")
+	flags.StringVar(&f.snippet, "snippet", "", "LUA snippet of the lua function")
+	flags.StringVar(&f.addresses, "addresses", "", "comma separated addresses, the primary addresses of ifurlup")
+	flags.StringVar(&f.backup, "backup", "", "comma separated backup addresses of ifurlup")
+	flags.StringVar(&f.weights, "weights", "", "comma separated weight:address of pickwrandom")
+	flags.IntVar(&f.port, "port", 0, "port checked by ifportup")
+	flags.IntVar(&f.timeout, "timeout", 5, "timeout of the ifportup and ifurlup checks")
+	flags.StringVar(&f.url, "url", "", "url checked by ifurlup")
+	flags.StringVar(&f.stringmatch, "stringmatch", "", "string expected in the ifurlup response")
+	return f
+}
+
+// render returns the LUA records of the name with the fields of the function
+func (f *recordFlags) render(name string) ([]pdnsgslb.Record, error) {
+	fields := map[string]interface{}{
+		"rrtype": f.rrtype,
+		"ttl":    f.ttl,
+	}
+	switch f.function {
+	case "ifportup":
+		fields["port"] = f.port
+		fields["timeout"] = f.timeout
+		fields["addresses"] = splitList(f.addresses)
+	case "ifurlup":
+		fields["url"] = f.url
+		fields["stringmatch"] = f.stringmatch
+		fields["timeout"] = f.timeout
+		fields["addresses"] = []interface{}{map[string]interface{}{
+			"primary": splitList(f.addresses),
+			"backup":  splitList(f.backup),
+		}}
+	case "pickrandom":
+		fields["addresses"] = splitList(f.addresses)
+	case "pickwrandom":
+		ipaddress := []interface{}{}
+		for _, v := range splitList(f.weights) {
+			weight, ip, ok := strings.Cut(v.(string), ":")
+			w, err := strconv.Atoi(weight)
+			if !ok || err != nil || w < 1 {
+				return nil, fmt.Errorf("Error invalid weight %q, expected weight:address", v)
+			}
+			ipaddress = append(ipaddress, map[string]interface{}{"weight": w, "ip": ip})
+		}
+		fields["ipaddress"] = ipaddress
+	case "lua":
+		if f.snippet == "" {
+			return nil, fmt.Errorf("Error the -snippet flag is required by the lua function")
+		}
+		fields["snippet"] = f.snippet
+	}
+	return pdnsgslb.RenderRecords(name, f.function, fields)
+}
+
+// splitList returns the elements of a comma separated list
+func splitList(s string) []interface{} {
+	list := []interface{}{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func printRecords(w io.Writer, records []pdnsgslb.Record) {
+	for _, r := range records {
+		fmt.Fprintln(w, r)
+	}
+}
@@ -11,7 +11,7 @@ terraform {
 # Configure the DNS Provider
 provider "powerdns-gslb" {
     server        = "10.0.0.211"
-    port          = "5353"
+    port          = 5353
     key_name      = "keytest."
     key_algo      = "hmac-sha256"
     key_secret    = "i4Yx6bmTJBRVLWub97qJqull3xZVIak4wz5P4x5HudIqnQ9X56x7befQAvqgGEdk5LOD0vqwomiZZb+OmTvTQQ=="
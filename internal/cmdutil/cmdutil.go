@@ -0,0 +1,36 @@
+// Package cmdutil holds the helpers shared by the commands of the provider.
+package cmdutil
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/dmachard/terraform-provider-powerdns-gslb/pdnsgslb"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+)
+
+// Client returns the client of the provider configuration, the attributes not
+// in the configuration are read from the environment variables of the
+// provider. The warnings are written to stderr.
+func Client(ctx context.Context, config map[string]interface{}, stderr io.Writer) (*pdnsgslb.Client, error) {
+	c, diags := pdnsgslb.ConfigureClient(ctx, config)
+
+	var errs []string
+	for _, d := range diags {
+		message := d.Summary
+		if d.Detail != "" {
+			message += ": " + d.Detail
+		}
+		if d.Severity == diag.Warning {
+			fmt.Fprintf(stderr, "Warning %s\n", message)
+			continue
+		}
+		errs = append(errs, "Error "+message)
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return c, nil
+}
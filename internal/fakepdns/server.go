@@ -0,0 +1,501 @@
+// Package fakepdns provides an in-process authoritative DNS server standing in
+// for PowerDNS in the unit tests. The server verifies the TSIG signatures,
+// applies the RFC 2136 updates with their prerequisites, serves the zones
+// with AXFR, and can inject faults such as SERVFAIL replies, timeouts or
+// truncated zone transfers.
+package fakepdns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bodgit/tsig"
+	"github.com/miekg/dns"
+)
+
+// TypeLUA is the rrtype of the PowerDNS LUA records
+const TypeLUA = 65402
+
+// operations of the requests, used to target the faults
+const (
+	OpQuery  = "query"
+	OpUpdate = "update"
+	OpAXFR   = "axfr"
+)
+
+// Fault is an error injected in the replies of the server
+type Fault struct {
+	// operation of the requests affected, all the requests when empty
+	Operation string
+
+	// return code of the reply, SERVFAIL for instance
+	Rcode int
+
+	// delay before the reply, longer than the client timeout to simulate a
+	// timeout, the request is applied before the delay
+	Delay time.Duration
+
+	// the zone transfer is interrupted before the final SOA record
+	Truncate bool
+
+	// number of requests affected, all the requests when zero
+	Count int
+}
+
+// Server is a fake PowerDNS server listening on a local TCP port
+type Server struct {
+	// address of the server, 127.0.0.1:port
+	Addr string
+
+	mu       sync.Mutex
+	keys     map[string]string
+	zones    map[string]*zone
+	faults   []*Fault
+	requests map[string]int
+	done     chan struct{}
+}
+
+type zone struct {
+	serial  uint32
+	records []dns.RR
+}
+
+// New starts a server accepting the messages signed with the TSIG keys, key
+// name to base64 secret, unsigned messages are accepted when keys is nil.
+// The server is stopped at the end of the test.
+func New(t testing.TB, keys map[string]string) *Server {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{
+		Addr:     listener.Addr().String(),
+		keys:     keys,
+		zones:    make(map[string]*zone),
+		requests: make(map[string]int),
+		done:     make(chan struct{}),
+	}
+
+	server := &dns.Server{
+		Listener: listener,
+		Handler:  dns.HandlerFunc(s.serveDNS),
+		// dns updates are rejected by the default accept function
+		MsgAcceptFunc: func(dh dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+	}
+	if keys != nil {
+		server.TsigProvider = tsig.HMAC(keys)
+	}
+	go server.ActivateAndServe()
+
+	// the delayed handlers are released before the shutdown
+	t.Cleanup(func() { server.Shutdown() })
+	t.Cleanup(func() { close(s.done) })
+
+	return s
+}
+
+// Port returns the port the server listens on
+func (s *Server) Port() int {
+	addr, _ := net.ResolveTCPAddr("tcp", s.Addr)
+	return addr.Port
+}
+
+// AddZone creates an empty zone
+func (s *Server) AddZone(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.zones[dns.CanonicalName(name)] = &zone{serial: 1}
+}
+
+// AddRecord adds a record in presentation format to its zone, the LUA records
+// are written TYPE65402 with the RFC 3597 syntax.
+func (s *Server) AddRecord(record string) error {
+	rr, err := dns.NewRR(record)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	z := s.findZone(rr.Header().Name)
+	if z == nil {
+		return fmt.Errorf("no zone for %s", rr.Header().Name)
+	}
+	z.add(rr)
+	return nil
+}
+
+// Records returns the records of the owner name with the rrtype, all the
+// rrtypes with dns.TypeANY
+func (s *Server) Records(name string, rrtype uint16) []dns.RR {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	z := s.findZone(name)
+	if z == nil {
+		return nil
+	}
+	var records []dns.RR
+	for _, rr := range z.records {
+		if matchRRset(rr, name, rrtype) {
+			records = append(records, dns.Copy(rr))
+		}
+	}
+	return records
+}
+
+// InjectFault adds a fault to the next requests, the faults are applied in
+// the order they were injected.
+func (s *Server) InjectFault(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = append(s.faults, &f)
+}
+
+// ClearFaults removes the injected faults
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = nil
+}
+
+// Requests returns the number of requests received for the operation, all
+// the requests when operation is empty
+func (s *Server) Requests(operation string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if operation == "" {
+		total := 0
+		for _, n := range s.requests {
+			total += n
+		}
+		return total
+	}
+	return s.requests[operation]
+}
+
+func (s *Server) serveDNS(w dns.ResponseWriter, r *dns.Msg) {
+	operation := requestOperation(r)
+
+	s.mu.Lock()
+	s.requests[operation]++
+	fault := s.nextFault(operation)
+	s.mu.Unlock()
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+
+	if s.keys != nil && (r.IsTsig() == nil || w.TsigStatus() != nil) {
+		m.Rcode = dns.RcodeNotAuth
+		w.WriteMsg(m)
+		return
+	}
+
+	switch {
+	case fault != nil && fault.Rcode != dns.RcodeSuccess:
+		m.Rcode = fault.Rcode
+	case len(r.Question) != 1:
+		m.Rcode = dns.RcodeFormatError
+	case operation == OpUpdate:
+		m.Rcode = s.update(r)
+	case operation == OpAXFR:
+		m.Rcode = s.transfer(r, m, fault != nil && fault.Truncate)
+		if m.Rcode == dns.RcodeSuccess && fault != nil && fault.Truncate {
+			// the final SOA record is never sent
+			s.writeMsg(w, r, m)
+			w.Close()
+			return
+		}
+	default:
+		m.Rcode = s.query(r, m)
+	}
+
+	// the request is applied, only the reply is delayed as a reply lost
+	if fault != nil && fault.Delay > 0 {
+		select {
+		case <-time.After(fault.Delay):
+		case <-s.done:
+			return
+		}
+	}
+	s.writeMsg(w, r, m)
+}
+
+// writeMsg signs the reply with the key of the request
+func (s *Server) writeMsg(w dns.ResponseWriter, r *dns.Msg, m *dns.Msg) {
+	if t := r.IsTsig(); t != nil && s.keys != nil {
+		m.SetTsig(t.Hdr.Name, t.Algorithm, t.Fudge, time.Now().Unix())
+	}
+	w.WriteMsg(m)
+}
+
+// nextFault returns the fault applied to the request, nil without fault
+func (s *Server) nextFault(operation string) *Fault {
+	for i, f := range s.faults {
+		if f.Operation != "" && f.Operation != operation {
+			continue
+		}
+		if f.Count > 0 {
+			f.Count--
+			if f.Count == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+		return f
+	}
+	return nil
+}
+
+// findZone returns the closest zone of the name, nil when the server is not
+// authoritative for the name
+func (s *Server) findZone(name string) *zone {
+	name = dns.CanonicalName(name)
+	for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+		if z, ok := s.zones[name[off:]]; ok {
+			return z
+		}
+	}
+	return nil
+}
+
+// query answers a standard query
+func (s *Server) query(r *dns.Msg, m *dns.Msg) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q := r.Question[0]
+	z := s.findZone(q.Name)
+	if z == nil {
+		return dns.RcodeRefused
+	}
+	if q.Qtype == dns.TypeSOA && s.zones[dns.CanonicalName(q.Name)] == z {
+		m.Answer = append(m.Answer, z.soa(q.Name))
+		return dns.RcodeSuccess
+	}
+
+	found := false
+	for _, rr := range z.records {
+		if !strings.EqualFold(rr.Header().Name, q.Name) {
+			continue
+		}
+		found = true
+		if rr.Header().Rrtype == q.Qtype || q.Qtype == dns.TypeANY {
+			m.Answer = append(m.Answer, dns.Copy(rr))
+		}
+	}
+	if !found {
+		return dns.RcodeNameError
+	}
+	return dns.RcodeSuccess
+}
+
+// transfer answers a zone transfer in a single message, the final SOA record
+// is left out of a truncated transfer
+func (s *Server) transfer(r *dns.Msg, m *dns.Msg, truncate bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := dns.CanonicalName(r.Question[0].Name)
+	z, ok := s.zones[name]
+	if !ok {
+		return dns.RcodeNotAuth
+	}
+
+	m.Answer = append(m.Answer, z.soa(name))
+	for _, rr := range z.records {
+		m.Answer = append(m.Answer, dns.Copy(rr))
+	}
+	if !truncate {
+		m.Answer = append(m.Answer, z.soa(name))
+	}
+	return dns.RcodeSuccess
+}
+
+// update applies a dns update, the prerequisites are checked before any
+// change of the zone (RFC 2136 section 3)
+func (s *Server) update(r *dns.Msg) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zoneName := dns.CanonicalName(r.Question[0].Name)
+	z, ok := s.zones[zoneName]
+	if !ok || r.Question[0].Qtype != dns.TypeSOA {
+		return dns.RcodeNotAuth
+	}
+
+	for _, rr := range append(append([]dns.RR{}, r.Answer...), r.Ns...) {
+		if !dns.IsSubDomain(zoneName, dns.CanonicalName(rr.Header().Name)) {
+			return dns.RcodeNotZone
+		}
+	}
+
+	if rcode := z.checkPrerequisites(r.Answer); rcode != dns.RcodeSuccess {
+		return rcode
+	}
+
+	for _, rr := range r.Ns {
+		h := rr.Header()
+		switch {
+		case h.Class == dns.ClassANY:
+			z.remove(func(stored dns.RR) bool { return matchRRset(stored, h.Name, h.Rrtype) })
+		case h.Class == dns.ClassNONE:
+			z.remove(func(stored dns.RR) bool {
+				return matchRRset(stored, h.Name, h.Rrtype) && rdata(stored) == rdata(rr)
+			})
+		default:
+			z.add(rr)
+		}
+	}
+	z.serial++
+	return dns.RcodeSuccess
+}
+
+// checkPrerequisites returns the return code of the first prerequisite not
+// met, NOERROR when all of them are met
+func (z *zone) checkPrerequisites(prerequisites []dns.RR) int {
+	// value dependent prerequisites are compared by rrset
+	expected := make(map[string][]dns.RR)
+
+	for _, rr := range prerequisites {
+		h := rr.Header()
+		exists := false
+		for _, stored := range z.records {
+			if matchRRset(stored, h.Name, h.Rrtype) {
+				exists = true
+				break
+			}
+		}
+
+		switch {
+		case h.Class == dns.ClassANY && h.Rrtype == dns.TypeANY:
+			if !exists {
+				return dns.RcodeNameError
+			}
+		case h.Class == dns.ClassANY:
+			if !exists {
+				return dns.RcodeNXRrset
+			}
+		case h.Class == dns.ClassNONE && h.Rrtype == dns.TypeANY:
+			if exists {
+				return dns.RcodeYXDomain
+			}
+		case h.Class == dns.ClassNONE:
+			if exists {
+				return dns.RcodeYXRrset
+			}
+		default:
+			key := dns.CanonicalName(h.Name) + "/" + dns.Type(h.Rrtype).String()
+			expected[key] = append(expected[key], rr)
+		}
+	}
+
+	for _, rrset := range expected {
+		h := rrset[0].Header()
+		var stored []string
+		for _, rr := range z.records {
+			if matchRRset(rr, h.Name, h.Rrtype) {
+				stored = append(stored, rdata(rr))
+			}
+		}
+		if len(stored) != len(rrset) {
+			return dns.RcodeNXRrset
+		}
+		for _, rr := range rrset {
+			if !contains(stored, rdata(rr)) {
+				return dns.RcodeNXRrset
+			}
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+// add adds a record, the ttl of an existing record is updated
+func (z *zone) add(rr dns.RR) {
+	rr = dns.Copy(rr)
+	rr.Header().Class = dns.ClassINET
+
+	h := rr.Header()
+	for i, stored := range z.records {
+		if matchRRset(stored, h.Name, h.Rrtype) && rdata(stored) == rdata(rr) {
+			z.records[i] = rr
+			return
+		}
+	}
+	z.records = append(z.records, rr)
+}
+
+// remove removes the records matching the function
+func (z *zone) remove(match func(dns.RR) bool) {
+	var records []dns.RR
+	for _, rr := range z.records {
+		if !match(rr) {
+			records = append(records, rr)
+		}
+	}
+	z.records = records
+}
+
+// soa returns the SOA record of the zone
+func (z *zone) soa(name string) dns.RR {
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: name, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 3600},
+		Ns:      "ns1." + name,
+		Mbox:    "hostmaster." + name,
+		Serial:  z.serial,
+		Refresh: 10800,
+		Retry:   3600,
+		Expire:  604800,
+		Minttl:  3600,
+	}
+}
+
+// requestOperation returns the operation of a request
+func requestOperation(r *dns.Msg) string {
+	switch {
+	case r.Opcode == dns.OpcodeUpdate:
+		return OpUpdate
+	case len(r.Question) > 0 && r.Question[0].Qtype == dns.TypeAXFR:
+		return OpAXFR
+	default:
+		return OpQuery
+	}
+}
+
+// matchRRset returns true when the record belongs to the rrset of the name and
+// rrtype, any rrtype with dns.TypeANY
+func matchRRset(rr dns.RR, name string, rrtype uint16) bool {
+	h := rr.Header()
+	return strings.EqualFold(h.Name, name) && (rrtype == dns.TypeANY || h.Rrtype == rrtype)
+}
+
+// rdata returns the rdata of a record in the RFC 3597 format, to compare
+// known and unknown types
+func rdata(rr dns.RR) string {
+	unknown := new(dns.RFC3597)
+	if err := unknown.ToRFC3597(rr); err != nil {
+		return rr.String()
+	}
+	return strings.ToLower(unknown.Rdata)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package fakepdns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestServerPrerequisites(t *testing.T) {
+	s := New(t, nil)
+	s.AddZone("test.internal.")
+	if err := s.AddRecord("www.test.internal. 300 IN A 192.168.1.1"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	a, _ := dns.NewRR("www.test.internal. 300 IN A 192.168.1.1")
+	other, _ := dns.NewRR("www.test.internal. 300 IN A 192.168.1.2")
+	missing, _ := dns.NewRR("missing.test.internal. 300 IN A 192.168.1.1")
+	outside, _ := dns.NewRR("www.example.com. 300 IN A 192.168.1.1")
+
+	for name, tc := range map[string]struct {
+		prepare  func(m *dns.Msg)
+		expected int
+	}{
+		"name in use":          {func(m *dns.Msg) { m.NameUsed([]dns.RR{a}) }, dns.RcodeSuccess},
+		"name not in use":      {func(m *dns.Msg) { m.NameUsed([]dns.RR{missing}) }, dns.RcodeNameError},
+		"name used":            {func(m *dns.Msg) { m.NameNotUsed([]dns.RR{a}) }, dns.RcodeYXDomain},
+		"rrset exists":         {func(m *dns.Msg) { m.RRsetUsed([]dns.RR{a}) }, dns.RcodeSuccess},
+		"rrset does not exist": {func(m *dns.Msg) { m.RRsetUsed([]dns.RR{missing}) }, dns.RcodeNXRrset},
+		"rrset exists already": {func(m *dns.Msg) { m.RRsetNotUsed([]dns.RR{a}) }, dns.RcodeYXRrset},
+		"rrset value":          {func(m *dns.Msg) { m.Used([]dns.RR{a}) }, dns.RcodeSuccess},
+		"rrset other value":    {func(m *dns.Msg) { m.Used([]dns.RR{other}) }, dns.RcodeNXRrset},
+		"not in zone":          {func(m *dns.Msg) { m.Insert([]dns.RR{outside}) }, dns.RcodeNotZone},
+	} {
+		m := new(dns.Msg)
+		m.SetUpdate("test.internal.")
+		tc.prepare(m)
+
+		r, err := exchange(s, m)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if r.Rcode != tc.expected {
+			t.Errorf("%s: expected %s, got %s", name, dns.RcodeToString[tc.expected], dns.RcodeToString[r.Rcode])
+		}
+	}
+}
+
+func TestServerUpdate(t *testing.T) {
+	s := New(t, nil)
+	s.AddZone("test.internal.")
+
+	a1, _ := dns.NewRR("www.test.internal. 300 IN A 192.168.1.1")
+	a2, _ := dns.NewRR("www.test.internal. 300 IN A 192.168.1.2")
+
+	m := new(dns.Msg)
+	m.SetUpdate("test.internal.")
+	m.Insert([]dns.RR{a1, a2})
+	if _, err := exchange(s, m); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if records := s.Records("www.test.internal.", dns.TypeA); len(records) != 2 {
+		t.Fatalf("expected 2 records, got %v", records)
+	}
+
+	m = new(dns.Msg)
+	m.SetUpdate("test.internal.")
+	m.Remove([]dns.RR{a1})
+	if _, err := exchange(s, m); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if records := s.Records("www.test.internal.", dns.TypeA); len(records) != 1 || records[0].(*dns.A).A.String() != "192.168.1.2" {
+		t.Errorf("expected the second record, got %v", records)
+	}
+
+	m = new(dns.Msg)
+	m.SetUpdate("test.internal.")
+	m.RemoveName([]dns.RR{a2})
+	if _, err := exchange(s, m); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if records := s.Records("www.test.internal.", dns.TypeANY); len(records) != 0 {
+		t.Errorf("expected no record, got %v", records)
+	}
+}
+
+// exchange sends the message to the server over tcp
+func exchange(s *Server, m *dns.Msg) (*dns.Msg, error) {
+	c := &dns.Client{Net: "tcp"}
+	r, _, err := c.Exchange(m, s.Addr)
+	return r, err
+}
@@ -1,15 +1,29 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"log"
+
 	"github.com/dmachard/terraform-provider-powerdns-gslb/pdnsgslb"
-	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
-	"github.com/hashicorp/terraform-plugin-sdk/v2/plugin"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5/tf5server"
 )
 
 func main() {
-	plugin.Serve(&plugin.ServeOpts{
-		ProviderFunc: func() *schema.Provider {
-			return pdnsgslb.Provider()
-		},
-	})
+	debug := flag.Bool("debug", false, "start the provider with support for debuggers")
+	flag.Parse()
+
+	// the sdk provider is muxed with the provider functions of the framework
+	server, err := pdnsgslb.ProviderServer(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var opts []tf5server.ServeOpt
+	if *debug {
+		opts = append(opts, tf5server.WithManagedDebug())
+	}
+	if err := tf5server.Serve("registry.terraform.io/dmachard/powerdns-gslb", server, opts...); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -1,41 +1,129 @@
 package pdnsgslb
 
 import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/bodgit/tsig"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
 	"github.com/miekg/dns"
 )
 
 const (
 	TYPE_LUA = 65402
+
+	// authentication modes of the dns updates
+	authModeTsig = "tsig"
+	authModeSig0 = "sig0"
+	authModeGss  = "gss"
+
+	// default timeout of the dns client for dial, read and write
+	dnsTimeout = 2 * time.Second
+
+	// unsigned messages allowed in a row in a signed zone transfer
+	maxUnsignedMessages = 99
+)
+
+var (
+	errServerUnreachable = errors.New("DNS server unreachable")
+	errTimeout           = errors.New("DNS server timeout")
+	errConnRefused       = errors.New("connection refused by the DNS server")
+	errTsigUnknownKey    = errors.New("TSIG key unknown")
+	errTsigBadSignature  = errors.New("TSIG bad signature")
+	errTsigClockSkew     = errors.New("TSIG clock skew")
+	errGssNegotiation    = errors.New("Error negotiating GSS-TSIG context")
+
+	// dns return codes of the failed operations
+	errNotAuth  = errors.New("not authorized (NOTAUTH)")
+	errRefused  = errors.New("refused (REFUSED)")
+	errNotZone  = errors.New("name not in zone (NOTZONE)")
+	errYXRRSet  = errors.New("rrset exists (YXRRSET)")
+	errNXRRSet  = errors.New("rrset does not exist (NXRRSET)")
+	rcodeErrors = map[int]error{
+		dns.RcodeNotAuth: errNotAuth,
+		dns.RcodeRefused: errRefused,
+		dns.RcodeNotZone: errNotZone,
+		dns.RcodeYXRrset: errYXRRSet,
+		dns.RcodeNXRrset: errNXRRSet,
+	}
 )
 
 type Client struct {
 	DNSClient *dns.Client
 	SrvAddr   string
 	Transport string
+
+	// failover between several servers, SrvAddr is the first one
+	SrvAddrs       []string
+	FailoverPolicy string
+	serverFailures map[string]time.Time
+	healthMutex    sync.Mutex
+
 	KeyName   string
 	KeyAlgo   string
 	KeySecret string
 	Retries   int
+
+	// delay between the attempts and dns return codes retried
+	RetryPolicy retryPolicy
+
+	// tsig by default, sig0 with a private key or gss with kerberos
+	AuthMode   string
+	Sig0Key    *dns.KEY
+	Sig0Signer crypto.Signer
+
+	// gss-tsig security contexts, negotiated with each server
+	gssNegotiate func(ctx context.Context, server string) (string, time.Time, error)
+	gssKeys      map[string]gssKey
+	gssMutex     sync.Mutex
+
+	// take over existing LUA rrsets on create instead of failing
+	AdoptExisting bool
+
+	// directory of the dumps of the dns messages, disabled when empty
+	DumpDir string
+	dumpSeq atomic.Uint64
 }
 
-func NewClient(server string, port int, transport string, keyname string, keysecret string, keyalgo string, retries int) (*Client, error) {
+func NewClient(server string, port int, transport string, keyname string, keysecret string, keyalgo string, retries int, tlsconfig *tls.Config) (*Client, error) {
 	c := Client{
 		DNSClient: &dns.Client{},
 		SrvAddr:   net.JoinHostPort(server, strconv.Itoa(port)),
+		Transport: transport,
 		KeyName:   keyname,
 		KeySecret: keysecret,
 		Retries:   retries,
+		AuthMode:  authModeTsig,
+
+		RetryPolicy:    defaultRetryPolicy,
+		FailoverPolicy: failoverOrdered,
 	}
 
 	c.DNSClient.Net = transport
+	c.DNSClient.TLSConfig = tlsconfig
+	c.DNSClient.DialTimeout = dnsTimeout
+	c.DNSClient.ReadTimeout = dnsTimeout
+	c.DNSClient.WriteTimeout = dnsTimeout
+
+	// no tsig key with the other authentication modes
+	if keyname == "" {
+		return &c, nil
+	}
+
 	c.DNSClient.TsigProvider = tsig.HMAC{keyname: keysecret}
 	keyalgo, err := convertTsigAlgo(keyalgo)
 	if err != nil {
@@ -46,56 +134,212 @@ func NewClient(server string, port int, transport string, keyname string, keysec
 	return &c, nil
 }
 
-func (c *Client) doTransfer(record string) ([]*dns.RFC3597, error) {
-	// init retries counter
-	retries := c.Retries
-
+func (c *Client) doTransfer(ctx context.Context, record string) ([]*dns.RFC3597, string, error) {
 	labels := dns.SplitDomainName(record)
 	zone := dns.Fqdn(strings.Join(labels[1:], "."))
 
-	dnstransfer := new(dns.Transfer)
-	dnstransfer.TsigSecret = map[string]string{c.KeyName: c.KeySecret}
+	lua_records, server, err := c.transfer(ctx, zone, record)
+	if err != nil {
+		return nil, server, err
+	}
+	if len(lua_records) == 0 {
+		return nil, server, fmt.Errorf("Error no LUA record retrieved for %s from %s", record, server)
+	}
+	return lua_records, server, nil
+}
+
+// doTransferZone returns the LUA records of all the names of the zone
+func (c *Client) doTransferZone(ctx context.Context, zone string) ([]*dns.RFC3597, string, error) {
+	return c.transfer(ctx, dns.Fqdn(zone), "")
+}
 
+// transfer returns the LUA records of the record name from a zone transfer,
+// the records of all the names when record is empty
+func (c *Client) transfer(ctx context.Context, zone string, record string) ([]*dns.RFC3597, string, error) {
 	// prepare DNS AXFR operation
 	dnsmsg := new(dns.Msg)
 	dnsmsg.SetAxfr(zone)
-	dnsmsg.SetTsig(c.KeyName, c.KeyAlgo, 300, time.Now().Unix())
+	if err := c.setTsig(dnsmsg); err != nil {
+		return nil, "", fmt.Errorf("Error on axfr zone: %w", err)
+	}
+
+	ctx = logContext(ctx)
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		lua_records, server, err := c.transferFailover(ctx, dnsmsg, record)
+
+		fields := logFields(dnsmsg, server, attempt, start)
+		if record != "" {
+			fields["owner"] = record
+		}
+		fields["records"] = len(lua_records)
+		if err != nil {
+			fields["error"] = err.Error()
+			tflog.SubsystemWarn(ctx, logSubsystem, "DNS AXFR failed", fields)
+		} else {
+			tflog.SubsystemDebug(ctx, logSubsystem, "DNS AXFR", fields)
+		}
+
+		if err != nil {
+			// retry on network error, tsig and dns errors are not retried
+			if !isNetworkError(err) || ctx.Err() != nil || attempt >= c.Retries {
+				return nil, server, fmt.Errorf("Error on axfr zone: %w", err)
+			}
+			if err := c.RetryPolicy.wait(ctx, attempt); err != nil {
+				return nil, server, fmt.Errorf("Error on axfr zone: %w", err)
+			}
+			continue
+		}
+		return lua_records, server, nil
+	}
+}
+
+// transferFailover runs the zone transfer with the first server available
+func (c *Client) transferFailover(ctx context.Context, dnsmsg *dns.Msg, record string) ([]*dns.RFC3597, string, error) {
+	// zone transfer is done over the same transport as the updates
+	xfrclient := &dns.Client{
+		Net:         transferTransport(c.Transport),
+		TLSConfig:   c.DNSClient.TLSConfig,
+		DialTimeout: c.DNSClient.DialTimeout,
+	}
+
+	var failures serverErrors
+	for _, server := range c.servers() {
+		if err := ctx.Err(); err != nil {
+			return nil, server, err
+		}
+		conn, err := xfrclient.DialContext(ctx, server)
+		if err == nil {
+			var lua_records []*dns.RFC3597
+			lua_records, err = c.readTransfer(ctx, conn, dnsmsg, record, server)
+			conn.Close()
+			if err == nil || !isNetworkError(err) {
+				c.setServerHealth(server, true)
+				if err != nil {
+					return nil, server, fmt.Errorf("%s: %w", server, err)
+				}
+				return lua_records, server, nil
+			}
+		}
+
+		if ctx.Err() != nil {
+			return nil, server, ctx.Err()
+		}
+
+		// connection error, try the next server
+		tflog.SubsystemWarn(ctx, logSubsystem, "DNS server failed", map[string]interface{}{
+			"server": server,
+			"error":  err.Error(),
+		})
+		c.setServerHealth(server, false)
+		failures = append(failures, fmt.Errorf("%s: %w", server, networkError(err)))
+	}
+	return nil, "", fmt.Errorf("%w: %w", errServerUnreachable, failures)
+}
 
-RetryTransfer:
-	in, err := dnstransfer.In(dnsmsg, c.SrvAddr)
+// readTransfer sends the AXFR request on the connection and collects the LUA
+// records of the name, the TSIG of the signed messages of the transfer is checked.
+func (c *Client) readTransfer(ctx context.Context, conn *dns.Conn, dnsmsg *dns.Msg, record string, server string) ([]*dns.RFC3597, error) {
+	dnstransfer := &dns.Transfer{Conn: conn}
+
+	dnsmsg, err := c.gssTsig(ctx, dnsmsg, server)
 	if err != nil {
-		// retry on transfer error
-		if retries > 0 {
-			retries--
-			goto RetryTransfer
+		return nil, err
+	}
+
+	// a cancelled context interrupts the transfer
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	dump := c.newDump("axfr", server)
+	dump.write(ctx, "request", dnsmsg)
+
+	conn.SetWriteDeadline(contextDeadline(ctx, c.DNSClient.WriteTimeout))
+	if c.AuthMode == authModeSig0 {
+		buf, err := c.signSig0(dnsmsg)
+		if err != nil {
+			return nil, err
+		}
+		dump.writeWire(ctx, "request-signed", buf)
+		if _, err := conn.Write(buf); err != nil {
+			return nil, err
+		}
+	} else {
+		if dump != nil {
+			if wire, err := c.signedWire(dnsmsg); err == nil {
+				dump.writeWire(ctx, "request-signed", wire)
+			}
+		}
+
+		// the tsig record is removed from the message once signed, a copy is
+		// sent so the message can be sent again on retry
+		dnstransfer.TsigProvider = c.DNSClient.TsigProvider
+		if err := dnstransfer.WriteMsg(dnsmsg.Copy()); err != nil {
+			return nil, err
 		}
-		return nil, fmt.Errorf("Error on axfr zone: %s", err)
 	}
 
+	// the transfer starts and ends with the SOA record of the zone
 	var lua_records []*dns.RFC3597
-	for env := range in {
-		if env.Error != nil {
-			return nil, fmt.Errorf("Error axfr zone: %s", env.Error)
+	soa_count := 0
+	responses := 1
+	unsigned := 0
+	for soa_count < 2 {
+		conn.SetReadDeadline(contextDeadline(ctx, c.DNSClient.ReadTimeout))
+		in, err := dnstransfer.ReadMsg()
+		if in == nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+		dump.write(ctx, fmt.Sprintf("response-%d", responses), in)
+		responses++
+
+		// the messages after the first one may be unsigned, the next signed
+		// message covers them (RFC 8945 section 5.3.1)
+		if c.AuthMode != authModeSig0 && soa_count > 0 && in.IsTsig() == nil && in.Rcode == dns.RcodeSuccess {
+			unsigned++
+			if unsigned > maxUnsignedMessages {
+				return nil, fmt.Errorf("%w: more than %d unsigned messages in the zone transfer from the server %s", errTsigBadSignature, maxUnsignedMessages, server)
+			}
+		} else {
+			unsigned = 0
+			if err := c.checkReply(in, err, server); err != nil {
+				return nil, err
+			}
+		}
+		if in.Rcode != dns.RcodeSuccess {
+			return nil, rcodeError(in.Rcode, server)
+		}
+		if soa_count == 0 && (len(in.Answer) == 0 || in.Answer[0].Header().Rrtype != dns.TypeSOA) {
+			return nil, fmt.Errorf("zone transfer does not start with a SOA record")
 		}
-		for _, rr := range env.RR {
-			if rr.Header().Rrtype == 65402 && rr.Header().Name == record {
+
+		for _, rr := range in.Answer {
+			if rr.Header().Rrtype == dns.TypeSOA {
+				soa_count++
+				continue
+			}
+			if rr.Header().Rrtype == TYPE_LUA && (record == "" || rr.Header().Name == record) {
 				unknownRR := new(dns.RFC3597)
 				err = unknownRR.ToRFC3597(rr)
 				if err != nil {
-					return nil, fmt.Errorf("Error to convert to rfc3597 representation: %s", env.Error)
+					return nil, fmt.Errorf("Error to convert to rfc3597 representation: %w", err)
 				}
 				lua_records = append(lua_records, unknownRR)
 			}
 		}
 	}
 
-	if len(lua_records) == 0 {
-		return nil, fmt.Errorf("Error no LUA record retrieved for %s", record)
+	// the last message of the transfer is always signed
+	if unsigned > 0 {
+		return nil, fmt.Errorf("%w: unsigned last message of the zone transfer from the server %s", errTsigBadSignature, server)
 	}
 	return lua_records, nil
 }
 
-func (c *Client) doCreate(record string, rrset []interface{}) (*dns.Msg, error) {
+func (c *Client) doCreate(ctx context.Context, record string, rrset []interface{}, adopt bool) (string, error) {
 	labels := dns.SplitDomainName(record)
 	zone := dns.Fqdn(strings.Join(labels[1:], "."))
 
@@ -103,12 +347,26 @@ func (c *Client) doCreate(record string, rrset []interface{}) (*dns.Msg, error)
 	dnsmsg := new(dns.Msg)
 	dnsmsg.SetUpdate(zone)
 
+	// the lua rrset must not exist yet, unless we explicitly take it over
+	rr_exists := new(dns.RFC3597)
+	rr_exists.Hdr.Name = record
+	rr_exists.Hdr.Rrtype = TYPE_LUA
+	if adopt {
+		dnsmsg.RemoveRRset([]dns.RR{rr_exists})
+	} else {
+		dnsmsg.RRsetNotUsed([]dns.RR{rr_exists})
+	}
+
+	var inserted []*dns.RFC3597
 	for _, rr := range rrset {
 		lua_rr := rr.(map[string]interface{})
 
 		rrtype_int, err := convertRRType(lua_rr["rrtype"].(string))
 		if err != nil {
-			return nil, err
+			return "", err
+		}
+		if err := checkSnippet(lua_rr["snippet"].(string)); err != nil {
+			return "", fmt.Errorf("Error creating DNS LUA record: %w", err)
 		}
 		dns_rr := new(dns.RFC3597)
 		dns_rr.Hdr.Name = record
@@ -121,17 +379,26 @@ func (c *Client) doCreate(record string, rrset []interface{}) (*dns.Msg, error)
 		dns_rr.Rdata += hex.EncodeToString([]byte(lua_rr["snippet"].(string)))
 
 		dnsmsg.Insert([]dns.RR{dns_rr})
+		inserted = append(inserted, dns_rr)
 	}
 
 	// send dns query
-	r, err := c.doExchange(dnsmsg)
+	r, server, lost, err := c.exchangeRetry(ctx, dnsmsg)
+	if r != nil && r.Rcode == dns.RcodeYXRrset {
+		// the server may have created the rrset before the reply of a previous
+		// attempt was lost, the rrset is ours when it holds the same records
+		if lost && c.hasRRset(ctx, zone, record, inserted) {
+			return server, nil
+		}
+		return server, fmt.Errorf("Error creating DNS LUA record: %s already exists on %s, set adopt_existing to take it over: %w", record, server, errYXRRSet)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("Error creating DNS LUA record: %s", err)
+		return server, fmt.Errorf("Error creating DNS LUA record: %w", err)
 	}
-	return r, nil
+	return server, nil
 }
 
-func (c *Client) doUpdate(record string, rrset []interface{}) (*dns.Msg, error) {
+func (c *Client) doUpdate(ctx context.Context, record string, rrset []interface{}) (string, error) {
 	labels := dns.SplitDomainName(record)
 	zone := dns.Fqdn(strings.Join(labels[1:], "."))
 
@@ -159,7 +426,10 @@ func (c *Client) doUpdate(record string, rrset []interface{}) (*dns.Msg, error)
 
 		rrtype_int, err := convertRRType(lua_rr["rrtype"].(string))
 		if err != nil {
-			return nil, err
+			return "", err
+		}
+		if err := checkSnippet(lua_rr["snippet"].(string)); err != nil {
+			return "", fmt.Errorf("Error updating DNS LUA record: %w", err)
 		}
 
 		rr_insert.Rdata = fmt.Sprintf("%04x", rrtype_int)
@@ -169,14 +439,14 @@ func (c *Client) doUpdate(record string, rrset []interface{}) (*dns.Msg, error)
 	}
 
 	// send dns update
-	r, err := c.doExchange(dnsmsg)
+	_, server, err := c.doExchange(ctx, dnsmsg)
 	if err != nil {
-		return nil, fmt.Errorf("Error updating DNS LUA record: %s", err)
+		return server, fmt.Errorf("Error updating DNS LUA record: %w", err)
 	}
-	return r, nil
+	return server, nil
 }
 
-func (c *Client) doDelete(record string) (*dns.Msg, error) {
+func (c *Client) doDelete(ctx context.Context, record string) (string, error) {
 	labels := dns.SplitDomainName(record)
 	zone := dns.Fqdn(strings.Join(labels[1:], "."))
 
@@ -194,49 +464,348 @@ func (c *Client) doDelete(record string) (*dns.Msg, error) {
 	dnsmsg.RemoveRRset([]dns.RR{rr})
 
 	// send dns delete
-	r, err := c.doExchange(dnsmsg)
+	_, server, err := c.doExchange(ctx, dnsmsg)
 	if err != nil {
-		return nil, fmt.Errorf("Error deleting DNS LUA record: %s", err)
+		return server, fmt.Errorf("Error deleting DNS LUA record: %w", err)
+	}
+	return server, nil
+}
+
+// hasRRset returns true when the LUA rrset of the record holds the same
+// records, read back from a zone transfer
+func (c *Client) hasRRset(ctx context.Context, zone string, record string, rrset []*dns.RFC3597) bool {
+	lua_records, _, err := c.transfer(ctx, zone, record)
+	if err != nil || len(lua_records) != len(rrset) {
+		return false
+	}
+
+	count := make(map[string]int)
+	for _, rr := range rrset {
+		count[fmt.Sprintf("%d %s", rr.Hdr.Ttl, strings.ToLower(rr.Rdata))]++
+	}
+	for _, rr := range lua_records {
+		key := fmt.Sprintf("%d %s", rr.Hdr.Ttl, strings.ToLower(rr.Rdata))
+		if count[key] == 0 {
+			return false
+		}
+		count[key]--
 	}
-	return r, nil
+	return true
 }
 
-func (c *Client) doExchange(dnsmsg *dns.Msg) (*dns.Msg, error) {
-	// init retries counter
-	retries := c.Retries
+func (c *Client) doExchange(ctx context.Context, dnsmsg *dns.Msg) (*dns.Msg, string, error) {
+	r, server, _, err := c.exchangeRetry(ctx, dnsmsg)
+	return r, server, err
+}
 
+// exchangeRetry sends the message until a reply or the last attempt, lost is
+// true when an attempt failed without reply, the server may have applied it.
+func (c *Client) exchangeRetry(ctx context.Context, dnsmsg *dns.Msg) (*dns.Msg, string, bool, error) {
 	// add tsig key
-	dnsmsg.SetTsig(c.KeyName, c.KeyAlgo, 300, time.Now().Unix())
+	if err := c.setTsig(dnsmsg); err != nil {
+		return nil, "", false, err
+	}
+
+	lost := false
+
+	ctx = logContext(ctx)
+	for attempt := 0; ; attempt++ {
+		// make dns operation
+		start := time.Now()
+		r, server, err := c.exchangeFailover(ctx, dnsmsg)
+
+		fields := logFields(dnsmsg, server, attempt, start)
+		if r == nil {
+			fields["error"] = err.Error()
+			tflog.SubsystemWarn(ctx, logSubsystem, "DNS UPDATE failed", fields)
+		} else {
+			fields["rcode"] = dns.RcodeToString[r.Rcode]
+			tflog.SubsystemDebug(ctx, logSubsystem, "DNS UPDATE", fields)
+		}
+
+		if r == nil {
+			// retry on network failure, not on a failed gss negotiation
+			if ctx.Err() != nil || attempt >= c.Retries || errors.Is(err, errGssNegotiation) {
+				return nil, server, lost, err
+			}
+			lost = true
+		} else {
+			// check the signature of the reply, tsig errors are not retried
+			if err := c.checkReply(r, err, server); err != nil {
+				return nil, server, lost, err
+			}
+
+			// dns success ? the reply is returned so callers can inspect the rcode
+			retry := c.RetryPolicy.retryRcode(r.Rcode) && attempt < c.Retries
+			if r.Rcode == dns.RcodeSuccess {
+				return r, server, lost, nil
+			}
+			if !retry {
+				return r, server, lost, rcodeError(r.Rcode, server)
+			}
+
+			// retry on dns failure, on another server with the health policy
+			c.setServerHealth(server, false)
+		}
+
+		if err := c.RetryPolicy.wait(ctx, attempt); err != nil {
+			return nil, server, lost, err
+		}
+	}
+}
+
+// doVerify sends a signed SOA query to the server and checks the TSIG of the
+// reply, to detect connectivity and key problems before the first update. The
+// reply must be signed whatever its rcode, an unsigned refusal of the query
+// does not verify the key.
+func (c *Client) doVerify(ctx context.Context) error {
+	dnsmsg := new(dns.Msg)
+	dnsmsg.SetQuestion(".", dns.TypeSOA)
+	if err := c.setTsig(dnsmsg); err != nil {
+		return err
+	}
 
-RetryDnsOperation:
-	// make dns operation
-	r, _, err := c.DNSClient.Exchange(dnsmsg, c.SrvAddr)
+	ctx = logContext(ctx)
+	start := time.Now()
+	r, server, err := c.exchangeFailover(ctx, dnsmsg)
+	if r == nil {
+		return err
+	}
+	fields := logFields(dnsmsg, server, 0, start)
+	fields["rcode"] = dns.RcodeToString[r.Rcode]
+	tflog.SubsystemDebug(ctx, logSubsystem, "DNS verification query", fields)
+
+	if err := c.checkReply(r, err, server); err != nil {
+		return err
+	}
+	if c.AuthMode != authModeSig0 && r.IsTsig() == nil {
+		return fmt.Errorf("%w: unsigned %s reply from the server %s, the key %s is not verified", errTsigBadSignature, dns.RcodeToString[r.Rcode], server, c.KeyName)
+	}
+	return nil
+}
+
+// setTsig adds the TSIG record to the message, signed later by the dns
+// client, with the key or the gss-tsig context of the authentication mode.
+func (c *Client) setTsig(dnsmsg *dns.Msg) error {
+	switch c.AuthMode {
+	case authModeSig0:
+		return nil
+	case authModeGss:
+		// signed in exchange with the context of the server
+		return nil
+	default:
+		dnsmsg.SetTsig(c.KeyName, c.KeyAlgo, 300, time.Now().Unix())
+	}
+	return nil
+}
+
+// exchange sends the message to the server and waits for the reply, the message
+// is signed here with the sig0 authentication mode, the dns client handles tsig.
+func (c *Client) exchange(ctx context.Context, dnsmsg *dns.Msg, server string) (*dns.Msg, error) {
+	dnsmsg, err := c.gssTsig(ctx, dnsmsg, server)
 	if err != nil {
-		// retry on network failure
-		if retries > 0 {
-			retries--
-			goto RetryDnsOperation
+		return nil, err
+	}
+
+	dump := c.newDump(strings.ToLower(dns.OpcodeToString[dnsmsg.Opcode]), server)
+	dump.write(ctx, "request", dnsmsg)
+
+	if c.AuthMode != authModeSig0 {
+		if dump != nil {
+			if wire, err := c.signedWire(dnsmsg); err == nil {
+				dump.writeWire(ctx, "request-signed", wire)
+			}
 		}
+
+		// the tsig record is removed from the message once signed, a copy is
+		// sent so the message can be sent again on retry
+		r, _, err := c.DNSClient.ExchangeContext(ctx, dnsmsg.Copy(), server)
+		dump.write(ctx, "response", r)
+		return r, err
+	}
+
+	buf, err := c.signSig0(dnsmsg)
+	if err != nil {
+		return nil, err
+	}
+	dump.writeWire(ctx, "request-signed", buf)
+
+	conn, err := c.DNSClient.DialContext(ctx, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// a cancelled context interrupts the exchange
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	conn.SetWriteDeadline(contextDeadline(ctx, c.DNSClient.WriteTimeout))
+	if _, err := conn.Write(buf); err != nil {
 		return nil, err
 	}
-	// retry on dns failure
-	if r.Rcode == dns.RcodeServerFailure && retries > 0 {
-		retries--
-		goto RetryDnsOperation
+	conn.SetReadDeadline(contextDeadline(ctx, c.DNSClient.ReadTimeout))
+	r, err := conn.ReadMsg()
+	dump.write(ctx, "response", r)
+	if err == nil && r.Id != dnsmsg.Id {
+		return nil, dns.ErrId
+	}
+	return r, err
+}
+
+// checkReply checks the authentication of a reply, only tsig replies are
+// signed, the replies to sig0 requests are not verified.
+func (c *Client) checkReply(r *dns.Msg, err error, server string) error {
+	if c.AuthMode == authModeSig0 {
+		return err
+	}
+	return c.checkTsig(r, err, server)
+}
+
+// checkTsig inspects the TSIG record of the reply to a signed request, err is
+// the signature verification error returned by the dns client for this reply.
+func (c *Client) checkTsig(r *dns.Msg, err error, server string) error {
+	t := r.IsTsig()
+	if t == nil {
+		switch r.Rcode {
+		case dns.RcodeNotAuth:
+			// unsigned refusal, the server did not accept the key
+			return fmt.Errorf("%w: the server %s refused the key %s", errTsigUnknownKey, server, c.KeyName)
+		case dns.RcodeSuccess:
+			return fmt.Errorf("%w: unsigned reply from the server %s", errTsigBadSignature, server)
+		}
+		// other dns errors are reported by the caller
+		return nil
+	}
+
+	// tsig error returned by the server
+	switch t.Error {
+	case dns.RcodeBadKey:
+		return fmt.Errorf("%w: the key %s is not known by the server %s", errTsigUnknownKey, c.KeyName, server)
+	case dns.RcodeBadSig:
+		return fmt.Errorf("%w: the server %s rejected the signature, check the secret and the algorithm of the key %s", errTsigBadSignature, server, c.KeyName)
+	case dns.RcodeBadTime:
+		delta := int64(tsigServerTime(t)) - time.Now().Unix()
+		return fmt.Errorf("%w: the server %s rejected the request time, clock delta with the server is %ds", errTsigClockSkew, server, delta)
+	}
+
+	// signature of the reply checked by the dns client
+	switch {
+	case errors.Is(err, dns.ErrAuth) && r.Rcode == dns.RcodeNotAuth:
+		// the dns client does not verify NOTAUTH replies, the key was
+		// accepted but is not allowed to update the zone
+		return nil
+	case errors.Is(err, dns.ErrTime):
+		delta := int64(t.TimeSigned) - time.Now().Unix()
+		return fmt.Errorf("%w: the reply of the server %s is out of the allowed time window, clock delta with the server is %ds", errTsigClockSkew, server, delta)
+	case err != nil:
+		return fmt.Errorf("%w: invalid signature in the reply of the server %s: %s", errTsigBadSignature, server, err)
+	}
+
+	return nil
+}
+
+// tsigServerTime returns the server time of a BADTIME reply, carried in the
+// other data field (RFC 8945 section 5.2.3), or the signing time otherwise.
+func tsigServerTime(t *dns.TSIG) uint64 {
+	otherdata, err := hex.DecodeString(t.OtherData)
+	if err != nil || len(otherdata) != 6 {
+		return t.TimeSigned
+	}
+	var servertime uint64
+	for _, b := range otherdata {
+		servertime = servertime<<8 | uint64(b)
+	}
+	return servertime
+}
+
+// transferTransport returns the stream transport to use for zone transfers,
+// AXFR is not possible over udp so the tcp transport of the same family is used.
+func transferTransport(transport string) string {
+	switch transport {
+	case "", "udp":
+		return "tcp"
+	case "udp4":
+		return "tcp4"
+	case "udp6":
+		return "tcp6"
+	default:
+		return transport
+	}
+}
+
+// newTLSConfig builds the tls configuration used by the DNS over TLS transport
+func newTLSConfig(cafile string, certfile string, keyfile string, servername string, minversion string) (*tls.Config, error) {
+	tlsconfig := &tls.Config{ServerName: servername}
+
+	switch minversion {
+	case "1.0":
+		tlsconfig.MinVersion = tls.VersionTLS10
+	case "1.1":
+		tlsconfig.MinVersion = tls.VersionTLS11
+	case "1.2":
+		tlsconfig.MinVersion = tls.VersionTLS12
+	case "1.3":
+		tlsconfig.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("Unknown TLS version: %s", minversion)
+	}
+
+	// custom ca bundle to verify the server certificate
+	if cafile != "" {
+		pem, err := os.ReadFile(cafile)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("Error no certificate found in CA bundle %s", cafile)
+		}
+		tlsconfig.RootCAs = pool
 	}
 
-	// dns success ?
-	if r.Rcode != dns.RcodeSuccess {
-		return nil, fmt.Errorf("invalid dns return code: %v (%s)", r.Rcode, dns.RcodeToString[r.Rcode])
+	// client certificate authentication
+	if certfile != "" || keyfile != "" {
+		cert, err := tls.LoadX509KeyPair(certfile, keyfile)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading client certificate: %w", err)
+		}
+		tlsconfig.Certificates = []tls.Certificate{cert}
 	}
 
-	return r, nil
+	return tlsconfig, nil
+}
+
+// isNetworkError returns true for errors worth retrying on a new connection
+func isNetworkError(err error) bool {
+	var neterr net.Error
+	return errors.As(err, &neterr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, errServerUnreachable)
 }
 
 func isTimeout(err error) bool {
+	var timeout net.Error
+	return errors.As(err, &timeout) && timeout.Timeout()
+}
 
-	timeout, ok := err.(net.Error)
-	return ok && timeout.Timeout()
+// networkError classifies the network error of an operation
+func networkError(err error) error {
+	switch {
+	case isTimeout(err):
+		return fmt.Errorf("%w: %w", errTimeout, err)
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return fmt.Errorf("%w: %w", errConnRefused, err)
+	default:
+		return err
+	}
+}
+
+// rcodeError returns the error of a dns return code from the server
+func rcodeError(rcode int, server string) error {
+	err := fmt.Errorf("invalid dns return code from %s: %v (%s)", server, rcode, dns.RcodeToString[rcode])
+	if rcodeerr, ok := rcodeErrors[rcode]; ok {
+		return fmt.Errorf("%w: %w", rcodeerr, err)
+	}
+	return err
 }
 
 func convertTsigAlgo(name string) (string, error) {
@@ -245,8 +814,12 @@ func convertTsigAlgo(name string) (string, error) {
 		return dns.HmacMD5, nil
 	case "hmac-sha1":
 		return dns.HmacSHA1, nil
+	case "hmac-sha224":
+		return dns.HmacSHA224, nil
 	case "hmac-sha256":
 		return dns.HmacSHA256, nil
+	case "hmac-sha384":
+		return dns.HmacSHA384, nil
 	case "hmac-sha512":
 		return dns.HmacSHA512, nil
 	default:
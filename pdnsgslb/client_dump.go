@@ -0,0 +1,99 @@
+package pdnsgslb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/miekg/dns"
+)
+
+// messageDump writes the messages of one exchange with a server to the debug
+// dump directory, as presentation text and as wire bytes, the TSIG MAC of the
+// messages being masked.
+type messageDump struct {
+	dir    string
+	prefix string
+	server string
+}
+
+// newDump returns the dump of an exchange, nil when the dump is disabled
+func (c *Client) newDump(operation string, server string) *messageDump {
+	if c.DumpDir == "" {
+		return nil
+	}
+	seq := c.dumpSeq.Add(1)
+	return &messageDump{
+		dir:    c.DumpDir,
+		prefix: fmt.Sprintf("%s-%06d-%s", time.Now().UTC().Format("20060102T150405.000000"), seq, operation),
+		server: server,
+	}
+}
+
+// write dumps the message at a stage of the exchange
+func (d *messageDump) write(ctx context.Context, stage string, dnsmsg *dns.Msg) {
+	if d == nil || dnsmsg == nil {
+		return
+	}
+
+	masked := maskMac(dnsmsg)
+	wire, err := masked.Pack()
+	if err != nil {
+		tflog.SubsystemWarn(ctx, logSubsystem, "Unable to dump the DNS message", map[string]interface{}{"error": err.Error()})
+		return
+	}
+	d.save(ctx, stage, masked.String(), wire)
+}
+
+// writeWire dumps the wire bytes of a message at a stage of the exchange
+func (d *messageDump) writeWire(ctx context.Context, stage string, wire []byte) {
+	if d == nil {
+		return
+	}
+
+	dnsmsg := new(dns.Msg)
+	if err := dnsmsg.Unpack(wire); err != nil {
+		tflog.SubsystemWarn(ctx, logSubsystem, "Unable to dump the DNS message", map[string]interface{}{"error": err.Error()})
+		return
+	}
+	d.write(ctx, stage, dnsmsg)
+}
+
+// save writes the text and the wire files of the message, failures are
+// logged and never fail the operation
+func (d *messageDump) save(ctx context.Context, stage string, text string, wire []byte) {
+	basename := filepath.Join(d.dir, d.prefix+"-"+stage)
+	header := fmt.Sprintf(";; server %s\n;; stage %s\n", d.server, stage)
+
+	for path, content := range map[string][]byte{
+		basename + ".txt": []byte(header + text),
+		basename + ".bin": wire,
+	} {
+		if err := os.WriteFile(path, content, 0600); err != nil {
+			tflog.SubsystemWarn(ctx, logSubsystem, "Unable to dump the DNS message", map[string]interface{}{"error": err.Error()})
+		}
+	}
+}
+
+// signedWire returns the wire bytes of the message as signed by the dns
+// client, the TSIG MAC is computed with the same time as the sent message.
+func (c *Client) signedWire(dnsmsg *dns.Msg) ([]byte, error) {
+	if dnsmsg.IsTsig() == nil || c.DNSClient.TsigProvider == nil {
+		return dnsmsg.Pack()
+	}
+	wire, _, err := dns.TsigGenerateWithProvider(dnsmsg.Copy(), c.DNSClient.TsigProvider, "", false)
+	return wire, err
+}
+
+// maskMac returns a copy of the message with the TSIG MAC replaced by zeros
+func maskMac(dnsmsg *dns.Msg) *dns.Msg {
+	masked := dnsmsg.Copy()
+	if t := masked.IsTsig(); t != nil {
+		t.MAC = strings.Repeat("00", int(t.MACSize))
+	}
+	return masked
+}
@@ -0,0 +1,68 @@
+package pdnsgslb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestClientDump(t *testing.T) {
+	port := testServer(t, map[string]string{testKeyName: testKeySecret}, nil, testLuaHandler)
+
+	c, err := NewClient("127.0.0.1", port, "tcp", testKeyName, testKeySecret, testKeyAlgo, 0, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	c.DumpDir = t.TempDir()
+
+	rrset := []interface{}{
+		map[string]interface{}{"rrtype": "TXT", "ttl": 30, "snippet": "os.date()"},
+	}
+	if _, err := c.doUpdate(context.Background(), "testtls.test.internal.", rrset); err != nil {
+		t.Fatalf("update: %s", err)
+	}
+	if _, _, err := c.doTransfer(context.Background(), "testtls.test.internal."); err != nil {
+		t.Fatalf("axfr: %s", err)
+	}
+
+	for _, stage := range []string{"update-request", "update-request-signed", "update-response", "axfr-request", "axfr-request-signed", "axfr-response-1"} {
+		matches, _ := filepath.Glob(filepath.Join(c.DumpDir, "*-"+stage+".txt"))
+		if len(matches) != 1 {
+			t.Errorf("expected one %s dump, got %v", stage, matches)
+			continue
+		}
+		text, err := os.ReadFile(matches[0])
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if !strings.Contains(string(text), ";; server "+c.SrvAddr) {
+			t.Errorf("%s: the server is missing in the dump:\n%s", stage, text)
+		}
+
+		wire, err := os.ReadFile(strings.TrimSuffix(matches[0], ".txt") + ".bin")
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		dnsmsg := new(dns.Msg)
+		if err := dnsmsg.Unpack(wire); err != nil {
+			t.Errorf("%s: invalid wire dump: %s", stage, err)
+			continue
+		}
+
+		// the signed messages are dumped with a masked MAC
+		if strings.HasSuffix(stage, "-signed") || strings.Contains(stage, "response") {
+			tsig := dnsmsg.IsTsig()
+			if tsig == nil {
+				t.Errorf("%s: the TSIG record is missing", stage)
+				continue
+			}
+			if strings.Trim(tsig.MAC, "0") != "" {
+				t.Errorf("%s: the TSIG MAC is not masked: %s", stage, tsig.MAC)
+			}
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package pdnsgslb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/miekg/dns"
+)
+
+const (
+	// failover policies between the dns servers
+	failoverOrdered = "ordered"
+	failoverHealth  = "health"
+
+	// a failed server is tried last during this delay with the health policy
+	failoverCooldown = 30 * time.Second
+)
+
+// useServers configures the list of dns servers, a server is a hostname or
+// an ip address with an optional port, the default port is used otherwise.
+func (c *Client) useServers(servers []string, port int, policy string) error {
+	if len(servers) == 0 {
+		return fmt.Errorf("Error at least one DNS server is required")
+	}
+	if policy != failoverOrdered && policy != failoverHealth {
+		return fmt.Errorf("Unknown failover policy: %s", policy)
+	}
+
+	var addrs []string
+	for _, server := range servers {
+		addr := server
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			addr = net.JoinHostPort(strings.Trim(server, "[]"), strconv.Itoa(port))
+		}
+		addrs = append(addrs, addr)
+	}
+
+	c.SrvAddr = addrs[0]
+	c.SrvAddrs = addrs
+	c.FailoverPolicy = policy
+	return nil
+}
+
+// servers returns the dns servers in the order to try them, the configured
+// order with the ordered policy, the healthy servers first with the health
+// policy, servers which failed recently being tried last.
+func (c *Client) servers() []string {
+	if len(c.SrvAddrs) == 0 {
+		return []string{c.SrvAddr}
+	}
+	if c.FailoverPolicy != failoverHealth {
+		return c.SrvAddrs
+	}
+
+	c.healthMutex.Lock()
+	defer c.healthMutex.Unlock()
+
+	var healthy, failed []string
+	for _, server := range c.SrvAddrs {
+		if since, ok := c.serverFailures[server]; ok && time.Since(since) < failoverCooldown {
+			failed = append(failed, server)
+		} else {
+			healthy = append(healthy, server)
+		}
+	}
+	return append(healthy, failed...)
+}
+
+// setServerHealth records the result of the last operation on the server
+func (c *Client) setServerHealth(server string, healthy bool) {
+	c.healthMutex.Lock()
+	defer c.healthMutex.Unlock()
+
+	if c.serverFailures == nil {
+		c.serverFailures = make(map[string]time.Time)
+	}
+	if healthy {
+		delete(c.serverFailures, server)
+	} else {
+		c.serverFailures[server] = time.Now()
+	}
+}
+
+// exchangeFailover sends the message to the servers until one of them replies
+// and returns the reply with the server which served it.
+func (c *Client) exchangeFailover(ctx context.Context, dnsmsg *dns.Msg) (*dns.Msg, string, error) {
+	var failures serverErrors
+	for _, server := range c.servers() {
+		if err := ctx.Err(); err != nil {
+			return nil, server, err
+		}
+		r, err := c.exchange(ctx, dnsmsg, server)
+		if r != nil {
+			c.setServerHealth(server, true)
+			return r, server, err
+		}
+		if ctx.Err() != nil {
+			return nil, server, ctx.Err()
+		}
+		// a failed gss negotiation is not a failure of the server
+		if errors.Is(err, errGssNegotiation) {
+			return nil, server, err
+		}
+		tflog.SubsystemWarn(ctx, logSubsystem, "DNS server failed", map[string]interface{}{
+			"server": server,
+			"error":  err.Error(),
+		})
+		c.setServerHealth(server, false)
+		failures = append(failures, fmt.Errorf("%s: %w", server, networkError(err)))
+	}
+	return nil, "", fmt.Errorf("%w: %w", errServerUnreachable, failures)
+}
+
+// serverErrors are the errors of the servers tried by an operation
+type serverErrors []error
+
+func (e serverErrors) Error() string {
+	var messages []string
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, ", ")
+}
+
+func (e serverErrors) Unwrap() []error {
+	return e
+}
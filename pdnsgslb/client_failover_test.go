@@ -0,0 +1,103 @@
+package pdnsgslb
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// testClosedPort returns a local port with no server listening
+func testClosedPort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	return port
+}
+
+func TestUseServers(t *testing.T) {
+	c := &Client{}
+	if err := c.useServers([]string{"10.0.0.1", "10.0.0.2:5353", "2001:db8::1", "[2001:db8::2]:5353"}, 53, failoverOrdered); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := []string{"10.0.0.1:53", "10.0.0.2:5353", "[2001:db8::1]:53", "[2001:db8::2]:5353"}
+	if !reflect.DeepEqual(c.SrvAddrs, expected) {
+		t.Errorf("expected %v, got %v", expected, c.SrvAddrs)
+	}
+	if c.SrvAddr != expected[0] {
+		t.Errorf("expected the first server as primary, got %s", c.SrvAddr)
+	}
+
+	if err := c.useServers(nil, 53, failoverOrdered); err == nil {
+		t.Error("expected an error without server")
+	}
+	if err := c.useServers([]string{"10.0.0.1"}, 53, "random"); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
+
+func TestClientFailover(t *testing.T) {
+	down := testClosedPort(t)
+	up := testServer(t, map[string]string{testKeyName: testKeySecret}, nil, testLuaHandler)
+
+	c, err := NewClient("127.0.0.1", down, "tcp", testKeyName, testKeySecret, testKeyAlgo, 0, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	servers := []string{net.JoinHostPort("127.0.0.1", strconv.Itoa(down)), net.JoinHostPort("127.0.0.1", strconv.Itoa(up))}
+	if err := c.useServers(servers, 53, failoverOrdered); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	rrset := []interface{}{
+		map[string]interface{}{"rrtype": "TXT", "ttl": 30, "snippet": "os.date()"},
+	}
+	server, err := c.doCreate(context.Background(), "testtls.test.internal.", rrset, false)
+	if err != nil || server != servers[1] {
+		t.Errorf("update expected to be served by %s, got %s: %v", servers[1], server, err)
+	}
+	_, server, err = c.doTransfer(context.Background(), "testtls.test.internal.")
+	if err != nil || server != servers[1] {
+		t.Errorf("axfr expected to be served by %s, got %s: %v", servers[1], server, err)
+	}
+
+	// the ordered policy always starts with the first server
+	if got := c.servers(); !reflect.DeepEqual(got, servers) {
+		t.Errorf("ordered policy: expected %v, got %v", servers, got)
+	}
+
+	// the health policy starts with the servers which did not fail
+	c.FailoverPolicy = failoverHealth
+	if got := c.servers(); !reflect.DeepEqual(got, []string{servers[1], servers[0]}) {
+		t.Errorf("health policy: expected the failed server last, got %v", got)
+	}
+	if d := failoverDiagnostics(c, server, "read", "testtls.test.internal."); len(d) != 1 {
+		t.Errorf("expected a failover warning, got %v", d)
+	}
+	if d := failoverDiagnostics(c, servers[0], "read", "testtls.test.internal."); len(d) != 0 {
+		t.Errorf("expected no warning from the primary server, got %v", d)
+	}
+}
+
+func TestClientFailoverUnreachable(t *testing.T) {
+	c, err := NewClient("127.0.0.1", 53, "tcp", testKeyName, testKeySecret, testKeyAlgo, 1, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	servers := []string{net.JoinHostPort("127.0.0.1", strconv.Itoa(testClosedPort(t))), net.JoinHostPort("127.0.0.1", strconv.Itoa(testClosedPort(t)))}
+	if err := c.useServers(servers, 53, failoverHealth); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := c.doDelete(context.Background(), "testtls.test.internal."); !errors.Is(err, errServerUnreachable) {
+		t.Errorf("update expected %v, got %v", errServerUnreachable, err)
+	}
+	if _, _, err := c.doTransfer(context.Background(), "testtls.test.internal."); !errors.Is(err, errServerUnreachable) {
+		t.Errorf("axfr expected %v, got %v", errServerUnreachable, err)
+	}
+}
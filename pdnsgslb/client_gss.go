@@ -0,0 +1,560 @@
+package pdnsgslb
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bodgit/tsig"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/miekg/dns"
+)
+
+const (
+	// TKEY mode of the GSS-API key establishment (RFC 2930)
+	tkeyModeGss = 3
+	// lifetime of the security context requested to the server
+	gssLifetime = time.Hour
+)
+
+// gssConfig holds the kerberos settings of the gss-tsig authentication mode
+type gssConfig struct {
+	Realm      string
+	Username   string
+	Password   string
+	Keytab     string
+	CCache     string
+	KDC        string
+	ConfigFile string
+}
+
+// krb5Config returns the content of the kerberos configuration, read from
+// the configuration file or built from the realm and the kdc.
+func (g gssConfig) krb5Config() (string, error) {
+	if g.ConfigFile != "" {
+		config, err := os.ReadFile(g.ConfigFile)
+		if err != nil {
+			return "", fmt.Errorf("Error reading kerberos configuration: %w", err)
+		}
+		return string(config), nil
+	}
+	if g.KDC == "" {
+		return "", nil
+	}
+	if g.Realm == "" {
+		return "", fmt.Errorf("Error a kerberos realm is required with a kdc")
+	}
+
+	config := "[libdefaults]\n"
+	config += fmt.Sprintf("  default_realm = %s\n", g.Realm)
+	config += "  dns_lookup_kdc = false\n"
+	config += "[realms]\n"
+	config += fmt.Sprintf("  %s = {\n    kdc = %s\n  }\n", g.Realm, g.KDC)
+	return config, nil
+}
+
+// krb5Client returns a kerberos client with the credentials of a keytab, a
+// password or a credential cache, in this order.
+func (g gssConfig) krb5Client() (*client.Client, error) {
+	krb5config, err := g.krb5Config()
+	if err != nil {
+		return nil, err
+	}
+	if krb5config == "" {
+		content, err := os.ReadFile(envOrDefault("KRB5_CONFIG", "/etc/krb5.conf"))
+		if err != nil {
+			return nil, fmt.Errorf("Error reading kerberos configuration: %w", err)
+		}
+		krb5config = string(content)
+	}
+	cfg, err := config.NewFromString(krb5config)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing kerberos configuration: %w", err)
+	}
+
+	settings := client.DisablePAFXFAST(true)
+	switch {
+	case g.Keytab != "":
+		kt, err := keytab.Load(g.Keytab)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading kerberos keytab: %w", err)
+		}
+		return client.NewWithKeytab(g.Username, g.Realm, kt, cfg, settings), nil
+	case g.Password != "":
+		return client.NewWithPassword(g.Username, g.Realm, g.Password, cfg, settings), nil
+	}
+
+	// the cache is handed to the kerberos library, the environment is only
+	// read for its default location
+	ccache := g.CCache
+	if ccache == "" {
+		ccache = envOrDefault("KRB5CCNAME", fmt.Sprintf("/tmp/krb5cc_%d", os.Getuid()))
+	}
+	cache, err := credentials.LoadCCache(strings.TrimPrefix(ccache, "FILE:"))
+	if err != nil {
+		return nil, fmt.Errorf("Error reading kerberos credential cache: %w", err)
+	}
+	cl, err := client.NewFromCCache(cache, cfg, settings)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading kerberos credential cache: %w", err)
+	}
+	return cl, nil
+}
+
+// envOrDefault returns the value of the environment variable, or the default
+// value when it is not set
+func envOrDefault(name string, value string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return value
+}
+
+// useGss switches the client to the GSS-TSIG authentication mode (RFC 3645),
+// a security context is negotiated with a TKEY exchange on the first use of
+// each server.
+//
+// The kerberos exchanges are done with gokrb5 instead of the GSS client of
+// bodgit/tsig: the latter reads the credential cache only from KRB5CCNAME and
+// sends the TKEY queries with its own dns client, out of the failover, the
+// retries and the cancellation of the operations.
+func (c *Client) useGss(config gssConfig) error {
+	if (config.Keytab != "" || config.Password != "") && (config.Realm == "" || config.Username == "") {
+		return fmt.Errorf("Error a kerberos realm and username are required with a keytab or a password")
+	}
+	if _, err := config.krb5Config(); err != nil {
+		return err
+	}
+
+	provider := &gssProvider{sessions: make(map[string]*gssSession)}
+
+	c.AuthMode = authModeGss
+	c.KeyAlgo = tsig.GSS
+	c.DNSClient.TsigProvider = provider
+	c.gssNegotiate = func(ctx context.Context, server string) (string, time.Time, error) {
+		return c.negotiateGss(ctx, server, config, provider)
+	}
+
+	return nil
+}
+
+// negotiateGss establishes a security context with the server, the AP-REQ of
+// the DNS/<server> service ticket is sent in a TKEY query and the AP-REP of
+// the server is checked for the mutual authentication.
+func (c *Client) negotiateGss(ctx context.Context, server string, config gssConfig, provider *gssProvider) (string, time.Time, error) {
+	krbclient, err := config.krb5Client()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer krbclient.Destroy()
+
+	if err := krbclient.AffirmLogin(); err != nil {
+		return "", time.Time{}, fmt.Errorf("Error obtaining kerberos ticket: %w", err)
+	}
+
+	host, _, err := net.SplitHostPort(server)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	host = strings.TrimSuffix(host, ".")
+	ticket, key, err := krbclient.GetServiceTicket("DNS/" + host)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("Error obtaining kerberos ticket for DNS/%s: %w", host, err)
+	}
+
+	apreq, err := spnego.NewKRB5TokenAPREQ(krbclient, ticket, key,
+		[]int{gssapi.ContextFlagMutual, gssapi.ContextFlagReplay, gssapi.ContextFlagInteg}, []int{flags.APOptionMutualRequired})
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("Error building kerberos AP-REQ: %w", err)
+	}
+	if err := apreq.APReq.DecryptAuthenticator(key); err != nil {
+		return "", time.Time{}, fmt.Errorf("Error building kerberos AP-REQ: %w", err)
+	}
+	token, err := apreq.Marshal()
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("Error building kerberos AP-REQ: %w", err)
+	}
+
+	keyname, err := gssKeyName(host)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	// the TKEY reply is signed with the context being negotiated, its TSIG is
+	// checked once the AP-REP is
+	provider.add(keyname, &gssSession{key: key, auth: apreq.APReq.Authenticator})
+	tkey, err := c.exchangeTkey(ctx, server, keyname, token)
+	if err != nil {
+		provider.remove(keyname)
+		return "", time.Time{}, err
+	}
+	reply, err := hex.DecodeString(tkey.Key)
+	if err != nil {
+		provider.remove(keyname)
+		return "", time.Time{}, fmt.Errorf("Error decoding TKEY reply: %w", err)
+	}
+	expiry := time.Unix(int64(tkey.Expiration), 0)
+	if err := provider.establish(keyname, reply, expiry); err != nil {
+		provider.remove(keyname)
+		return "", time.Time{}, err
+	}
+
+	return keyname, expiry, nil
+}
+
+// exchangeTkey sends the GSS-API token in a TKEY query to the server and
+// returns the TKEY record of the reply, the network errors are returned as
+// is for the failover.
+func (c *Client) exchangeTkey(ctx context.Context, server string, keyname string, token []byte) (*dns.TKEY, error) {
+	now := time.Now()
+	dnsmsg := new(dns.Msg)
+	dnsmsg.SetQuestion(keyname, dns.TypeTKEY)
+	dnsmsg.Question[0].Qclass = dns.ClassANY
+	dnsmsg.RecursionDesired = false
+	dnsmsg.Extra = append(dnsmsg.Extra, &dns.TKEY{
+		Hdr:        dns.RR_Header{Name: keyname, Rrtype: dns.TypeTKEY, Class: dns.ClassANY},
+		Algorithm:  tsig.GSS,
+		Mode:       tkeyModeGss,
+		Inception:  uint32(now.Unix()),
+		Expiration: uint32(now.Add(gssLifetime).Unix()),
+		KeySize:    uint16(len(token)),
+		Key:        hex.EncodeToString(token),
+	})
+
+	// the tokens hardly fit in a datagram, the exchange is done over the
+	// transport of the zone transfers
+	tkeyclient := &dns.Client{
+		Net:          transferTransport(c.Transport),
+		TLSConfig:    c.DNSClient.TLSConfig,
+		DialTimeout:  c.DNSClient.DialTimeout,
+		ReadTimeout:  c.DNSClient.ReadTimeout,
+		WriteTimeout: c.DNSClient.WriteTimeout,
+		TsigProvider: c.DNSClient.TsigProvider,
+	}
+
+	dump := c.newDump("tkey", server)
+	dump.write(ctx, "request", dnsmsg)
+	start := time.Now()
+	r, _, err := tkeyclient.ExchangeContext(ctx, dnsmsg, server)
+	dump.write(ctx, "response", r)
+
+	fields := logFields(dnsmsg, server, 0, start)
+	if r == nil {
+		fields["error"] = err.Error()
+		tflog.SubsystemWarn(ctx, logSubsystem, "DNS TKEY failed", fields)
+		return nil, err
+	}
+	fields["rcode"] = dns.RcodeToString[r.Rcode]
+	tflog.SubsystemDebug(ctx, logSubsystem, "DNS TKEY", fields)
+
+	// the reply must be signed, the signature is checked with the context
+	if err := c.checkTsig(r, err, server); err != nil {
+		return nil, fmt.Errorf("Error on TKEY query: %w", err)
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("Error on TKEY query: %w", rcodeError(r.Rcode, server))
+	}
+
+	var tkey *dns.TKEY
+	for _, rr := range r.Answer {
+		if t, ok := rr.(*dns.TKEY); ok {
+			tkey = t
+		}
+	}
+	if tkey == nil {
+		return nil, fmt.Errorf("Error on TKEY query: no TKEY record in the reply")
+	}
+	if tkey.Error != dns.RcodeSuccess {
+		return nil, fmt.Errorf("Error on TKEY query: %s", dns.RcodeToString[int(tkey.Error)])
+	}
+	if !strings.EqualFold(tkey.Hdr.Name, keyname) {
+		return nil, fmt.Errorf("Error on TKEY query: the reply is for the key %s instead of %s", tkey.Hdr.Name, keyname)
+	}
+	return tkey, nil
+}
+
+// gssKeyName returns a random name for the security context
+func gssKeyName(host string) (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(0x7fffffff))
+	if err != nil {
+		return "", err
+	}
+	return dns.Fqdn(fmt.Sprintf("%d.sig-%s", n.Int64(), host)), nil
+}
+
+// gssSession is a security context negotiated with a server
+type gssSession struct {
+	key    types.EncryptionKey
+	subkey types.EncryptionKey
+	auth   types.Authenticator
+	seq    uint64
+	expiry time.Time
+
+	// the context is established by the AP-REP of the TKEY reply, the TSIG
+	// of the reply is kept until then
+	established bool
+	reply       []byte
+	replyMac    []byte
+
+	// sequence numbers of the tokens of the server: the first one, the next
+	// one expected and the window of the last ones received
+	peerSeq  uint64
+	peerNext uint64
+	peerMask uint64
+}
+
+// checkAPRep checks the AP-REP of the server against the authenticator of the
+// AP-REQ, the sequence number and subkey of the server are kept.
+func (s *gssSession) checkAPRep(reply []byte) error {
+	var token spnego.KRB5Token
+	if err := token.Unmarshal(reply); err != nil {
+		return fmt.Errorf("Error decoding kerberos AP-REP: %w", err)
+	}
+	if token.IsKRBError() {
+		return fmt.Errorf("Error negotiating kerberos context: %w", token.KRBError)
+	}
+	if !token.IsAPRep() {
+		return fmt.Errorf("Error negotiating kerberos context: no AP-REP in the TKEY reply")
+	}
+
+	b, err := crypto.DecryptEncPart(token.APRep.EncPart, s.key, keyusage.AP_REP_ENCPART)
+	if err != nil {
+		return fmt.Errorf("Error decrypting kerberos AP-REP: %w", err)
+	}
+	var part messages.EncAPRepPart
+	if err := part.Unmarshal(b); err != nil {
+		return fmt.Errorf("Error decoding kerberos AP-REP: %w", err)
+	}
+	if !part.CTime.Equal(s.auth.CTime) || part.Cusec != s.auth.Cusec {
+		return fmt.Errorf("Error negotiating kerberos context: the AP-REP does not match the AP-REQ")
+	}
+
+	s.seq = uint64(s.auth.SeqNumber)
+	s.peerSeq = uint64(part.SequenceNumber)
+	if part.Subkey.KeyType != 0 {
+		s.subkey = part.Subkey
+	}
+	return nil
+}
+
+// sign returns the MIC token of the message
+func (s *gssSession) sign(msg []byte) ([]byte, error) {
+	token := gssapi.MICToken{SndSeqNum: s.seq, Payload: msg}
+	key := s.key
+	if s.subkey.KeyType != 0 {
+		key = s.subkey
+		token.Flags = gssapi.MICTokenFlagAcceptorSubkey
+	}
+	if err := token.SetChecksum(key, keyusage.GSSAPI_INITIATOR_SIGN); err != nil {
+		return nil, err
+	}
+	s.seq++
+	return token.Marshal()
+}
+
+// verify checks the MIC token of the message sent by the server, a token
+// already received or older than the window is rejected
+func (s *gssSession) verify(msg []byte, mac []byte) error {
+	var token gssapi.MICToken
+	if err := token.Unmarshal(mac, true); err != nil {
+		return dns.ErrSig
+	}
+	token.Payload = msg
+
+	key := s.key
+	if s.subkey.KeyType != 0 {
+		key = s.subkey
+	}
+	if ok, _ := token.Verify(key, keyusage.GSSAPI_ACCEPTOR_SIGN); !ok {
+		return dns.ErrSig
+	}
+	return s.checkSeq(token.SndSeqNum)
+}
+
+// checkSeq records the sequence number of a token of the server, the replays
+// are detected in a window of the last 64 numbers (RFC 4121 section 4.2.6.1)
+func (s *gssSession) checkSeq(seq uint64) error {
+	relative := seq - s.peerSeq
+	if relative >= s.peerNext {
+		s.peerMask = s.peerMask<<(relative-s.peerNext+1) | 1
+		s.peerNext = relative + 1
+		return nil
+	}
+
+	offset := s.peerNext - relative
+	if offset > 64 {
+		return fmt.Errorf("%w: token of the server too old, sequence number %d", dns.ErrSig, seq)
+	}
+	bit := uint64(1) << (offset - 1)
+	if s.peerMask&bit != 0 {
+		return fmt.Errorf("%w: token of the server replayed, sequence number %d", dns.ErrSig, seq)
+	}
+	s.peerMask |= bit
+	return nil
+}
+
+// gssProvider signs and verifies the TSIG of the messages with the security
+// context of the key name, the MAC is a GSS-API MIC token.
+type gssProvider struct {
+	mutex    sync.Mutex
+	sessions map[string]*gssSession
+}
+
+// add registers the security context being negotiated, the expired ones are
+// dropped
+func (p *gssProvider) add(keyname string, session *gssSession) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for name, s := range p.sessions {
+		if s.established && time.Now().After(s.expiry) {
+			delete(p.sessions, name)
+		}
+	}
+	p.sessions[keyname] = session
+}
+
+// remove drops the security context of a failed negotiation
+func (p *gssProvider) remove(keyname string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.sessions, keyname)
+}
+
+// establish checks the AP-REP of the server and the TSIG of the TKEY reply
+// carrying it, the context is then used for the messages.
+func (p *gssProvider) establish(keyname string, reply []byte, expiry time.Time) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	s, ok := p.sessions[keyname]
+	if !ok {
+		return fmt.Errorf("Error negotiating kerberos context: unknown context %s", keyname)
+	}
+	if err := s.checkAPRep(reply); err != nil {
+		return err
+	}
+	if s.replyMac == nil {
+		return fmt.Errorf("%w: unsigned TKEY reply", errTsigBadSignature)
+	}
+	if err := s.verify(s.reply, s.replyMac); err != nil {
+		return fmt.Errorf("%w: invalid signature of the TKEY reply: %w", errTsigBadSignature, err)
+	}
+	s.established = true
+	s.expiry = expiry
+	s.reply, s.replyMac = nil, nil
+	return nil
+}
+
+func (p *gssProvider) Generate(msg []byte, t *dns.TSIG) ([]byte, error) {
+	if dns.CanonicalName(t.Algorithm) != tsig.GSS {
+		return nil, dns.ErrKeyAlg
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	s, ok := p.sessions[t.Hdr.Name]
+	if !ok || !s.established {
+		return nil, dns.ErrSecret
+	}
+	return s.sign(msg)
+}
+
+func (p *gssProvider) Verify(msg []byte, t *dns.TSIG) error {
+	if dns.CanonicalName(t.Algorithm) != tsig.GSS {
+		return dns.ErrKeyAlg
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	s, ok := p.sessions[t.Hdr.Name]
+	if !ok {
+		return dns.ErrSecret
+	}
+	mac, err := hex.DecodeString(t.MAC)
+	if err != nil {
+		return err
+	}
+
+	// the TKEY reply is checked when the context is established
+	if !s.established {
+		if s.replyMac != nil {
+			return dns.ErrSig
+		}
+		s.reply = append([]byte(nil), msg...)
+		s.replyMac = mac
+		return nil
+	}
+	return s.verify(msg, mac)
+}
+
+// gssKey is the name and the expiry of the security context of a server
+type gssKey struct {
+	name   string
+	expiry time.Time
+}
+
+// gssContext returns the name of the security context negotiated with the
+// server, a new context is negotiated when none is active. The TKEY query
+// failing on the network is a failure of the server, the other errors fail
+// the negotiation.
+func (c *Client) gssContext(ctx context.Context, server string) (string, error) {
+	c.gssMutex.Lock()
+	defer c.gssMutex.Unlock()
+
+	// renew the context a bit before its expiration
+	if key, ok := c.gssKeys[server]; ok && time.Now().Add(time.Minute).Before(key.expiry) {
+		return key.name, nil
+	}
+
+	keyname, expiry, err := c.gssNegotiate(ctx, server)
+	if err != nil {
+		if isNetworkError(err) || ctx.Err() != nil {
+			return "", err
+		}
+		return "", fmt.Errorf("%w with %s: %w", errGssNegotiation, server, err)
+	}
+	if c.gssKeys == nil {
+		c.gssKeys = make(map[string]gssKey)
+	}
+	c.gssKeys[server] = gssKey{name: keyname, expiry: expiry}
+	c.KeyName = keyname
+
+	return keyname, nil
+}
+
+// gssTsig returns a copy of the message with the TSIG record of the security
+// context of the server, the message as is with the other authentication
+// modes
+func (c *Client) gssTsig(ctx context.Context, dnsmsg *dns.Msg, server string) (*dns.Msg, error) {
+	if c.AuthMode != authModeGss {
+		return dnsmsg, nil
+	}
+	keyname, err := c.gssContext(ctx, server)
+	if err != nil {
+		return nil, err
+	}
+	signed := dnsmsg.Copy()
+	signed.SetTsig(keyname, c.KeyAlgo, 300, time.Now().Unix())
+	return signed, nil
+}
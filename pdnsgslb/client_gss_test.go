@@ -0,0 +1,621 @@
+package pdnsgslb
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bodgit/gssapi"
+	"github.com/bodgit/tsig"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
+	"github.com/jcmturner/gokrb5/v8/iana/nametype"
+	"github.com/jcmturner/gokrb5/v8/iana/patype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/miekg/dns"
+)
+
+const (
+	testRealm       = "TEST.INTERNAL"
+	testKrbUser     = "pdns"
+	testKrbPassword = "pdns-secret"
+)
+
+// testGssProvider stands in for a negotiated kerberos security context, the
+// MAC is a hmac keyed with the context name shared by the client and server.
+type testGssProvider struct{}
+
+func (testGssProvider) Generate(msg []byte, t *dns.TSIG) ([]byte, error) {
+	if dns.CanonicalName(t.Algorithm) != tsig.GSS {
+		return nil, dns.ErrKeyAlg
+	}
+	h := hmac.New(sha256.New, []byte(t.Hdr.Name))
+	h.Write(msg)
+	return h.Sum(nil), nil
+}
+
+func (p testGssProvider) Verify(msg []byte, t *dns.TSIG) error {
+	expected, err := p.Generate(msg, t)
+	if err != nil {
+		return err
+	}
+	mac, err := hex.DecodeString(t.MAC)
+	if err != nil || !hmac.Equal(expected, mac) {
+		return dns.ErrSig
+	}
+	return nil
+}
+
+// testGssClient returns a client in gss mode with a stubbed negotiation,
+// every negotiated context name is recorded
+func testGssClient(t *testing.T, port int, lifetime time.Duration, negotiated *[]string) *Client {
+	c, err := NewClient("127.0.0.1", port, "tcp", "", "", "", 0, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	c.AuthMode = authModeGss
+	c.KeyAlgo = tsig.GSS
+	c.DNSClient.TsigProvider = testGssProvider{}
+	c.gssNegotiate = func(ctx context.Context, server string) (string, time.Time, error) {
+		keyname := fmt.Sprintf("%d.sig-pdns.test.internal.", len(*negotiated))
+		*negotiated = append(*negotiated, keyname)
+		return keyname, time.Now().Add(lifetime), nil
+	}
+	return c
+}
+
+func TestClientGss(t *testing.T) {
+	var signers []string
+	handler := func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		ts := r.IsTsig()
+		if ts == nil || w.TsigStatus() != nil {
+			m.Rcode = dns.RcodeNotAuth
+			w.WriteMsg(m)
+			return
+		}
+		signers = append(signers, ts.Hdr.Name)
+
+		if r.Question[0].Qtype == dns.TypeAXFR {
+			soa, _ := dns.NewRR("test.internal. 3600 IN SOA ns1.test.internal. hostmaster.test.internal. 1 10800 3600 604800 3600")
+			lua, _ := dns.NewRR("testgss.test.internal. 30 IN TYPE65402 \\# 12 0010 09 6f732e646174652829")
+			m.Answer = []dns.RR{soa, lua, soa}
+		}
+		m.SetTsig(ts.Hdr.Name, tsig.GSS, 300, time.Now().Unix())
+		w.WriteMsg(m)
+	}
+	port := testServerWithProvider(t, testGssProvider{}, nil, handler)
+
+	// the context is negotiated once and reused
+	var negotiated []string
+	c := testGssClient(t, port, time.Hour, &negotiated)
+	if err := c.doVerify(context.Background()); err != nil {
+		t.Fatalf("verify: %s", err)
+	}
+	if _, err := c.doDelete(context.Background(), "testgss.test.internal."); err != nil {
+		t.Fatalf("update: %s", err)
+	}
+	if _, _, err := c.doTransfer(context.Background(), "testgss.test.internal."); err != nil {
+		t.Fatalf("axfr: %s", err)
+	}
+	if len(negotiated) != 1 {
+		t.Fatalf("expected 1 negotiation, got %d", len(negotiated))
+	}
+	for _, signer := range signers {
+		if signer != negotiated[0] {
+			t.Errorf("expected messages signed with %s, got %s", negotiated[0], signer)
+		}
+	}
+
+	// an expiring context is negotiated again
+	negotiated = nil
+	c = testGssClient(t, port, 30*time.Second, &negotiated)
+	for i := 0; i < 2; i++ {
+		if _, err := c.doDelete(context.Background(), "testgss.test.internal."); err != nil {
+			t.Fatalf("update: %s", err)
+		}
+	}
+	if len(negotiated) != 2 {
+		t.Errorf("expected 2 negotiations, got %d", len(negotiated))
+	}
+}
+
+func TestClientGssNegotiationError(t *testing.T) {
+	c, err := NewClient("127.0.0.1", 53, "tcp", "", "", "", 2, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	c.AuthMode = authModeGss
+	negotiations := 0
+	c.gssNegotiate = func(ctx context.Context, server string) (string, time.Time, error) {
+		negotiations++
+		return "", time.Time{}, fmt.Errorf("KDC unreachable")
+	}
+
+	// the negotiation errors are not retried
+	_, err = c.doDelete(context.Background(), "testgss.test.internal.")
+	if !errors.Is(err, errGssNegotiation) || !strings.Contains(err.Error(), "KDC unreachable") {
+		t.Errorf("expected the negotiation error, got %v", err)
+	}
+	if negotiations != 1 {
+		t.Errorf("expected 1 negotiation, got %d", negotiations)
+	}
+}
+
+func TestGssSessionReplay(t *testing.T) {
+	s := &gssSession{peerSeq: 1000}
+	for _, seq := range []uint64{1000, 1001, 1003, 1002, 1070} {
+		if err := s.checkSeq(seq); err != nil {
+			t.Errorf("%d: %s", seq, err)
+		}
+	}
+	for _, seq := range []uint64{1002, 1070, 1003} {
+		if err := s.checkSeq(seq); !errors.Is(err, dns.ErrSig) {
+			t.Errorf("%d: expected a replay error, got %v", seq, err)
+		}
+	}
+	if err := s.checkSeq(1004); !errors.Is(err, dns.ErrSig) {
+		t.Errorf("expected an error for a token out of the window, got %v", err)
+	}
+	if err := s.checkSeq(1069); err != nil {
+		t.Errorf("1069: %s", err)
+	}
+}
+
+func TestGssConfig(t *testing.T) {
+	config, err := gssConfig{Realm: "TEST.INTERNAL", KDC: "127.0.0.1:88"}.krb5Config()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.Contains(config, "default_realm = TEST.INTERNAL") || !strings.Contains(config, "kdc = 127.0.0.1:88") {
+		t.Errorf("unexpected kerberos configuration:\n%s", config)
+	}
+
+	if _, err := (gssConfig{KDC: "127.0.0.1:88"}).krb5Config(); err == nil {
+		t.Error("expected an error for a kdc without realm")
+	}
+
+	c := &Client{DNSClient: &dns.Client{}}
+	if err := c.useGss(gssConfig{Keytab: "/etc/krb5.keytab"}); err == nil {
+		t.Error("expected an error for a keytab without realm and username")
+	}
+	if err := c.useGss(gssConfig{Realm: "TEST.INTERNAL", Username: "pdns", Password: "secret"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if c.AuthMode != authModeGss || c.KeyAlgo != tsig.GSS {
+		t.Errorf("client not in gss mode: %s %s", c.AuthMode, c.KeyAlgo)
+	}
+}
+
+// testKeytab returns a keytab with the keys of the principals, derived from
+// their name
+func testKeytab(t *testing.T, principals ...string) *keytab.Keytab {
+	kt := keytab.New()
+	for _, principal := range principals {
+		password := principal + "-secret"
+		if err := kt.AddEntry(principal, testRealm, password, time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	return kt
+}
+
+// testWriteKeytab writes the keytab to a file of the test directory
+func testWriteKeytab(t *testing.T, kt *keytab.Keytab) string {
+	b, err := kt.Marshal()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	path := filepath.Join(t.TempDir(), "krb5.keytab")
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return path
+}
+
+// testKDC is a KDC stand-in of the test realm, it answers the AS and TGS
+// exchanges over UDP without pre-authentication
+type testKDC struct {
+	keytab *keytab.Keytab
+	addr   string
+	as     atomic.Int32
+	tgs    atomic.Int32
+}
+
+func newTestKDC(t *testing.T) *testKDC {
+	kdc := &testKDC{keytab: testKeytab(t, "krbtgt/"+testRealm, testKrbUser, "DNS/127.0.0.1")}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	kdc.addr = conn.LocalAddr().String()
+
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			reply, err := kdc.handle(buf[:n])
+			if err != nil {
+				t.Errorf("kdc: %s", err)
+				continue
+			}
+			conn.WriteTo(reply, addr)
+		}
+	}()
+	return kdc
+}
+
+// ticket returns a ticket of the service for the client, with the encrypted
+// part of the reply
+func (kdc *testKDC) ticket(cname types.PrincipalName, sname types.PrincipalName, nonce int) (messages.Ticket, messages.EncKDCRepPart, error) {
+	now := time.Now().UTC()
+	tktflags := types.NewKrbFlags()
+	tkt, key, err := messages.NewTicket(cname, testRealm, sname, testRealm, tktflags, kdc.keytab,
+		etypeID.AES256_CTS_HMAC_SHA1_96, 1, now, now, now.Add(time.Hour), now.Add(time.Hour))
+	if err != nil {
+		return tkt, messages.EncKDCRepPart{}, err
+	}
+	part := messages.EncKDCRepPart{
+		Key:       key,
+		LastReqs:  []messages.LastReq{},
+		Nonce:     nonce,
+		Flags:     tktflags,
+		AuthTime:  now,
+		StartTime: now,
+		EndTime:   now.Add(time.Hour),
+		RenewTill: now.Add(time.Hour),
+		SRealm:    testRealm,
+		SName:     sname,
+	}
+	return tkt, part, nil
+}
+
+func (kdc *testKDC) handle(b []byte) ([]byte, error) {
+	var rep messages.KDCRepFields
+	var usage uint32
+	var key types.EncryptionKey
+
+	switch b[0] {
+	case 0x6a:
+		kdc.as.Add(1)
+		var req messages.ASReq
+		if err := req.Unmarshal(b); err != nil {
+			return nil, err
+		}
+		tkt, part, err := kdc.ticket(req.ReqBody.CName, req.ReqBody.SName, req.ReqBody.Nonce)
+		if err != nil {
+			return nil, err
+		}
+		key, _, err = kdc.keytab.GetEncryptionKey(req.ReqBody.CName, testRealm, 1, etypeID.AES256_CTS_HMAC_SHA1_96)
+		if err != nil {
+			return nil, err
+		}
+		rep = messages.KDCRepFields{PVNO: 5, MsgType: msgtype.KRB_AS_REP, CRealm: testRealm, CName: req.ReqBody.CName, Ticket: tkt}
+		rep.DecryptedEncPart = part
+		usage = keyusage.AS_REP_ENCPART
+	case 0x6c:
+		kdc.tgs.Add(1)
+		var req messages.TGSReq
+		if err := req.Unmarshal(b); err != nil {
+			return nil, err
+		}
+		var apreq messages.APReq
+		for _, pa := range req.PAData {
+			if pa.PADataType == patype.PA_TGS_REQ {
+				if err := apreq.Unmarshal(pa.PADataValue); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if err := apreq.Ticket.DecryptEncPart(kdc.keytab, nil); err != nil {
+			return nil, err
+		}
+		cname := apreq.Ticket.DecryptedEncPart.CName
+		tkt, part, err := kdc.ticket(cname, req.ReqBody.SName, req.ReqBody.Nonce)
+		if err != nil {
+			return nil, err
+		}
+		key = apreq.Ticket.DecryptedEncPart.Key
+		rep = messages.KDCRepFields{PVNO: 5, MsgType: msgtype.KRB_TGS_REP, CRealm: testRealm, CName: cname, Ticket: tkt}
+		rep.DecryptedEncPart = part
+		usage = keyusage.TGS_REP_ENCPART_SESSION_KEY
+	default:
+		return nil, fmt.Errorf("unexpected kerberos message %x", b[0])
+	}
+
+	part, err := rep.DecryptedEncPart.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	rep.EncPart, err = crypto.GetEncryptedData(part, key, usage, 1)
+	if err != nil {
+		return nil, err
+	}
+	if rep.MsgType == msgtype.KRB_AS_REP {
+		asrep := messages.ASRep{KDCRepFields: rep}
+		return asrep.Marshal()
+	}
+	tgsrep := messages.TGSRep{KDCRepFields: rep}
+	return tgsrep.Marshal()
+}
+
+// writeCCache writes a credential cache holding a TGT of the user, as left
+// by kinit
+func (kdc *testKDC) writeCCache(t *testing.T) string {
+	cname := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, testKrbUser)
+	sname := types.NewPrincipalName(nametype.KRB_NT_SRV_INST, "krbtgt/"+testRealm)
+	tkt, part, err := kdc.ticket(cname, sname, 0)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	ticket, err := tkt.Marshal()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	b := new(bytes.Buffer)
+	write := func(v interface{}) { binary.Write(b, binary.BigEndian, v) }
+	writeData := func(data []byte) {
+		write(int32(len(data)))
+		b.Write(data)
+	}
+	writePrincipal := func(name types.PrincipalName) {
+		write(name.NameType)
+		write(int32(len(name.NameString)))
+		writeData([]byte(testRealm))
+		for _, s := range name.NameString {
+			writeData([]byte(s))
+		}
+	}
+
+	// version 4 without header fields
+	b.Write([]byte{0x05, 0x04, 0x00, 0x00})
+	writePrincipal(cname)
+	writePrincipal(cname)
+	writePrincipal(sname)
+	write(int16(part.Key.KeyType))
+	writeData(part.Key.KeyValue)
+	for _, ts := range []time.Time{part.AuthTime, part.StartTime, part.EndTime, part.RenewTill} {
+		write(int32(ts.Unix()))
+	}
+	write(int8(0))
+	b.Write(make([]byte, 4))
+	write(int32(0))
+	write(int32(0))
+	writeData(ticket)
+	writeData(nil)
+
+	path := filepath.Join(t.TempDir(), "krb5cc")
+	if err := os.WriteFile(path, b.Bytes(), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return path
+}
+
+// testGssAcceptors signs and verifies the messages with the security
+// contexts accepted by the test server
+type testGssAcceptors struct {
+	mutex     sync.Mutex
+	acceptors map[string]*gssapi.Acceptor
+}
+
+func (a *testGssAcceptors) Generate(msg []byte, t *dns.TSIG) ([]byte, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	acceptor, ok := a.acceptors[t.Hdr.Name]
+	if !ok {
+		return nil, dns.ErrSecret
+	}
+	return acceptor.MakeSignature(msg)
+}
+
+func (a *testGssAcceptors) Verify(msg []byte, t *dns.TSIG) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	acceptor, ok := a.acceptors[t.Hdr.Name]
+	if !ok {
+		return dns.ErrSecret
+	}
+	mac, err := hex.DecodeString(t.MAC)
+	if err != nil {
+		return err
+	}
+	return acceptor.VerifySignature(msg, mac)
+}
+
+// testGssServer starts a DNS server accepting the GSS-TSIG contexts of the
+// DNS/127.0.0.1 service, the signed messages are answered by testLuaHandler.
+// The TKEY replies are signed with the accepted context unless unsigned.
+func testGssServer(t *testing.T, unsigned bool) int {
+	// the acceptor only reads its keytab from the environment
+	t.Setenv("KRB5_KTNAME", testWriteKeytab(t, testKeytab(t, "DNS/127.0.0.1")))
+
+	acceptors := &testGssAcceptors{acceptors: make(map[string]*gssapi.Acceptor)}
+	handler := func(w dns.ResponseWriter, r *dns.Msg) {
+		if r.Question[0].Qtype != dns.TypeTKEY {
+			if ts := r.IsTsig(); ts != nil && w.TsigStatus() == nil {
+				// answered with the context of the request
+				m := new(dns.Msg)
+				m.SetReply(r)
+				if r.Question[0].Qtype == dns.TypeAXFR {
+					soa, _ := dns.NewRR("test.internal. 3600 IN SOA ns1.test.internal. hostmaster.test.internal. 1 10800 3600 604800 3600")
+					lua, _ := dns.NewRR("testgss.test.internal. 30 IN TYPE65402 \\# 12 0010 09 6f732e646174652829")
+					m.Answer = []dns.RR{soa, lua, soa}
+				}
+				m.SetTsig(ts.Hdr.Name, tsig.GSS, 300, time.Now().Unix())
+				w.WriteMsg(m)
+				return
+			}
+			testLuaHandler(w, r)
+			return
+		}
+
+		m := new(dns.Msg)
+		m.SetReply(r)
+		tkey := r.Extra[0].(*dns.TKEY)
+		token, _ := hex.DecodeString(tkey.Key)
+		acceptor, _ := gssapi.NewAcceptor()
+		output, _, err := acceptor.Accept(token)
+		if err != nil {
+			t.Errorf("accept: %s", err)
+			m.Rcode = dns.RcodeRefused
+			w.WriteMsg(m)
+			return
+		}
+		acceptors.mutex.Lock()
+		acceptors.acceptors[tkey.Hdr.Name] = acceptor
+		acceptors.mutex.Unlock()
+
+		m.Answer = []dns.RR{&dns.TKEY{
+			Hdr:        dns.RR_Header{Name: tkey.Hdr.Name, Rrtype: dns.TypeTKEY, Class: dns.ClassANY},
+			Algorithm:  tsig.GSS,
+			Mode:       tkeyModeGss,
+			Inception:  tkey.Inception,
+			Expiration: tkey.Expiration,
+			KeySize:    uint16(len(output)),
+			Key:        hex.EncodeToString(output),
+		}}
+		if !unsigned {
+			m.SetTsig(tkey.Hdr.Name, tsig.GSS, 300, time.Now().Unix())
+		}
+		w.WriteMsg(m)
+	}
+	return testServerWithProvider(t, acceptors, nil, handler)
+}
+
+func TestClientGssKerberos(t *testing.T) {
+	kdc := newTestKDC(t)
+	port := testGssServer(t, false)
+	ccache := kdc.writeCCache(t)
+	userkeytab := testWriteKeytab(t, testKeytab(t, testKrbUser))
+
+	// the credential cache is not read from the environment
+	t.Setenv("KRB5CCNAME", "FILE:/nonexistent/krb5cc")
+
+	cases := []struct {
+		name   string
+		config gssConfig
+		as     int32
+	}{
+		{"password", gssConfig{Realm: testRealm, Username: testKrbUser, Password: testKrbPassword}, 1},
+		{"keytab", gssConfig{Realm: testRealm, Username: testKrbUser, Keytab: userkeytab}, 1},
+		{"ccache", gssConfig{Realm: testRealm, CCache: ccache}, 0},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			kdc.as.Store(0)
+			kdc.tgs.Store(0)
+
+			c, err := NewClient("127.0.0.1", port, "tcp", "", "", "", 0, nil)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			tc.config.KDC = kdc.addr
+			if err := c.useGss(tc.config); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+
+			if err := c.doVerify(context.Background()); err != nil {
+				t.Fatalf("verify: %s", err)
+			}
+			if _, err := c.doDelete(context.Background(), "testgss.test.internal."); err != nil {
+				t.Fatalf("update: %s", err)
+			}
+			if _, _, err := c.doTransfer(context.Background(), "testgss.test.internal."); err != nil {
+				t.Fatalf("axfr: %s", err)
+			}
+			if !strings.HasSuffix(c.KeyName, ".sig-127.0.0.1.") {
+				t.Errorf("unexpected context name %s", c.KeyName)
+			}
+			if kdc.as.Load() != tc.as || kdc.tgs.Load() != 1 {
+				t.Errorf("expected %d AS and 1 TGS exchanges, got %d and %d", tc.as, kdc.as.Load(), kdc.tgs.Load())
+			}
+		})
+	}
+
+	if ccname := os.Getenv("KRB5CCNAME"); ccname != "FILE:/nonexistent/krb5cc" {
+		t.Errorf("KRB5CCNAME changed to %s", ccname)
+	}
+
+	// a wrong password fails the negotiation
+	c, err := NewClient("127.0.0.1", port, "tcp", "", "", "", 0, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := c.useGss(gssConfig{Realm: testRealm, Username: testKrbUser, Password: "wrong", KDC: kdc.addr}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := c.doDelete(context.Background(), "testgss.test.internal."); !errors.Is(err, errGssNegotiation) {
+		t.Errorf("expected a negotiation error with a wrong password, got %v", err)
+	}
+}
+
+func TestClientGssFailover(t *testing.T) {
+	kdc := newTestKDC(t)
+	down := net.JoinHostPort("127.0.0.1", strconv.Itoa(testClosedPort(t)))
+	up := net.JoinHostPort("127.0.0.1", strconv.Itoa(testGssServer(t, false)))
+
+	c, err := NewClient("127.0.0.1", 53, "tcp", "", "", "", 0, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := c.useServers([]string{down, up}, 53, failoverOrdered); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := c.useGss(gssConfig{Realm: testRealm, Username: testKrbUser, Password: testKrbPassword, KDC: kdc.addr}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// the TKEY query failing on the first server, the context is negotiated
+	// with the next one
+	server, err := c.doDelete(context.Background(), "testgss.test.internal.")
+	if err != nil || server != up {
+		t.Fatalf("update expected to be served by %s, got %s: %v", up, server, err)
+	}
+	if _, _, err := c.doTransfer(context.Background(), "testgss.test.internal."); err != nil {
+		t.Fatalf("axfr: %s", err)
+	}
+	if _, ok := c.gssKeys[down]; ok || len(c.gssKeys) != 1 {
+		t.Errorf("expected a context with %s only, got %v", up, c.gssKeys)
+	}
+}
+
+func TestClientGssUnsignedTkey(t *testing.T) {
+	kdc := newTestKDC(t)
+	port := testGssServer(t, true)
+
+	c, err := NewClient("127.0.0.1", port, "tcp", "", "", "", 0, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := c.useGss(gssConfig{Realm: testRealm, Username: testKrbUser, Password: testKrbPassword, KDC: kdc.addr}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := c.doDelete(context.Background(), "testgss.test.internal."); !errors.Is(err, errTsigBadSignature) {
+		t.Errorf("expected an error for the unsigned TKEY reply, got %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package pdnsgslb
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// tsigKey is a TSIG key read from a key file
+type tsigKey struct {
+	Name      string
+	Algorithm string
+	Secret    string
+}
+
+var (
+	// key "name" { algorithm hmac-sha256; secret "base64"; };
+	bindKeyRegex       = regexp.MustCompile(`(?s)key\s+"?([^"\s{]+)"?\s*\{(.*?)\}\s*;`)
+	bindAlgorithmRegex = regexp.MustCompile(`algorithm\s+"?([^";\s]+)"?\s*;`)
+	bindSecretRegex    = regexp.MustCompile(`secret\s+"([^"]+)"\s*;`)
+
+	// - id: name
+	knotKeyRegex = regexp.MustCompile(`(?m)^\s*-\s*id\s*:`)
+)
+
+// readTsigKeyFile reads the TSIG keys of a key file, the BIND (tsig-keygen,
+// named.conf), knot (keymgr, knsupdate -k) and pdnsutil formats are supported.
+// With several keys in the file, keyname selects the key to use.
+func readTsigKeyFile(path string, keyname string) (*tsigKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading TSIG key file: %w", err)
+	}
+
+	keys, err := parseTsigKeys(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing TSIG key file %s: %w", path, err)
+	}
+
+	for _, key := range keys {
+		if keyname == "" || strings.TrimSuffix(key.Name, ".") == strings.TrimSuffix(keyname, ".") {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("Error no TSIG key %s in the key file %s", keyname, path)
+}
+
+// parseTsigKeys detects the format of the key file content and returns its keys
+func parseTsigKeys(content string) ([]*tsigKey, error) {
+	var keys []*tsigKey
+	var err error
+
+	switch {
+	case bindKeyRegex.MatchString(content):
+		keys, err = parseBindKeys(content)
+	case knotKeyRegex.MatchString(content):
+		keys, err = parseKnotKeys(content)
+	default:
+		keys, err = parseKeyLines(content)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no TSIG key found")
+	}
+
+	for _, key := range keys {
+		key.Algorithm = normalizeTsigAlgo(key.Algorithm)
+		if key.Name == "" || key.Algorithm == "" || key.Secret == "" {
+			return nil, fmt.Errorf("incomplete TSIG key %q, name, algorithm and secret are required", key.Name)
+		}
+		if _, err := base64.StdEncoding.DecodeString(key.Secret); err != nil {
+			return nil, fmt.Errorf("invalid secret of the TSIG key %q: %w", key.Name, err)
+		}
+	}
+	return keys, nil
+}
+
+// parseBindKeys parses the key statements written by tsig-keygen or ddns-confgen
+func parseBindKeys(content string) ([]*tsigKey, error) {
+	var keys []*tsigKey
+	for _, match := range bindKeyRegex.FindAllStringSubmatch(content, -1) {
+		key := &tsigKey{Name: match[1]}
+		if algo := bindAlgorithmRegex.FindStringSubmatch(match[2]); algo != nil {
+			key.Algorithm = algo[1]
+		}
+		if secret := bindSecretRegex.FindStringSubmatch(match[2]); secret != nil {
+			key.Secret = secret[1]
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// parseKnotKeys parses the key section of a knot configuration, as written by
+// keymgr -t, only the id, algorithm and secret items of the keys are read.
+func parseKnotKeys(content string) ([]*tsigKey, error) {
+	var keys []*tsigKey
+	var key *tsigKey
+
+	// the key section is optional in the output of keymgr -t
+	insection := true
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		raw := stripComment(scanner.Text())
+		line := strings.TrimSpace(raw)
+		if line == "" {
+			continue
+		}
+		// top level sections of the configuration file
+		if !strings.HasPrefix(raw, " ") && !strings.HasPrefix(raw, "-") && strings.HasSuffix(line, ":") {
+			insection = line == "key:"
+			key = nil
+			continue
+		}
+		if !insection {
+			continue
+		}
+
+		if strings.HasPrefix(line, "- ") {
+			key = &tsigKey{}
+			keys = append(keys, key)
+			line = strings.TrimSpace(line[2:])
+		}
+		item, value, found := strings.Cut(line, ":")
+		if !found || key == nil {
+			return nil, fmt.Errorf("unexpected line in the knot key section: %s", line)
+		}
+		value = strings.Trim(strings.TrimSpace(value), `"'`)
+		switch strings.TrimSpace(item) {
+		case "id":
+			key.Name = value
+		case "algorithm":
+			key.Algorithm = value
+		case "secret":
+			key.Secret = value
+		}
+	}
+	return keys, scanner.Err()
+}
+
+// parseKeyLines parses one key per line, either the "[algorithm:]name:secret"
+// format of knsupdate -y and kdig -y, or the "name algorithm secret" format
+// of pdnsutil list-tsig-keys and generate-tsig-key.
+func parseKeyLines(content string) ([]*tsigKey, error) {
+	var keys []*tsigKey
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+
+		// pdnsutil generate-tsig-key prefixes the key with a message
+		line = strings.TrimPrefix(line, "Create new TSIG key ")
+
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 3:
+			keys = append(keys, &tsigKey{Name: fields[0], Algorithm: fields[1], Secret: fields[2]})
+		case len(fields) == 1 && strings.Count(line, ":") == 2:
+			parts := strings.SplitN(line, ":", 3)
+			keys = append(keys, &tsigKey{Algorithm: parts[0], Name: parts[1], Secret: parts[2]})
+		case len(fields) == 1 && strings.Count(line, ":") == 1:
+			// the algorithm defaults to hmac-sha256 with knot utilities
+			parts := strings.SplitN(line, ":", 2)
+			keys = append(keys, &tsigKey{Algorithm: "hmac-sha256", Name: parts[0], Secret: parts[1]})
+		default:
+			return nil, fmt.Errorf("unknown key format: %s", line)
+		}
+	}
+	return keys, scanner.Err()
+}
+
+// stripComment removes the comment at the end of a key file line, base64
+// secrets never contain the comment marker.
+func stripComment(line string) string {
+	if i := strings.Index(line, "#"); i >= 0 {
+		return line[:i]
+	}
+	return line
+}
+
+// normalizeTsigAlgo returns the algorithm name as accepted by key_algo, key
+// files use upper case names, fqdn names and the legacy hmac-md5 name.
+func normalizeTsigAlgo(name string) string {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	return strings.TrimSuffix(name, ".sig-alg.reg.int")
+}
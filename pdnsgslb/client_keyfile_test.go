@@ -0,0 +1,150 @@
+package pdnsgslb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestParseTsigKeys(t *testing.T) {
+	cases := map[string]struct {
+		content string
+		keys    []tsigKey
+	}{
+		"bind": {
+			content: `key "keytest" {
+	algorithm hmac-sha256;
+	secret "` + testKeySecret + `";
+};
+key "other." {
+	algorithm HMAC-MD5.SIG-ALG.REG.INT;
+	secret "c2VjcmV0";
+};
+`,
+			keys: []tsigKey{
+				{Name: "keytest", Algorithm: "hmac-sha256", Secret: testKeySecret},
+				{Name: "other.", Algorithm: "hmac-md5", Secret: "c2VjcmV0"},
+			},
+		},
+		"knot": {
+			content: `# knot configuration
+server:
+    listen: 0.0.0.0@53
+
+key:
+  - id: keytest
+    algorithm: hmac-sha256
+    secret: ` + testKeySecret + `
+  - id: other
+    algorithm: hmac-sha512
+    secret: "c2VjcmV0"
+
+remote:
+  - id: primary
+    address: 127.0.0.1
+`,
+			keys: []tsigKey{
+				{Name: "keytest", Algorithm: "hmac-sha256", Secret: testKeySecret},
+				{Name: "other", Algorithm: "hmac-sha512", Secret: "c2VjcmV0"},
+			},
+		},
+		"keymgr": {
+			content: "# hmac-sha256:keytest:" + testKeySecret + "\nkey:\n- id: keytest\n  algorithm: hmac-sha256\n  secret: " + testKeySecret + "\n",
+			keys: []tsigKey{
+				{Name: "keytest", Algorithm: "hmac-sha256", Secret: testKeySecret},
+			},
+		},
+		"knsupdate": {
+			content: "hmac-sha512:keytest:" + testKeySecret + "\nother:c2VjcmV0\n",
+			keys: []tsigKey{
+				{Name: "keytest", Algorithm: "hmac-sha512", Secret: testKeySecret},
+				{Name: "other", Algorithm: "hmac-sha256", Secret: "c2VjcmV0"},
+			},
+		},
+		"pdnsutil": {
+			content: "Create new TSIG key keytest hmac-sha256 " + testKeySecret + "\nother. hmac-sha1. c2VjcmV0\n",
+			keys: []tsigKey{
+				{Name: "keytest", Algorithm: "hmac-sha256", Secret: testKeySecret},
+				{Name: "other.", Algorithm: "hmac-sha1", Secret: "c2VjcmV0"},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		keys, err := parseTsigKeys(tc.content)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if len(keys) != len(tc.keys) {
+			t.Errorf("%s: expected %d keys, got %d", name, len(tc.keys), len(keys))
+			continue
+		}
+		for i, key := range keys {
+			if *key != tc.keys[i] {
+				t.Errorf("%s: expected key %+v, got %+v", name, tc.keys[i], *key)
+			}
+		}
+	}
+
+	for name, content := range map[string]string{
+		"empty":       "# no key\n",
+		"no secret":   `key "keytest" { algorithm hmac-sha256; };`,
+		"not base64":  "keytest hmac-sha256 not-base64!",
+		"bad format":  "keytest hmac-sha256",
+		"no key item": "key:\n  algorithm: hmac-sha256\n  - id: keytest\n",
+	} {
+		if _, err := parseTsigKeys(content); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestReadTsigKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tsig.key")
+	content := "key \"other\" { algorithm hmac-sha1; secret \"c2VjcmV0\"; };\nkey \"keytest\" { algorithm hmac-sha256; secret \"" + testKeySecret + "\"; };\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := readTsigKeyFile(path, "")
+	if err != nil || key.Name != "other" {
+		t.Errorf("expected the first key, got %+v: %v", key, err)
+	}
+	key, err = readTsigKeyFile(path, testKeyName)
+	if err != nil || key.Name != "keytest" {
+		t.Errorf("expected the key %s, got %+v: %v", testKeyName, key, err)
+	}
+	if _, err := readTsigKeyFile(path, "missing."); err == nil {
+		t.Error("expected an error for a missing key name")
+	}
+	if _, err := readTsigKeyFile(filepath.Join(t.TempDir(), "missing.key"), ""); err == nil {
+		t.Error("expected an error for a missing key file")
+	}
+}
+
+func TestProviderConfigureKeyFile(t *testing.T) {
+	for _, env := range []string{"PDNSGLSB_DNSUPDATE_KEYNAME", "PDNSGLSB_DNSUPDATE_KEYALGORITHM", "PDNSGLSB_DNSUPDATE_KEYSECRET"} {
+		t.Setenv(env, "")
+	}
+
+	path := filepath.Join(t.TempDir(), "tsig.key")
+	if err := os.WriteFile(path, []byte("keytest hmac-sha256 "+testKeySecret+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	d := schema.TestResourceDataRaw(t, Provider().Schema, map[string]interface{}{
+		"server":   "127.0.0.1",
+		"key_file": path,
+	})
+	m, diags := providerConfigure(context.Background(), d)
+	if diags.HasError() {
+		t.Fatalf("unexpected errors: %v", diags)
+	}
+	if c := m.(*Client); c.KeyName != testKeyName || c.KeySecret != testKeySecret {
+		t.Errorf("key not loaded from the key file: %s", c.KeyName)
+	}
+}
@@ -0,0 +1,69 @@
+package pdnsgslb
+
+import (
+	"context"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/miekg/dns"
+)
+
+const (
+	// logs of the dns operations, the level is set with TF_LOG_PROVIDER or
+	// TF_LOG_PROVIDER_PDNSGSLB_DNS
+	logSubsystem = "dns"
+
+	// snippets are truncated in the logs, they can contain sensitive data
+	logSnippetLength = 32
+)
+
+// logContext returns the context with the logger of the dns operations
+func logContext(ctx context.Context) context.Context {
+	ctx = tflog.NewSubsystem(ctx, logSubsystem, tflog.WithLevelFromEnv("TF_LOG_PROVIDER_PDNSGSLB", logSubsystem))
+
+	// secrets are never logged, masked in case of a mistake
+	return tflog.SubsystemMaskFieldValuesWithFieldKeys(ctx, logSubsystem, "key_secret", "sig0_private_key", "krb_password", "mac")
+}
+
+// logFields returns the log fields of a dns message, the zone, the owner
+// and the rrtypes of the LUA records of an update.
+func logFields(dnsmsg *dns.Msg, server string, attempt int, start time.Time) map[string]interface{} {
+	fields := map[string]interface{}{
+		"server":     server,
+		"attempt":    attempt + 1,
+		"latency_ms": time.Since(start).Milliseconds(),
+	}
+	if len(dnsmsg.Question) > 0 {
+		fields["zone"] = dnsmsg.Question[0].Name
+	}
+
+	var rrtypes, snippets []string
+	for _, rr := range dnsmsg.Ns {
+		fields["owner"] = rr.Header().Name
+
+		lua, ok := rr.(*dns.RFC3597)
+		if !ok || len(lua.Rdata) < 6 {
+			continue
+		}
+		rrtype, _ := strconv.ParseUint(lua.Rdata[0:4], 16, 16)
+		rrtypes = append(rrtypes, dns.TypeToString[uint16(rrtype)])
+		snippet, _ := hex.DecodeString(lua.Rdata[6:])
+		snippets = append(snippets, truncateSnippet(string(snippet)))
+	}
+	if len(rrtypes) > 0 {
+		fields["rrtypes"] = rrtypes
+		fields["snippets"] = snippets
+	}
+
+	return fields
+}
+
+// truncateSnippet shortens a snippet for the logs
+func truncateSnippet(snippet string) string {
+	if len(snippet) <= logSnippetLength {
+		return snippet
+	}
+	return snippet[:logSnippetLength] + "...(truncated)"
+}
@@ -0,0 +1,77 @@
+package pdnsgslb
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-log/tflogtest"
+)
+
+func TestClientLogs(t *testing.T) {
+	port := testServer(t, map[string]string{testKeyName: testKeySecret}, nil, testLuaHandler)
+
+	c, err := NewClient("127.0.0.1", port, "tcp", testKeyName, testKeySecret, testKeyAlgo, 0, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var output bytes.Buffer
+	ctx := tflogtest.RootLogger(context.Background(), &output)
+
+	snippet := "ifportup(443, {'192.168.1.2', '192.168.1.3', '192.168.1.4'})"
+	rrset := []interface{}{
+		map[string]interface{}{"rrtype": "A", "ttl": 30, "snippet": snippet},
+	}
+	if _, err := c.doUpdate(ctx, "testtls.test.internal.", rrset); err != nil {
+		t.Fatalf("update: %s", err)
+	}
+	if _, _, err := c.doTransfer(ctx, "testtls.test.internal."); err != nil {
+		t.Fatalf("axfr: %s", err)
+	}
+
+	logs := output.String()
+	entries, err := tflogtest.MultilineJSONDecode(&output)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 log entries, got %v", entries)
+	}
+
+	update := entries[0]
+	if update["@message"] != "DNS UPDATE" || update["@module"] != "provider.dns" {
+		t.Errorf("unexpected update entry: %v", update)
+	}
+	for field, expected := range map[string]interface{}{
+		"zone":    "test.internal.",
+		"owner":   "testtls.test.internal.",
+		"server":  c.SrvAddr,
+		"attempt": float64(1),
+		"rcode":   "NOERROR",
+	} {
+		if update[field] != expected {
+			t.Errorf("update field %s: expected %v, got %v", field, expected, update[field])
+		}
+	}
+	if _, ok := update["latency_ms"]; !ok {
+		t.Error("update latency not logged")
+	}
+	if rrtypes, ok := update["rrtypes"].([]interface{}); !ok || len(rrtypes) != 1 || rrtypes[0] != "A" {
+		t.Errorf("unexpected rrtypes: %v", update["rrtypes"])
+	}
+
+	axfr := entries[1]
+	if axfr["@message"] != "DNS AXFR" || axfr["owner"] != "testtls.test.internal." || axfr["records"] != float64(1) {
+		t.Errorf("unexpected axfr entry: %v", axfr)
+	}
+
+	// the snippet is truncated and the secret is never logged
+	if !strings.Contains(logs, "ifportup(443") {
+		t.Errorf("truncated snippet not logged: %s", logs)
+	}
+	if strings.Contains(logs, snippet) || strings.Contains(logs, testKeySecret) {
+		t.Errorf("sensitive data logged: %s", logs)
+	}
+}
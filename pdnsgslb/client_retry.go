@@ -0,0 +1,96 @@
+package pdnsgslb
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/miekg/dns"
+)
+
+// retryPolicy defines the delay between the attempts of an operation and the
+// dns return codes worth retrying, network errors are always retried.
+type retryPolicy struct {
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	Jitter    bool
+	Rcodes    []int
+}
+
+var defaultRetryPolicy = retryPolicy{
+	BaseDelay: 100 * time.Millisecond,
+	MaxDelay:  5 * time.Second,
+	Jitter:    true,
+	Rcodes:    []int{dns.RcodeServerFailure},
+}
+
+// delay returns the delay before the next attempt, doubled after each failed
+// attempt up to the max delay, with a random part to spread the clients.
+func (p retryPolicy) delay(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 0; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if p.Jitter && delay > 0 {
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	}
+	return delay
+}
+
+// retryRcode returns true when the dns return code is worth retrying
+func (p retryPolicy) retryRcode(rcode int) bool {
+	for _, r := range p.Rcodes {
+		if r == rcode {
+			return true
+		}
+	}
+	return false
+}
+
+// wait sleeps before the next attempt, an error is returned when the context
+// is cancelled or expires in the meantime.
+func (p retryPolicy) wait(ctx context.Context, attempt int) error {
+	delay := p.delay(attempt)
+	tflog.SubsystemDebug(ctx, logSubsystem, "Retrying DNS operation", map[string]interface{}{
+		"attempt":  attempt + 2,
+		"delay_ms": delay.Milliseconds(),
+	})
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// convertRcodes returns the dns return codes of the names
+func convertRcodes(names []string) ([]int, error) {
+	var rcodes []int
+	for _, name := range names {
+		rcode, ok := dns.StringToRcode[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown dns return code: %s", name)
+		}
+		rcodes = append(rcodes, rcode)
+	}
+	return rcodes, nil
+}
+
+// contextDeadline returns the deadline of a network operation, bounded by
+// the deadline of the context.
+func contextDeadline(ctx context.Context, timeout time.Duration) time.Time {
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		return d
+	}
+	return deadline
+}
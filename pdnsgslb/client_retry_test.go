@@ -0,0 +1,209 @@
+package pdnsgslb
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dmachard/terraform-provider-powerdns-gslb/internal/fakepdns"
+	"github.com/miekg/dns"
+)
+
+// testRcodeHandler answers with the rcode to the first requests, then
+// delegates to the lua handler
+func testRcodeHandler(rcode int, count int32, requests *int32) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		if atomic.AddInt32(requests, 1) > count {
+			testLuaHandler(w, r)
+			return
+		}
+		m := new(dns.Msg)
+		m.SetRcode(r, rcode)
+		m.SetTsig(testKeyName, r.IsTsig().Algorithm, 300, time.Now().Unix())
+		w.WriteMsg(m)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := retryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for attempt, expected := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		if got := p.delay(attempt); got != expected*time.Millisecond {
+			t.Errorf("attempt %d: expected %s, got %s", attempt, expected*time.Millisecond, got)
+		}
+	}
+
+	p.Jitter = true
+	for attempt := 0; attempt < 10; attempt++ {
+		got := p.delay(attempt)
+		max := retryPolicy{BaseDelay: p.BaseDelay, MaxDelay: p.MaxDelay}.delay(attempt)
+		if got < max/2 || got > max {
+			t.Errorf("attempt %d: expected a delay between %s and %s, got %s", attempt, max/2, max, got)
+		}
+	}
+}
+
+func TestClientRetryRcodes(t *testing.T) {
+	rrset := []interface{}{
+		map[string]interface{}{"rrtype": "TXT", "ttl": 30, "snippet": "os.date()"},
+	}
+
+	for name, tc := range map[string]struct {
+		rcode    int
+		rcodes   []int
+		requests int32
+		success  bool
+	}{
+		"servfail retried":    {dns.RcodeServerFailure, []int{dns.RcodeServerFailure}, 3, true},
+		"refused not retried": {dns.RcodeRefused, []int{dns.RcodeServerFailure}, 1, false},
+		"refused retried":     {dns.RcodeRefused, []int{dns.RcodeServerFailure, dns.RcodeRefused}, 3, true},
+	} {
+		var requests int32
+		port := testServer(t, map[string]string{testKeyName: testKeySecret}, nil, testRcodeHandler(tc.rcode, 2, &requests))
+
+		c, err := NewClient("127.0.0.1", port, "tcp", testKeyName, testKeySecret, testKeyAlgo, 2, nil)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		c.RetryPolicy = retryPolicy{BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond, Rcodes: tc.rcodes}
+
+		_, err = c.doUpdate(context.Background(), "testtls.test.internal.", rrset)
+		if (err == nil) != tc.success {
+			t.Errorf("%s: unexpected result %v", name, err)
+		}
+		if requests != tc.requests {
+			t.Errorf("%s: expected %d requests, got %d", name, tc.requests, requests)
+		}
+	}
+}
+
+func TestClientRetryContext(t *testing.T) {
+	var requests int32
+	port := testServer(t, map[string]string{testKeyName: testKeySecret}, nil, testRcodeHandler(dns.RcodeServerFailure, 100, &requests))
+
+	c, err := NewClient("127.0.0.1", port, "tcp", testKeyName, testKeySecret, testKeyAlgo, 100, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	c.RetryPolicy.BaseDelay = time.Hour
+	c.RetryPolicy.MaxDelay = time.Hour
+
+	// the context is cancelled during the delay before the second attempt
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = c.doDelete(ctx, "testtls.test.internal.")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the retries were not interrupted, elapsed %s", elapsed)
+	}
+	if requests != 1 {
+		t.Errorf("expected 1 request, got %d", requests)
+	}
+}
+
+func TestClientReadTimeout(t *testing.T) {
+	// the handler is released before the server shutdown
+	blocked := make(chan struct{})
+	port := testServer(t, map[string]string{testKeyName: testKeySecret}, nil, func(w dns.ResponseWriter, r *dns.Msg) {
+		<-blocked
+	})
+	t.Cleanup(func() { close(blocked) })
+
+	c, err := NewClient("127.0.0.1", port, "tcp", testKeyName, testKeySecret, testKeyAlgo, 0, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	c.DNSClient.ReadTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	if _, err := c.doDelete(context.Background(), "testtls.test.internal."); !errors.Is(err, errTimeout) || !strings.Contains(err.Error(), "read") {
+		t.Errorf("update expected a read timeout, got %v", err)
+	}
+	if _, _, err := c.doTransfer(context.Background(), "testtls.test.internal."); !errors.Is(err, errTimeout) || !strings.Contains(err.Error(), "read") {
+		t.Errorf("axfr expected a read timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the read timeout was not applied, elapsed %s", elapsed)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	rrset := []interface{}{
+		map[string]interface{}{"rrtype": "TXT", "ttl": 30, "snippet": "os.date()"},
+	}
+
+	for name, tc := range map[string]struct {
+		rcode    int
+		expected error
+	}{
+		"notauth": {dns.RcodeNotAuth, errNotAuth},
+		"refused": {dns.RcodeRefused, errRefused},
+		"notzone": {dns.RcodeNotZone, errNotZone},
+		"yxrrset": {dns.RcodeYXRrset, errYXRRSet},
+		"nxrrset": {dns.RcodeNXRrset, errNXRRSet},
+	} {
+		var requests int32
+		port := testServer(t, map[string]string{testKeyName: testKeySecret}, nil, testRcodeHandler(tc.rcode, 100, &requests))
+
+		c, err := NewClient("127.0.0.1", port, "tcp", testKeyName, testKeySecret, testKeyAlgo, 0, nil)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if _, err := c.doCreate(context.Background(), "testtls.test.internal.", rrset, false); !errors.Is(err, tc.expected) {
+			t.Errorf("%s: update expected %v, got %v", name, tc.expected, err)
+		}
+		if _, _, err := c.doTransfer(context.Background(), "testtls.test.internal."); !errors.Is(err, tc.expected) {
+			t.Errorf("%s: axfr expected %v, got %v", name, tc.expected, err)
+		}
+	}
+
+	// nothing listens on the port
+	c, err := NewClient("127.0.0.1", testClosedPort(t), "tcp", testKeyName, testKeySecret, testKeyAlgo, 0, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := c.doDelete(context.Background(), "testtls.test.internal."); !errors.Is(err, errConnRefused) {
+		t.Errorf("update expected %v, got %v", errConnRefused, err)
+	}
+	if _, _, err := c.doTransfer(context.Background(), "testtls.test.internal."); !errors.Is(err, errConnRefused) {
+		t.Errorf("axfr expected %v, got %v", errConnRefused, err)
+	}
+}
+
+func TestClientCreateReplyLost(t *testing.T) {
+	srv, c := testFakeServer(t)
+	ctx := context.Background()
+	rrset := []interface{}{
+		map[string]interface{}{"rrtype": "A", "ttl": 30, "snippet": "'192.168.1.1'"},
+	}
+
+	// the first create is applied but its reply is lost, the retry fails on
+	// the prerequisite with the rrset just created
+	c.DNSClient.ReadTimeout = 50 * time.Millisecond
+	srv.InjectFault(fakepdns.Fault{Operation: fakepdns.OpUpdate, Delay: time.Second, Count: 1})
+	if _, err := c.doCreate(ctx, "testlost.test.internal.", rrset, false); err != nil {
+		t.Fatalf("create with a lost reply: %s", err)
+	}
+	if requests := srv.Requests(fakepdns.OpUpdate); requests != 2 {
+		t.Errorf("expected 2 update requests, got %d", requests)
+	}
+	if got := testFakeSnippets(t, srv, "testlost.test.internal."); !reflect.DeepEqual(got, []string{"A '192.168.1.1'"}) {
+		t.Errorf("unexpected records %q", got)
+	}
+
+	// another rrset of the name is still an error
+	srv.InjectFault(fakepdns.Fault{Operation: fakepdns.OpUpdate, Delay: time.Second, Count: 1})
+	if err := srv.AddRecord("testother.test.internal. 30 IN TYPE65402 \\# 12 0010 09 6f732e74696d652829"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := c.doCreate(ctx, "testother.test.internal.", rrset, false); !errors.Is(err, errYXRRSet) {
+		t.Errorf("expected a YXRRSET error, got %v", err)
+	}
+}
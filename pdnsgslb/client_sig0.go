@@ -0,0 +1,82 @@
+package pdnsgslb
+
+import (
+	"crypto"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// useSig0 switches the client to the SIG(0) authentication mode (RFC 2931),
+// publickey is the KEY record and privatekey the private key file content
+// as generated by dnssec-keygen.
+func (c *Client) useSig0(publickey string, privatekey string) error {
+	rr, err := dns.ReadRR(strings.NewReader(publickey), "")
+	if err != nil {
+		return fmt.Errorf("Error parsing SIG(0) public key: %w", err)
+	}
+
+	// dnssec-keygen writes a DNSKEY record without the -T KEY option
+	var key *dns.KEY
+	switch k := rr.(type) {
+	case *dns.KEY:
+		key = k
+	case *dns.DNSKEY:
+		key = &dns.KEY{DNSKEY: *k}
+	default:
+		return fmt.Errorf("Error SIG(0) public key is not a KEY record: %s", rr)
+	}
+
+	privkey, err := key.ReadPrivateKey(strings.NewReader(privatekey), "")
+	if err != nil {
+		return fmt.Errorf("Error parsing SIG(0) private key: %w", err)
+	}
+	signer, ok := privkey.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("Error SIG(0) private key can not be used to sign")
+	}
+
+	c.AuthMode = authModeSig0
+	c.Sig0Key = key
+	c.Sig0Signer = signer
+
+	return nil
+}
+
+// signSig0 returns the wire format of the message signed with the SIG(0) key
+func (c *Client) signSig0(dnsmsg *dns.Msg) ([]byte, error) {
+	now := time.Now().Unix()
+
+	sig := new(dns.SIG)
+	sig.Algorithm = c.Sig0Key.Algorithm
+	sig.KeyTag = c.Sig0Key.KeyTag()
+	sig.SignerName = c.Sig0Key.Hdr.Name
+	sig.Inception = uint32(now - 300)
+	sig.Expiration = uint32(now + 300)
+
+	buf, err := sig.Sign(c.Sig0Signer, dnsmsg)
+	if err != nil {
+		return nil, fmt.Errorf("Error signing with SIG(0) key: %w", err)
+	}
+	return buf, nil
+}
+
+// readSig0KeyFile reads the key pair generated by dnssec-keygen, the path of
+// either the public .key file or the .private file can be given.
+func readSig0KeyFile(path string) (string, string, error) {
+	basename := strings.TrimSuffix(strings.TrimSuffix(path, ".key"), ".private")
+
+	publickey, err := os.ReadFile(basename + ".key")
+	if err != nil {
+		return "", "", fmt.Errorf("Error reading SIG(0) public key: %w", err)
+	}
+	privatekey, err := os.ReadFile(basename + ".private")
+	if err != nil {
+		return "", "", fmt.Errorf("Error reading SIG(0) private key: %w", err)
+	}
+
+	return string(publickey), string(privatekey), nil
+}
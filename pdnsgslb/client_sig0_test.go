@@ -0,0 +1,131 @@
+package pdnsgslb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// testSig0Key generates an ECDSA SIG(0) key pair in the dnssec-keygen format
+func testSig0Key(t *testing.T, name string) (*dns.KEY, string, string) {
+	key := &dns.KEY{
+		DNSKEY: dns.DNSKEY{
+			Hdr:       dns.RR_Header{Name: name, Rrtype: dns.TypeKEY, Class: dns.ClassINET},
+			Flags:     512,
+			Protocol:  3,
+			Algorithm: dns.ECDSAP256SHA256,
+		},
+	}
+	privkey, err := key.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, key.String(), key.PrivateKeyString(privkey)
+}
+
+// testSig0Handler answers updates and zone transfers signed with the key
+func testSig0Handler(key *dns.KEY) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+
+		// the signature is the last record of the request
+		sig, ok := r.Extra[len(r.Extra)-1].(*dns.SIG)
+		buf, _ := r.Pack()
+		if !ok || sig.Verify(key, buf) != nil || !sig.ValidityPeriod(time.Now()) {
+			m.Rcode = dns.RcodeNotAuth
+			w.WriteMsg(m)
+			return
+		}
+
+		if r.Question[0].Qtype == dns.TypeAXFR {
+			soa, _ := dns.NewRR("test.internal. 3600 IN SOA ns1.test.internal. hostmaster.test.internal. 1 10800 3600 604800 3600")
+			lua, _ := dns.NewRR("testsig0.test.internal. 30 IN TYPE65402 \\# 12 0010 09 6f732e646174652829")
+			m.Answer = []dns.RR{soa, lua, soa}
+		}
+		w.WriteMsg(m)
+	}
+}
+
+func TestClientSig0(t *testing.T) {
+	key, publickey, privatekey := testSig0Key(t, "pipeline.test.internal.")
+	port := testServer(t, nil, nil, testSig0Handler(key))
+
+	for _, transport := range []string{"tcp", "tcp4"} {
+		c, err := NewClient("127.0.0.1", port, transport, "", "", "", 0, nil)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := c.useSig0(publickey, privatekey); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		rrset := []interface{}{
+			map[string]interface{}{"rrtype": "TXT", "ttl": 30, "snippet": "os.date()"},
+		}
+		if _, err := c.doCreate(context.Background(), "testsig0.test.internal.", rrset, false); err != nil {
+			t.Fatalf("%s: signed update: %s", transport, err)
+		}
+
+		rr_lua, _, err := c.doTransfer(context.Background(), "testsig0.test.internal.")
+		if err != nil {
+			t.Fatalf("%s: signed axfr: %s", transport, err)
+		}
+		if len(rr_lua) != 1 {
+			t.Fatalf("%s: expected 1 LUA record, got %d", transport, len(rr_lua))
+		}
+	}
+}
+
+func TestClientSig0WrongKey(t *testing.T) {
+	key, _, _ := testSig0Key(t, "pipeline.test.internal.")
+	_, publickey, privatekey := testSig0Key(t, "pipeline.test.internal.")
+	port := testServer(t, nil, nil, testSig0Handler(key))
+
+	c, err := NewClient("127.0.0.1", port, "tcp", "", "", "", 0, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := c.useSig0(publickey, privatekey); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := c.doDelete(context.Background(), "testsig0.test.internal."); err == nil {
+		t.Fatal("expected the server to refuse the signature")
+	}
+}
+
+func TestReadSig0KeyFile(t *testing.T) {
+	_, publickey, privatekey := testSig0Key(t, "pipeline.test.internal.")
+
+	basename := filepath.Join(t.TempDir(), "Kpipeline.test.internal.+013+12345")
+	if err := os.WriteFile(basename+".key", []byte("; This is a key\n"+publickey+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(basename+".private", []byte(privatekey), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{basename + ".key", basename + ".private"} {
+		pub, priv, err := readSig0KeyFile(path)
+		if err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+
+		c := &Client{}
+		if err := c.useSig0(pub, priv); err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+		if c.AuthMode != authModeSig0 || c.Sig0Key.Hdr.Name != "pipeline.test.internal." {
+			t.Errorf("%s: unexpected key %s", path, c.Sig0Key)
+		}
+	}
+
+	if _, _, err := readSig0KeyFile(filepath.Join(t.TempDir(), "Kmissing.key")); err == nil {
+		t.Error("expected an error for a missing key file")
+	}
+}
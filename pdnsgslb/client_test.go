@@ -0,0 +1,500 @@
+package pdnsgslb
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bodgit/tsig"
+	"github.com/miekg/dns"
+)
+
+const (
+	testKeyName   = "keytest."
+	testKeyAlgo   = "hmac-sha256"
+	testKeySecret = "i4Yx6bmTJBRVLWub97qJqull3xZVIak4wz5P4x5HudIqnQ9X56x7befQAvqgGEdk5LOD0vqwomiZZb+OmTvTQQ=="
+)
+
+// testTLSCertificate generates a self-signed certificate for 127.0.0.1
+// and writes it in PEM format to a file usable as CA bundle.
+func testTLSCertificate(t *testing.T) (tls.Certificate, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "pdns.test.internal"},
+		DNSNames:              []string{"pdns.test.internal"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cafile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(cafile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, cafile
+}
+
+// testServer starts a DNS server on 127.0.0.1, over tls when a certificate
+// is provided, and returns its port
+func testServer(t *testing.T, tsigsecret map[string]string, cert *tls.Certificate, handler dns.HandlerFunc) int {
+	var provider dns.TsigProvider
+	if tsigsecret != nil {
+		provider = tsig.HMAC(tsigsecret)
+	}
+	return testServerWithProvider(t, provider, cert, handler)
+}
+
+// testServerWithProvider starts a DNS server verifying and signing the TSIG
+// records with the given provider
+func testServerWithProvider(t *testing.T, provider dns.TsigProvider, cert *tls.Certificate, handler dns.HandlerFunc) int {
+	var listener net.Listener
+	var err error
+	if cert != nil {
+		listener, err = tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{*cert}})
+	} else {
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &dns.Server{
+		Listener:     listener,
+		Handler:      handler,
+		TsigProvider: provider,
+		// dns updates are rejected by the default accept function
+		MsgAcceptFunc: func(dh dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+	}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// testLuaHandler answers signed updates and zone transfers of test.internal.
+// with one LUA record
+func testLuaHandler(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	if r.IsTsig() == nil || w.TsigStatus() != nil {
+		m.Rcode = dns.RcodeNotAuth
+		w.WriteMsg(m)
+		return
+	}
+
+	if r.Question[0].Qtype == dns.TypeAXFR {
+		soa, _ := dns.NewRR("test.internal. 3600 IN SOA ns1.test.internal. hostmaster.test.internal. 1 10800 3600 604800 3600")
+		lua, _ := dns.NewRR("testtls.test.internal. 30 IN TYPE65402 \\# 12 0010 09 6f732e646174652829")
+		m.Answer = []dns.RR{soa, lua, soa}
+	}
+	m.SetTsig(testKeyName, r.IsTsig().Algorithm, 300, time.Now().Unix())
+	w.WriteMsg(m)
+}
+
+// testTsigErrorHandler answers with an unsigned NOTAUTH carrying the tsig error
+func testTsigErrorHandler(tsigerror uint16, otherdata string) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Rcode = dns.RcodeNotAuth
+
+		t := r.IsTsig()
+		m.Extra = append(m.Extra, &dns.TSIG{
+			Hdr:        dns.RR_Header{Name: t.Hdr.Name, Rrtype: dns.TypeTSIG, Class: dns.ClassANY},
+			Algorithm:  t.Algorithm,
+			TimeSigned: t.TimeSigned,
+			Fudge:      t.Fudge,
+			OrigId:     r.Id,
+			Error:      tsigerror,
+			OtherLen:   uint16(len(otherdata) / 2),
+			OtherData:  otherdata,
+		})
+		data, _ := m.Pack()
+		w.Write(data)
+	}
+}
+
+func TestClientTLS(t *testing.T) {
+	cert, cafile := testTLSCertificate(t)
+	port := testServer(t, map[string]string{testKeyName: testKeySecret}, &cert, testLuaHandler)
+
+	tlsconfig, err := newTLSConfig(cafile, "", "", "pdns.test.internal", "1.2")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	c, err := NewClient("127.0.0.1", port, "tcp-tls", testKeyName, testKeySecret, testKeyAlgo, 0, tlsconfig)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	rrset := []interface{}{
+		map[string]interface{}{"rrtype": "TXT", "ttl": 30, "snippet": "os.date()"},
+	}
+	if _, err := c.doCreate(context.Background(), "testtls.test.internal.", rrset, false); err != nil {
+		t.Fatalf("update over tls: %s", err)
+	}
+
+	rr_lua, _, err := c.doTransfer(context.Background(), "testtls.test.internal.")
+	if err != nil {
+		t.Fatalf("axfr over tls: %s", err)
+	}
+	if len(rr_lua) != 1 {
+		t.Fatalf("expected 1 LUA record, got %d", len(rr_lua))
+	}
+}
+
+func TestClientTLSUnknownAuthority(t *testing.T) {
+	cert, _ := testTLSCertificate(t)
+	port := testServer(t, map[string]string{testKeyName: testKeySecret}, &cert, testLuaHandler)
+
+	// the system pool does not know the self-signed certificate
+	tlsconfig, err := newTLSConfig("", "", "", "", "1.2")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	c, err := NewClient("127.0.0.1", port, "tcp-tls", testKeyName, testKeySecret, testKeyAlgo, 0, tlsconfig)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, _, err := c.doTransfer(context.Background(), "testtls.test.internal."); err == nil {
+		t.Fatal("expected a certificate verification error")
+	}
+}
+
+func TestClientTsigAlgorithms(t *testing.T) {
+	port := testServer(t, map[string]string{testKeyName: testKeySecret}, nil, testLuaHandler)
+
+	for _, algo := range []string{"hmac-md5", "hmac-sha1", "hmac-sha224", "hmac-sha256", "hmac-sha384", "hmac-sha512"} {
+		c, err := NewClient("127.0.0.1", port, "tcp", testKeyName, testKeySecret, algo, 0, nil)
+		if err != nil {
+			t.Fatalf("%s: %s", algo, err)
+		}
+
+		rrset := []interface{}{
+			map[string]interface{}{"rrtype": "TXT", "ttl": 30, "snippet": "os.date()"},
+		}
+		if _, err := c.doUpdate(context.Background(), "testtls.test.internal.", rrset); err != nil {
+			t.Errorf("%s: signed update: %s", algo, err)
+		}
+		if _, _, err := c.doTransfer(context.Background(), "testtls.test.internal."); err != nil {
+			t.Errorf("%s: signed axfr: %s", algo, err)
+		}
+	}
+}
+
+func TestTransferTransport(t *testing.T) {
+	for transport, expected := range map[string]string{
+		"udp":     "tcp",
+		"udp4":    "tcp4",
+		"udp6":    "tcp6",
+		"tcp":     "tcp",
+		"tcp6":    "tcp6",
+		"tcp-tls": "tcp-tls",
+	} {
+		if got := transferTransport(transport); got != expected {
+			t.Errorf("transport %s: expected %s, got %s", transport, expected, got)
+		}
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	if _, err := newTLSConfig("", "", "", "", "1.4"); err == nil {
+		t.Error("expected an error for an unknown tls version")
+	}
+	if _, err := newTLSConfig(filepath.Join(t.TempDir(), "missing.pem"), "", "", "", "1.2"); err == nil {
+		t.Error("expected an error for a missing CA bundle")
+	}
+
+	tlsconfig, err := newTLSConfig("", "", "", "pdns.test.internal", "1.3")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if tlsconfig.MinVersion != tls.VersionTLS13 || tlsconfig.ServerName != "pdns.test.internal" {
+		t.Errorf("unexpected tls config: %s %d", tlsconfig.ServerName, tlsconfig.MinVersion)
+	}
+}
+
+func TestClientVerify(t *testing.T) {
+	tsigsecret := map[string]string{testKeyName: testKeySecret}
+	servertime := fmt.Sprintf("%012x", time.Now().Unix()+3600)
+
+	// closed port for the unreachable server
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	// the query is refused without signing the reply
+	refused := func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeRefused)
+		w.WriteMsg(m)
+	}
+
+	for name, tc := range map[string]struct {
+		port     int
+		expected error
+	}{
+		"success":        {testServer(t, tsigsecret, nil, testLuaHandler), nil},
+		"unreachable":    {closed, errServerUnreachable},
+		"unknown key":    {testServer(t, tsigsecret, nil, testTsigErrorHandler(dns.RcodeBadKey, "")), errTsigUnknownKey},
+		"bad sig":        {testServer(t, tsigsecret, nil, testTsigErrorHandler(dns.RcodeBadSig, "")), errTsigBadSignature},
+		"clock skew":     {testServer(t, tsigsecret, nil, testTsigErrorHandler(dns.RcodeBadTime, servertime)), errTsigClockSkew},
+		"not auth":       {testServer(t, map[string]string{"otherkey.": testKeySecret}, nil, testLuaHandler), errTsigUnknownKey},
+		"refused":        {testServer(t, tsigsecret, nil, refused), errTsigBadSignature},
+		"signed refused": {testServer(t, tsigsecret, nil, testRcodeHandler(dns.RcodeRefused, 1, new(int32))), nil},
+	} {
+		c, err := NewClient("127.0.0.1", tc.port, "tcp", testKeyName, testKeySecret, testKeyAlgo, 0, nil)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		err = c.doVerify(context.Background())
+		if !errors.Is(err, tc.expected) {
+			t.Errorf("%s: expected %v, got %v", name, tc.expected, err)
+		}
+	}
+}
+
+func TestTsigServerTime(t *testing.T) {
+	tsig := &dns.TSIG{TimeSigned: 10, OtherData: "0000659f1e00"}
+	if got := tsigServerTime(tsig); got != 0x659f1e00 {
+		t.Errorf("expected server time from other data, got %d", got)
+	}
+
+	tsig = &dns.TSIG{TimeSigned: 10}
+	if got := tsigServerTime(tsig); got != 10 {
+		t.Errorf("expected signing time, got %d", got)
+	}
+}
+
+func TestClientTsigErrors(t *testing.T) {
+	tsigsecret := map[string]string{testKeyName: testKeySecret}
+	servertime := fmt.Sprintf("%012x", time.Now().Unix()+3600)
+
+	// the server signs its replies with another secret
+	badsecret := map[string]string{testKeyName: "c2VjcmV0"}
+	badsigner := func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.SetTsig(testKeyName, dns.HmacSHA256, 300, time.Now().Unix())
+		w.WriteMsg(m)
+	}
+
+	// the server does not sign its replies
+	unsigned := func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		w.WriteMsg(m)
+	}
+
+	rrset := []interface{}{
+		map[string]interface{}{"rrtype": "TXT", "ttl": 30, "snippet": "os.date()"},
+	}
+
+	for name, tc := range map[string]struct {
+		port     int
+		expected error
+	}{
+		"bad key":      {testServer(t, tsigsecret, nil, testTsigErrorHandler(dns.RcodeBadKey, "")), errTsigUnknownKey},
+		"bad sig":      {testServer(t, tsigsecret, nil, testTsigErrorHandler(dns.RcodeBadSig, "")), errTsigBadSignature},
+		"bad time":     {testServer(t, tsigsecret, nil, testTsigErrorHandler(dns.RcodeBadTime, servertime)), errTsigClockSkew},
+		"bad reply":    {testServer(t, badsecret, nil, badsigner), errTsigBadSignature},
+		"unsigned":     {testServer(t, tsigsecret, nil, unsigned), errTsigBadSignature},
+		"unknown name": {testServer(t, badsecret, nil, testLuaHandler), errTsigUnknownKey},
+	} {
+		c, err := NewClient("127.0.0.1", tc.port, "tcp", testKeyName, testKeySecret, testKeyAlgo, 0, nil)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if _, err := c.doCreate(context.Background(), "testtsig.test.internal.", rrset, false); !errors.Is(err, tc.expected) {
+			t.Errorf("%s: update expected %v, got %v", name, tc.expected, err)
+		}
+		if _, _, err := c.doTransfer(context.Background(), "testtsig.test.internal."); !errors.Is(err, tc.expected) {
+			t.Errorf("%s: axfr expected %v, got %v", name, tc.expected, err)
+		}
+	}
+}
+
+func TestClientTsigClockDelta(t *testing.T) {
+	servertime := fmt.Sprintf("%012x", time.Now().Unix()+3600)
+	port := testServer(t, map[string]string{testKeyName: testKeySecret}, nil, testTsigErrorHandler(dns.RcodeBadTime, servertime))
+
+	c, err := NewClient("127.0.0.1", port, "tcp", testKeyName, testKeySecret, testKeyAlgo, 0, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	_, err = c.doDelete(context.Background(), "testtsig.test.internal.")
+	if err == nil || !regexp.MustCompile(`clock delta with the server is 3[56]\d\ds`).MatchString(err.Error()) {
+		t.Errorf("expected the clock delta in the error, got %v", err)
+	}
+}
+
+// testUnsignedTransferHandler answers a zone transfer with a signed first
+// message, unsigned messages of a LUA record and a last message signed or not
+func testUnsignedTransferHandler(unsigned int, signedLast bool) dns.HandlerFunc {
+	provider := tsig.HMAC{testKeyName: testKeySecret}
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		soa, _ := dns.NewRR("test.internal. 3600 IN SOA ns1.test.internal. hostmaster.test.internal. 1 10800 3600 604800 3600")
+		lua, _ := dns.NewRR("testtls.test.internal. 30 IN TYPE65402 \\# 12 0010 09 6f732e646174652829")
+
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = []dns.RR{soa}
+		m.SetTsig(testKeyName, r.IsTsig().Algorithm, 300, time.Now().Unix())
+		buf, mac, _ := dns.TsigGenerateWithProvider(m, provider, r.IsTsig().MAC, false)
+		w.Write(buf)
+
+		for i := 0; i < unsigned; i++ {
+			m = new(dns.Msg)
+			m.SetReply(r)
+			m.Answer = []dns.RR{lua}
+			buf, _ = m.Pack()
+			w.Write(buf)
+		}
+
+		m = new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = []dns.RR{soa}
+		if signedLast {
+			m.SetTsig(testKeyName, r.IsTsig().Algorithm, 300, time.Now().Unix())
+			buf, _, _ = dns.TsigGenerateWithProvider(m, provider, mac, false)
+		} else {
+			buf, _ = m.Pack()
+		}
+		w.Write(buf)
+	}
+}
+
+func TestClientTransferUnsignedMessages(t *testing.T) {
+	tsigsecret := map[string]string{testKeyName: testKeySecret}
+
+	for name, tc := range map[string]struct {
+		unsigned   int
+		signedLast bool
+		expected   error
+	}{
+		"unsigned messages":     {3, true, nil},
+		"99 unsigned messages":  {99, true, nil},
+		"100 unsigned messages": {100, true, errTsigBadSignature},
+		"unsigned last message": {3, false, errTsigBadSignature},
+	} {
+		port := testServer(t, tsigsecret, nil, testUnsignedTransferHandler(tc.unsigned, tc.signedLast))
+		c, err := NewClient("127.0.0.1", port, "tcp", testKeyName, testKeySecret, testKeyAlgo, 0, nil)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		lua_records, _, err := c.doTransfer(context.Background(), "testtls.test.internal.")
+		if !errors.Is(err, tc.expected) {
+			t.Errorf("%s: expected %v, got %v", name, tc.expected, err)
+			continue
+		}
+		if err == nil && len(lua_records) != tc.unsigned {
+			t.Errorf("%s: expected %d records, got %d", name, tc.unsigned, len(lua_records))
+		}
+	}
+}
+
+// the LUA type is not registered in the dns package, the transfers read the
+// LUA records as generic records whatever their rdata
+func TestClientLuaGenericRecords(t *testing.T) {
+	if _, ok := dns.TypeToRR[TYPE_LUA]; ok {
+		t.Fatal("LUA type registered in the dns package")
+	}
+
+	srv, c := testFakeServer(t)
+	ctx := context.Background()
+	for _, record := range []string{
+		testLuaRecord("testlua.test.internal.", 30, "A", "pickrandom({'127.0.0.1'})"),
+		// two character-strings, a byte after the character-string
+		"testmulti.test.internal. 30 IN TYPE65402 \\# 10 0010 03616263 03646566",
+		"testtrailing.test.internal. 30 IN TYPE65402 \\# 7 0010 03616263 00",
+	} {
+		if err := srv.AddRecord(record); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	records, err := ListRecords(ctx, c, "test.internal.")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(records) != 3 {
+		t.Errorf("expected 3 records, got %v", records)
+	}
+	records, err = GetRecords(ctx, c, "testlua.test.internal.", "")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := []Record{{"testlua.test.internal.", "A", 30, "pickrandom({'127.0.0.1'})"}}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("expected %v, got %v", expected, records)
+	}
+}
+
+// the length of a snippet is a single byte of the rdata
+func TestClientSnippetLength(t *testing.T) {
+	srv, c := testFakeServer(t)
+	ctx := context.Background()
+	rrset := []interface{}{map[string]interface{}{"rrtype": "TXT", "ttl": 30, "snippet": "'" + strings.Repeat("a", 254) + "'"}}
+
+	if _, err := c.doCreate(ctx, "testlength.test.internal.", rrset, false); err == nil || !strings.Contains(err.Error(), "longer than 255 bytes") {
+		t.Errorf("create: expected a length error, got %v", err)
+	}
+	if _, err := c.doUpdate(ctx, "testlength.test.internal.", rrset); err == nil || !strings.Contains(err.Error(), "longer than 255 bytes") {
+		t.Errorf("update: expected a length error, got %v", err)
+	}
+	if got := srv.Records("testlength.test.internal.", dns.TypeANY); len(got) != 0 {
+		t.Errorf("expected no record, got %v", got)
+	}
+
+	rrset[0].(map[string]interface{})["snippet"] = strings.Repeat("a", 255)
+	if _, err := c.doCreate(ctx, "testlength.test.internal.", rrset, false); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	records, err := GetRecords(ctx, c, "testlength.test.internal.", "")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(records) != 1 || records[0].Snippet != strings.Repeat("a", 255) {
+		t.Errorf("unexpected records %v", records)
+	}
+}
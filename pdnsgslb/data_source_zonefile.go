@@ -0,0 +1,141 @@
+package pdnsgslb
+
+import (
+	"context"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/miekg/dns"
+)
+
+// zoneFileResources are the attributes of the data source with the names of
+// each resource type
+var zoneFileResources = []struct {
+	resourceType string
+	attribute    string
+	resource     func() *schema.Resource
+}{
+	{"powerdns-gslb_ifportup", "ifportup", resourceIfPortUp},
+	{"powerdns-gslb_ifurlup", "ifurlup", resourceIfUrlUp},
+	{"powerdns-gslb_pickrandom", "pickrandom", resourcePickRandom},
+	{"powerdns-gslb_pickwrandom", "pickwrandom", resourcePickWrandom},
+	{"powerdns-gslb_lua", "lua", resourceLua},
+}
+
+func dataSourceZoneFile() *schema.Resource {
+	s := map[string]*schema.Schema{
+		"zone": {
+			Type:     schema.TypeString,
+			Required: true,
+		},
+		"content": {
+			Type:     schema.TypeString,
+			Required: true,
+		},
+		"records": {
+			Type:     schema.TypeList,
+			Computed: true,
+			Elem: &schema.Resource{
+				Schema: map[string]*schema.Schema{
+					"fqdn":    {Type: schema.TypeString, Computed: true},
+					"rrtype":  {Type: schema.TypeString, Computed: true},
+					"ttl":     {Type: schema.TypeInt, Computed: true},
+					"snippet": {Type: schema.TypeString, Computed: true},
+				},
+			},
+		},
+	}
+
+	// the names of each resource type, with the records of the resource
+	for _, r := range zoneFileResources {
+		record := r.resource().Schema["record"].Elem.(*schema.Resource)
+		s[r.attribute] = &schema.Schema{
+			Type:     schema.TypeList,
+			Computed: true,
+			Elem: &schema.Resource{
+				Schema: map[string]*schema.Schema{
+					"name": {Type: schema.TypeString, Computed: true},
+					"record": {
+						Type:     schema.TypeList,
+						Computed: true,
+						Elem:     &schema.Resource{Schema: computedSchema(record.Schema)},
+					},
+				},
+			},
+		}
+	}
+
+	return &schema.Resource{
+		ReadContext: dataSourceZoneFileRead,
+		Schema:      s,
+	}
+}
+
+func dataSourceZoneFileRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	zone := dns.Fqdn(d.Get("zone").(string))
+
+	records, err := ParseZoneFile(d.Get("content").(string), zone)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	var flat []interface{}
+	for _, r := range records {
+		flat = append(flat, map[string]interface{}{
+			"fqdn":    r.Name,
+			"rrtype":  r.Rrtype,
+			"ttl":     r.TTL,
+			"snippet": r.Snippet,
+		})
+	}
+	if err := d.Set("records", flat); err != nil {
+		return diag.FromErr(err)
+	}
+
+	// the names are typed as the export does, the apex and the names of
+	// several labels are only in the records
+	resources, _ := exportResources(zone, records)
+	byType := make(map[string][]interface{})
+	for _, r := range resources {
+		var rrset []interface{}
+		for _, record := range r.records {
+			rrset = append(rrset, record)
+		}
+		byType[r.resourceType] = append(byType[r.resourceType], map[string]interface{}{
+			"name":   r.name,
+			"record": rrset,
+		})
+	}
+	for _, r := range zoneFileResources {
+		if err := d.Set(r.attribute, byType[r.resourceType]); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	d.SetId(strings.TrimSuffix(zone, "."))
+	return nil
+}
+
+// computedSchema returns a copy of the schema of a resource with computed
+// attributes, for the same attributes in a data source
+func computedSchema(s map[string]*schema.Schema) map[string]*schema.Schema {
+	computed := make(map[string]*schema.Schema, len(s))
+	for k, v := range s {
+		attribute := &schema.Schema{
+			Type:     v.Type,
+			Computed: true,
+		}
+		switch elem := v.Elem.(type) {
+		case *schema.Resource:
+			attribute.Elem = &schema.Resource{Schema: computedSchema(elem.Schema)}
+		case *schema.Schema:
+			attribute.Elem = &schema.Schema{Type: elem.Type}
+		}
+		if attribute.Type == schema.TypeSet {
+			attribute.Type = schema.TypeList
+		}
+		computed[k] = attribute
+	}
+	return computed
+}
@@ -0,0 +1,229 @@
+package pdnsgslb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/miekg/dns"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// exportFunctions are the typed resources tried for the LUA records of a name,
+// the lua resource is used when no typed resource renders the same snippets
+var exportFunctions = []struct {
+	resourceType string
+	render       func([]interface{}) []interface{}
+	parse        func(string) (map[string]interface{}, error)
+}{
+	{"powerdns-gslb_ifportup", ifPortUpToLuaSnippet, ifPortUpFromLuaSnippet},
+	{"powerdns-gslb_ifurlup", ifUrlUpToLuaSnippet, ifUrlUpFromLuaSnippet},
+	{"powerdns-gslb_pickrandom", pickRandomToLuaSnippet, pickRandomFromLuaSnippet},
+	{"powerdns-gslb_pickwrandom", PickWrandomToLuaSnippet, pickWrandomFromLuaSnippet},
+}
+
+// exportedResource is a resource of the LUA records of a name
+type exportedResource struct {
+	resourceType string
+	label        string
+	zone         string
+	name         string
+	records      []map[string]interface{}
+}
+
+// ExportZone writes the LUA records of the zone as terraform resources with
+// their import blocks. Each name is exported with the typed resource of its
+// snippets when they are rendered the same by the resource, with the lua
+// resource otherwise.
+func ExportZone(ctx context.Context, c *Client, zone string, w io.Writer) error {
+	zone = dns.Fqdn(zone)
+	lua_records, _, err := c.doTransferZone(ctx, zone)
+	if err != nil {
+		return err
+	}
+
+	resources, skipped := exportResources(zone, toRecords(lua_records, ""))
+	for _, message := range skipped {
+		if _, err := fmt.Fprintf(w, "# %s\n", message); err != nil {
+			return err
+		}
+	}
+	if len(skipped) > 0 {
+		fmt.Fprintln(w)
+	}
+
+	_, err = w.Write(renderExport(resources))
+	return err
+}
+
+// exportResources returns the resources of the LUA records of the zone, and
+// the names which can not be managed by a resource
+func exportResources(zone string, lua_records []Record) ([]exportedResource, []string) {
+	// the records by name in the order of the transfer
+	var owners []string
+	byOwner := make(map[string][]Record)
+	for _, rr := range lua_records {
+		owner := dns.CanonicalName(rr.Name)
+		if _, ok := byOwner[owner]; !ok {
+			owners = append(owners, owner)
+		}
+		byOwner[owner] = append(byOwner[owner], rr)
+	}
+
+	var resources []exportedResource
+	var skipped []string
+	labels := make(map[string]bool)
+	for _, owner := range owners {
+		if dns.CountLabel(owner) != dns.CountLabel(zone)+1 {
+			skipped = append(skipped, fmt.Sprintf("%s not exported, the name of a resource is a single label of the zone %s", owner, zone))
+			continue
+		}
+
+		r := exportedResource{zone: zone, name: dns.SplitDomainName(owner)[0]}
+		r.resourceType, r.records = exportRecords(byOwner[owner])
+
+		// the resource labels are unique by type
+		r.label = resourceLabel(r.name)
+		for i := 2; labels[r.resourceType+"."+r.label]; i++ {
+			r.label = fmt.Sprintf("%s_%d", resourceLabel(r.name), i)
+		}
+		labels[r.resourceType+"."+r.label] = true
+
+		resources = append(resources, r)
+	}
+	return resources, skipped
+}
+
+// exportRecords returns the most specific resource type of the LUA records of
+// a name with the records of this resource
+func exportRecords(rrset []Record) (string, []map[string]interface{}) {
+	for _, function := range exportFunctions {
+		var records []map[string]interface{}
+		for _, rr := range rrset {
+			fields, err := function.parse(rr.Snippet)
+			if err != nil {
+				break
+			}
+			fields["rrtype"] = rr.Rrtype
+			fields["ttl"] = rr.TTL
+
+			// the resource must render the same snippet, it would be updated
+			// by the next apply otherwise
+			rendered := function.render([]interface{}{fields})
+			if rendered[0].(map[string]interface{})["snippet"] != rr.Snippet {
+				break
+			}
+			records = append(records, fields)
+		}
+		if len(records) == len(rrset) {
+			return function.resourceType, records
+		}
+	}
+
+	var records []map[string]interface{}
+	for _, rr := range rrset {
+		records = append(records, map[string]interface{}{
+			"rrtype":  rr.Rrtype,
+			"ttl":     rr.TTL,
+			"snippet": rr.Snippet,
+		})
+	}
+	return "powerdns-gslb_lua", records
+}
+
+// renderExport returns the HCL of the resources and of their import blocks
+func renderExport(resources []exportedResource) []byte {
+	f := hclwrite.NewEmptyFile()
+	body := f.Body()
+	for i, r := range resources {
+		if i > 0 {
+			body.AppendNewline()
+		}
+
+		block := body.AppendNewBlock("resource", []string{r.resourceType, r.label}).Body()
+		block.SetAttributeValue("zone", cty.StringVal(r.zone))
+		block.SetAttributeValue("name", cty.StringVal(r.name))
+		for _, record := range r.records {
+			appendRecordBlock(block.AppendNewBlock("record", nil).Body(), record)
+		}
+
+		body.AppendNewline()
+		importBlock := body.AppendNewBlock("import", nil).Body()
+		importBlock.SetAttributeTraversal("to", hcl.Traversal{
+			hcl.TraverseRoot{Name: r.resourceType},
+			hcl.TraverseAttr{Name: r.label},
+		})
+		importBlock.SetAttributeValue("id", cty.StringVal(strings.TrimSuffix(r.zone, ".")+"/"+r.name))
+	}
+	return f.Bytes()
+}
+
+// appendRecordBlock writes the fields of a record, the rrtype and the ttl
+// first, the lists of objects as nested blocks
+func appendRecordBlock(body *hclwrite.Body, fields map[string]interface{}) {
+	var keys, first []string
+	for k := range fields {
+		switch k {
+		case "rrtype", "ttl":
+			first = append(first, k)
+		default:
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(first)
+	sort.Strings(keys)
+	keys = append(first, keys...)
+
+	var blocks []string
+	for _, k := range keys {
+		switch value := fields[k].(type) {
+		case string:
+			body.SetAttributeValue(k, cty.StringVal(value))
+		case int:
+			body.SetAttributeValue(k, cty.NumberIntVal(int64(value)))
+		case []interface{}:
+			if len(value) > 0 {
+				if _, ok := value[0].(map[string]interface{}); ok {
+					blocks = append(blocks, k)
+					continue
+				}
+			}
+			body.SetAttributeValue(k, stringList(value))
+		}
+	}
+
+	for _, k := range blocks {
+		for _, v := range fields[k].([]interface{}) {
+			appendRecordBlock(body.AppendNewBlock(k, nil).Body(), v.(map[string]interface{}))
+		}
+	}
+}
+
+// stringList returns the cty list of strings, an empty list for no element
+func stringList(values []interface{}) cty.Value {
+	if len(values) == 0 {
+		return cty.ListValEmpty(cty.String)
+	}
+	var list []cty.Value
+	for _, v := range values {
+		list = append(list, cty.StringVal(fmt.Sprint(v)))
+	}
+	return cty.ListVal(list)
+}
+
+// resourceLabel returns a terraform identifier for the name of a record
+func resourceLabel(name string) string {
+	if name == "*" {
+		return "wildcard"
+	}
+	label := regexp.MustCompile(`[^a-zA-Z0-9_-]`).ReplaceAllString(name, "_")
+	if label == "" || (label[0] >= '0' && label[0] <= '9') || label[0] == '-' {
+		label = "r_" + label
+	}
+	return label
+}
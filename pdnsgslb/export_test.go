@@ -0,0 +1,127 @@
+package pdnsgslb
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/miekg/dns"
+)
+
+// testLuaRecord returns the presentation of a LUA record for the fake server
+func testLuaRecord(name string, ttl int, rrtype string, snippet string) string {
+	rdata := fmt.Sprintf("%04x%02x%s", dns.StringToType[rrtype], len(snippet), hex.EncodeToString([]byte(snippet)))
+	return fmt.Sprintf("%s %d IN TYPE%d \\# %d %s", name, ttl, TYPE_LUA, len(rdata)/2, rdata)
+}
+
+func TestExportZone(t *testing.T) {
+	srv, c := testFakeServer(t)
+	for _, record := range []string{
+		testLuaRecord("www.test.internal.", 30, "A", "ifportup(443, {'192.168.1.1','192.168.1.2'},{timeout=2})"),
+		testLuaRecord("www.test.internal.", 30, "AAAA", "ifportup(443, {'2001:db8::1'},{timeout=2})"),
+		testLuaRecord("app.test.internal.", 60, "A", "ifurlup('https://app.test.internal/', {{'10.0.0.1'}, {'10.0.0.2'} },{stringmatch='ok', timeout=5})"),
+		testLuaRecord("random.test.internal.", 30, "A", "pickrandom({'127.0.0.1','127.0.0.2'})"),
+		testLuaRecord("weighted.test.internal.", 30, "A", "pickwrandom({{10, '192.168.1.1'},{90, '192.168.1.2'}})"),
+		// a snippet of a function formatted by hand, and a snippet without function
+		testLuaRecord("spaced.test.internal.", 30, "A", "pickrandom({'127.0.0.1', '127.0.0.2'})"),
+		testLuaRecord("date.test.internal.", 30, "TXT", "os.date()"),
+		testLuaRecord("*.test.internal.", 30, "A", "pickrandom({'127.0.0.1'})"),
+		testLuaRecord("1st.test.internal.", 30, "A", "pickrandom({'127.0.0.1'})"),
+		// names which can not be exported
+		testLuaRecord("test.internal.", 30, "A", "pickrandom({'127.0.0.1'})"),
+		testLuaRecord("www.sub.test.internal.", 30, "A", "pickrandom({'127.0.0.1'})"),
+	} {
+		if err := srv.AddRecord(record); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := ExportZone(context.Background(), c, "test.internal", &out); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, diags := hclparse.NewParser().ParseHCL(out.Bytes(), "export.tf"); diags.HasErrors() {
+		t.Fatalf("invalid HCL: %s\n%s", diags, out.String())
+	}
+
+	path := filepath.Join("testdata", "export", "test.internal.tf")
+	if *updateGolden {
+		if err := os.WriteFile(path, out.Bytes(), 0644); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	golden, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if out.String() != string(golden) {
+		t.Errorf("unexpected export, got:\n%s\nexpected:\n%s", out.String(), golden)
+	}
+}
+
+func TestExportResourcesImport(t *testing.T) {
+	srv, c := testFakeServer(t)
+	for _, record := range []string{
+		testLuaRecord("www.test.internal.", 30, "A", "pickrandom({'127.0.0.1'})"),
+		testLuaRecord("www.test.internal.", 30, "TXT", "os.date()"),
+	} {
+		if err := srv.AddRecord(record); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	// the names with several functions are exported with the lua resource,
+	// the import of the resource must accept the records
+	lua_records, _, err := c.doTransferZone(context.Background(), "test.internal.")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	resources, _ := exportResources("test.internal.", toRecords(lua_records, ""))
+	if len(resources) != 1 || resources[0].resourceType != "powerdns-gslb_lua" {
+		t.Fatalf("unexpected resources %v", resources)
+	}
+
+	r := Provider().ResourcesMap[resources[0].resourceType]
+	d := r.TestResourceData()
+	d.SetId("test.internal/www")
+	if _, err := r.Importer.StateContext(context.Background(), d, c); err != nil {
+		t.Errorf("err: %s", err)
+	}
+}
+
+func TestConfigureClient(t *testing.T) {
+	srv, _ := testFakeServer(t)
+	if err := srv.AddRecord(testLuaRecord("www.test.internal.", 30, "A", "pickrandom({'127.0.0.1'})")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// the attributes not configured are read from the environment
+	t.Setenv("PDNSGLSB_DNSUPDATE_KEYSECRET", testKeySecret)
+	c, diags := ConfigureClient(context.Background(), map[string]interface{}{
+		"server":   "127.0.0.1",
+		"port":     srv.Port(),
+		"key_name": testKeyName,
+		"key_algo": testKeyAlgo,
+	})
+	if diags.HasError() {
+		t.Fatalf("configure: %v", diags)
+	}
+
+	var out bytes.Buffer
+	if err := ExportZone(context.Background(), c, "test.internal.", &out); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !bytes.Contains(out.Bytes(), []byte(`resource "powerdns-gslb_pickrandom" "www"`)) {
+		t.Errorf("unexpected export:\n%s", out.String())
+	}
+
+	if _, diags := ConfigureClient(context.Background(), map[string]interface{}{"transport": "sctp"}); !diags.HasError() {
+		t.Errorf("expected an error for an invalid transport")
+	}
+}
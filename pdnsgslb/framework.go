@@ -0,0 +1,110 @@
+package pdnsgslb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/function"
+	"github.com/hashicorp/terraform-plugin-framework/provider"
+	fwschema "github.com/hashicorp/terraform-plugin-framework/provider/schema"
+	"github.com/hashicorp/terraform-plugin-framework/providerserver"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+	"github.com/hashicorp/terraform-plugin-mux/tf5muxserver"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// ProviderServer returns the server of the provider, the resources and the
+// data sources of the sdk provider muxed with the functions of the framework
+// provider.
+func ProviderServer(ctx context.Context) (func() tfprotov5.ProviderServer, error) {
+	mux, err := tf5muxserver.NewMuxServer(ctx,
+		Provider().GRPCProvider,
+		providerserver.NewProtocol5(newFrameworkProvider()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return mux.ProviderServer, nil
+}
+
+// frameworkProvider serves the provider functions only, the configuration
+// is the one of the sdk provider and is not used by the functions
+type frameworkProvider struct{}
+
+var _ provider.ProviderWithFunctions = &frameworkProvider{}
+
+func newFrameworkProvider() provider.Provider {
+	return &frameworkProvider{}
+}
+
+func (p *frameworkProvider) Metadata(ctx context.Context, req provider.MetadataRequest, resp *provider.MetadataResponse) {
+	resp.TypeName = "powerdns-gslb"
+}
+
+// Schema is the schema of the sdk provider, the mux requires the same
+// provider schema on both servers
+func (p *frameworkProvider) Schema(ctx context.Context, req provider.SchemaRequest, resp *provider.SchemaResponse) {
+	attributes, err := frameworkAttributes(Provider().Schema)
+	if err != nil {
+		resp.Diagnostics.AddError("Unable to convert the provider schema", err.Error())
+		return
+	}
+	resp.Schema = fwschema.Schema{Attributes: attributes}
+}
+
+func (p *frameworkProvider) Configure(ctx context.Context, req provider.ConfigureRequest, resp *provider.ConfigureResponse) {
+}
+
+func (p *frameworkProvider) Resources(ctx context.Context) []func() resource.Resource {
+	return nil
+}
+
+func (p *frameworkProvider) DataSources(ctx context.Context) []func() datasource.DataSource {
+	return nil
+}
+
+func (p *frameworkProvider) Functions(ctx context.Context) []func() function.Function {
+	return []func() function.Function{
+		newIfPortUpFunction,
+		newIfUrlUpFunction,
+		newPickRandomFunction,
+		newPickWrandomFunction,
+	}
+}
+
+// frameworkAttributes returns the framework attributes of the attributes of
+// the sdk provider, as the sdk presents them to terraform
+func frameworkAttributes(s map[string]*schema.Schema) (map[string]fwschema.Attribute, error) {
+	attributes := make(map[string]fwschema.Attribute, len(s))
+	for name, v := range s {
+		// the attributes with a default are optional for terraform
+		optional := v.Optional || (v.Required && (v.Default != nil || v.DefaultFunc != nil))
+		required := v.Required && !optional
+		deprecation := v.Deprecated
+
+		switch v.Type {
+		case schema.TypeString:
+			attributes[name] = fwschema.StringAttribute{Required: required, Optional: optional, Sensitive: v.Sensitive, Description: v.Description, DeprecationMessage: deprecation}
+		case schema.TypeInt:
+			attributes[name] = fwschema.Int64Attribute{Required: required, Optional: optional, Sensitive: v.Sensitive, Description: v.Description, DeprecationMessage: deprecation}
+		case schema.TypeBool:
+			attributes[name] = fwschema.BoolAttribute{Required: required, Optional: optional, Sensitive: v.Sensitive, Description: v.Description, DeprecationMessage: deprecation}
+		case schema.TypeList, schema.TypeSet:
+			elem, ok := v.Elem.(*schema.Schema)
+			if !ok || elem.Type != schema.TypeString {
+				return nil, fmt.Errorf("unsupported elements of the attribute %s", name)
+			}
+			if v.Type == schema.TypeList {
+				attributes[name] = fwschema.ListAttribute{ElementType: types.StringType, Required: required, Optional: optional, Sensitive: v.Sensitive, Description: v.Description, DeprecationMessage: deprecation}
+			} else {
+				attributes[name] = fwschema.SetAttribute{ElementType: types.StringType, Required: required, Optional: optional, Sensitive: v.Sensitive, Description: v.Description, DeprecationMessage: deprecation}
+			}
+		default:
+			return nil, fmt.Errorf("unsupported type of the attribute %s", name)
+		}
+	}
+	return attributes, nil
+}
@@ -0,0 +1,348 @@
+package pdnsgslb
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/function"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+)
+
+// the provider functions return the snippets of the typed resources, rendered
+// by the same functions, for the records of the lua resource
+
+type ifPortUpFunction struct{}
+
+func newIfPortUpFunction() function.Function {
+	return &ifPortUpFunction{}
+}
+
+func (f *ifPortUpFunction) Metadata(ctx context.Context, req function.MetadataRequest, resp *function.MetadataResponse) {
+	resp.Name = "ifportup"
+}
+
+func (f *ifPortUpFunction) Definition(ctx context.Context, req function.DefinitionRequest, resp *function.DefinitionResponse) {
+	resp.Definition = function.Definition{
+		Summary:     "Returns an ifportup LUA snippet",
+		Description: "Returns the ifportup snippet of the powerdns-gslb_ifportup resource, the addresses answered when the port is up. The options are an object with an optional timeout, 5 seconds by default.",
+		Parameters: []function.Parameter{
+			function.Int64Parameter{Name: "port", Description: "The port checked"},
+			function.ListParameter{Name: "addresses", ElementType: types.StringType, Description: "The IP addresses"},
+			function.DynamicParameter{Name: "options", AllowNullValue: true, Description: "The options, {timeout = 5} or {}"},
+		},
+		Return: function.StringReturn{},
+	}
+}
+
+func (f *ifPortUpFunction) Run(ctx context.Context, req function.RunRequest, resp *function.RunResponse) {
+	var port int64
+	var addresses []string
+	var opts types.Dynamic
+	resp.Error = req.Arguments.Get(ctx, &port, &addresses, &opts)
+	if resp.Error != nil {
+		return
+	}
+
+	if port < 1 || port > 65535 {
+		resp.Error = function.NewArgumentFuncError(0, fmt.Sprintf("Error invalid port %d, expected a port between 1 and 65535", port))
+		return
+	}
+	if err := checkFunctionAddresses(addresses); err != nil {
+		resp.Error = function.NewArgumentFuncError(1, err.Error())
+		return
+	}
+	options, err := functionOptions(opts, map[string]interface{}{"timeout": 5})
+	if err != nil {
+		resp.Error = function.NewArgumentFuncError(2, err.Error())
+		return
+	}
+
+	snippet, err := renderSnippet(ifPortUpToLuaSnippet, map[string]interface{}{
+		"port":      int(port),
+		"addresses": toInterfaces(addresses),
+		"timeout":   options["timeout"],
+	})
+	if err != nil {
+		resp.Error = function.NewFuncError(err.Error())
+		return
+	}
+	resp.Error = resp.Result.Set(ctx, snippet)
+}
+
+type ifUrlUpFunction struct{}
+
+func newIfUrlUpFunction() function.Function {
+	return &ifUrlUpFunction{}
+}
+
+func (f *ifUrlUpFunction) Metadata(ctx context.Context, req function.MetadataRequest, resp *function.MetadataResponse) {
+	resp.Name = "ifurlup"
+}
+
+func (f *ifUrlUpFunction) Definition(ctx context.Context, req function.DefinitionRequest, resp *function.DefinitionResponse) {
+	resp.Definition = function.Definition{
+		Summary:     "Returns an ifurlup LUA snippet",
+		Description: "Returns the ifurlup snippet of the powerdns-gslb_ifurlup resource, the primary addresses answered when the url is up, the backup addresses otherwise. The options are an object with an optional stringmatch, empty by default, and an optional timeout, 5 seconds by default.",
+		Parameters: []function.Parameter{
+			function.StringParameter{Name: "url", Description: "The url checked"},
+			function.ListParameter{Name: "addresses", ElementType: types.ListType{ElemType: types.StringType}, Description: "The primary addresses and optionally the backup addresses, [primary] or [primary, backup]"},
+			function.DynamicParameter{Name: "options", AllowNullValue: true, Description: "The options, {stringmatch = \"ok\", timeout = 5} or {}"},
+		},
+		Return: function.StringReturn{},
+	}
+}
+
+func (f *ifUrlUpFunction) Run(ctx context.Context, req function.RunRequest, resp *function.RunResponse) {
+	var url string
+	var groups [][]string
+	var opts types.Dynamic
+	resp.Error = req.Arguments.Get(ctx, &url, &groups, &opts)
+	if resp.Error != nil {
+		return
+	}
+
+	if url == "" {
+		resp.Error = function.NewArgumentFuncError(0, "Error invalid empty url")
+		return
+	}
+	if len(groups) < 1 || len(groups) > 2 {
+		resp.Error = function.NewArgumentFuncError(1, "Error expected the primary addresses and optionally the backup addresses")
+		return
+	}
+	primary, backup := groups[0], []string{}
+	if len(groups) == 2 {
+		backup = groups[1]
+	}
+	if err := checkFunctionAddresses(append(append([]string{}, primary...), backup...)); err != nil {
+		resp.Error = function.NewArgumentFuncError(1, err.Error())
+		return
+	}
+	options, err := functionOptions(opts, map[string]interface{}{"stringmatch": "", "timeout": 5})
+	if err != nil {
+		resp.Error = function.NewArgumentFuncError(2, err.Error())
+		return
+	}
+
+	snippet, err := renderSnippet(ifUrlUpToLuaSnippet, map[string]interface{}{
+		"url":         url,
+		"stringmatch": options["stringmatch"],
+		"timeout":     options["timeout"],
+		"addresses": []interface{}{map[string]interface{}{
+			"primary": toInterfaces(primary),
+			"backup":  toInterfaces(backup),
+		}},
+	})
+	if err != nil {
+		resp.Error = function.NewFuncError(err.Error())
+		return
+	}
+	resp.Error = resp.Result.Set(ctx, snippet)
+}
+
+type pickRandomFunction struct{}
+
+func newPickRandomFunction() function.Function {
+	return &pickRandomFunction{}
+}
+
+func (f *pickRandomFunction) Metadata(ctx context.Context, req function.MetadataRequest, resp *function.MetadataResponse) {
+	resp.Name = "pickrandom"
+}
+
+func (f *pickRandomFunction) Definition(ctx context.Context, req function.DefinitionRequest, resp *function.DefinitionResponse) {
+	resp.Definition = function.Definition{
+		Summary:     "Returns a pickrandom LUA snippet",
+		Description: "Returns the pickrandom snippet of the powerdns-gslb_pickrandom resource, one of the addresses answered at random.",
+		Parameters: []function.Parameter{
+			function.ListParameter{Name: "addresses", ElementType: types.StringType, Description: "The IP addresses"},
+		},
+		Return: function.StringReturn{},
+	}
+}
+
+func (f *pickRandomFunction) Run(ctx context.Context, req function.RunRequest, resp *function.RunResponse) {
+	var addresses []string
+	resp.Error = req.Arguments.Get(ctx, &addresses)
+	if resp.Error != nil {
+		return
+	}
+
+	if err := checkFunctionAddresses(addresses); err != nil {
+		resp.Error = function.NewArgumentFuncError(0, err.Error())
+		return
+	}
+
+	snippet, err := renderSnippet(pickRandomToLuaSnippet, map[string]interface{}{
+		"addresses": toInterfaces(addresses),
+	})
+	if err != nil {
+		resp.Error = function.NewFuncError(err.Error())
+		return
+	}
+	resp.Error = resp.Result.Set(ctx, snippet)
+}
+
+type pickWrandomFunction struct{}
+
+func newPickWrandomFunction() function.Function {
+	return &pickWrandomFunction{}
+}
+
+func (f *pickWrandomFunction) Metadata(ctx context.Context, req function.MetadataRequest, resp *function.MetadataResponse) {
+	resp.Name = "pickwrandom"
+}
+
+func (f *pickWrandomFunction) Definition(ctx context.Context, req function.DefinitionRequest, resp *function.DefinitionResponse) {
+	resp.Definition = function.Definition{
+		Summary:     "Returns a pickwrandom LUA snippet",
+		Description: "Returns the pickwrandom snippet of the powerdns-gslb_pickwrandom resource, one of the addresses answered at random by weight.",
+		Parameters: []function.Parameter{
+			function.ListParameter{
+				Name: "weights",
+				ElementType: types.ObjectType{AttrTypes: map[string]attr.Type{
+					"weight": types.Int64Type,
+					"ip":     types.StringType,
+				}},
+				Description: "The weighted addresses, [{weight = 10, ip = \"192.168.1.1\"}, ...]",
+			},
+		},
+		Return: function.StringReturn{},
+	}
+}
+
+func (f *pickWrandomFunction) Run(ctx context.Context, req function.RunRequest, resp *function.RunResponse) {
+	var weights []struct {
+		Weight int64  `tfsdk:"weight"`
+		IP     string `tfsdk:"ip"`
+	}
+	resp.Error = req.Arguments.Get(ctx, &weights)
+	if resp.Error != nil {
+		return
+	}
+
+	var addresses []string
+	var ipaddress []interface{}
+	for _, w := range weights {
+		if w.Weight < 1 {
+			resp.Error = function.NewArgumentFuncError(0, fmt.Sprintf("Error invalid weight %d of %s, expected at least 1", w.Weight, w.IP))
+			return
+		}
+		addresses = append(addresses, w.IP)
+		ipaddress = append(ipaddress, map[string]interface{}{"weight": int(w.Weight), "ip": w.IP})
+	}
+	if err := checkFunctionAddresses(addresses); err != nil {
+		resp.Error = function.NewArgumentFuncError(0, err.Error())
+		return
+	}
+
+	snippet, err := renderSnippet(PickWrandomToLuaSnippet, map[string]interface{}{
+		"ipaddress": ipaddress,
+	})
+	if err != nil {
+		resp.Error = function.NewFuncError(err.Error())
+		return
+	}
+	resp.Error = resp.Result.Set(ctx, snippet)
+}
+
+// renderSnippet returns the snippet of a record of a typed resource, an error
+// when the snippet does not fit in a LUA record
+func renderSnippet(render func([]interface{}) []interface{}, fields map[string]interface{}) (string, error) {
+	record := map[string]interface{}{"rrtype": "", "ttl": 0}
+	for k, v := range fields {
+		record[k] = v
+	}
+	snippet := render([]interface{}{record})[0].(map[string]interface{})["snippet"].(string)
+	if err := checkSnippet(snippet); err != nil {
+		return "", err
+	}
+	return snippet, nil
+}
+
+// checkFunctionAddresses returns an error when there is no address, or an
+// address is invalid or duplicated
+func checkFunctionAddresses(addresses []string) error {
+	if len(addresses) == 0 {
+		return fmt.Errorf("Error expected at least one address")
+	}
+	for _, address := range addresses {
+		if address == "" {
+			return fmt.Errorf("Error invalid empty address")
+		}
+	}
+	if err := checkAddresses("", addresses); err != nil {
+		return fmt.Errorf("Error %w", err)
+	}
+	return nil
+}
+
+// functionOptions returns the options of an object or a map argument, with
+// the defaults of the options not set. The numbers are integers and the
+// other options strings.
+func functionOptions(opts types.Dynamic, defaults map[string]interface{}) (map[string]interface{}, error) {
+	options := make(map[string]interface{}, len(defaults))
+	for k, v := range defaults {
+		options[k] = v
+	}
+	if opts.IsNull() || opts.IsUnderlyingValueNull() {
+		return options, nil
+	}
+
+	var values map[string]attr.Value
+	switch v := opts.UnderlyingValue().(type) {
+	case basetypes.ObjectValue:
+		values = v.Attributes()
+	case basetypes.MapValue:
+		values = v.Elements()
+	default:
+		return nil, fmt.Errorf("Error expected an object of options, got %s", opts.UnderlyingValue().Type(context.Background()))
+	}
+
+	var names []string
+	for k := range defaults {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for k, v := range values {
+		def, ok := defaults[k]
+		if !ok {
+			return nil, fmt.Errorf("Error unknown option %s, expected %s", k, strings.Join(names, ", "))
+		}
+		if v.IsNull() {
+			continue
+		}
+		switch def.(type) {
+		case int:
+			n, ok := v.(basetypes.NumberValue)
+			if !ok {
+				return nil, fmt.Errorf("Error option %s must be a number", k)
+			}
+			i, accuracy := n.ValueBigFloat().Int64()
+			if accuracy != big.Exact || i < 1 {
+				return nil, fmt.Errorf("Error option %s must be a positive integer", k)
+			}
+			options[k] = int(i)
+		case string:
+			s, ok := v.(basetypes.StringValue)
+			if !ok {
+				return nil, fmt.Errorf("Error option %s must be a string", k)
+			}
+			options[k] = s.ValueString()
+		}
+	}
+	return options, nil
+}
+
+func toInterfaces(values []string) []interface{} {
+	list := []interface{}{}
+	for _, v := range values {
+		list = append(list, v)
+	}
+	return list
+}
@@ -0,0 +1,219 @@
+package pdnsgslb
+
+import (
+	"context"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/function"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+// testStrings returns a list of strings argument
+func testStrings(values ...string) types.List {
+	var elems []attr.Value
+	for _, v := range values {
+		elems = append(elems, types.StringValue(v))
+	}
+	return types.ListValueMust(types.StringType, elems)
+}
+
+// testOptions returns an options argument, an object of the attributes
+func testOptions(attributes map[string]attr.Value) types.Dynamic {
+	attrTypes := make(map[string]attr.Type)
+	for k, v := range attributes {
+		attrTypes[k] = v.Type(context.Background())
+	}
+	return types.DynamicValue(types.ObjectValueMust(attrTypes, attributes))
+}
+
+// testRunFunction returns the snippet of the function or its error
+func testRunFunction(f function.Function, args ...attr.Value) (string, error) {
+	resp := &function.RunResponse{Result: function.NewResultData(types.StringUnknown())}
+	f.Run(context.Background(), function.RunRequest{Arguments: function.NewArgumentsData(args)}, resp)
+	if resp.Error != nil {
+		return "", resp.Error
+	}
+	return resp.Result.Value().(types.String).ValueString(), nil
+}
+
+func TestFunctionsGolden(t *testing.T) {
+	weighted := func(weight int64, ip string) attr.Value {
+		return types.ObjectValueMust(
+			map[string]attr.Type{"weight": types.Int64Type, "ip": types.StringType},
+			map[string]attr.Value{"weight": types.Int64Value(weight), "ip": types.StringValue(ip)},
+		)
+	}
+	weightType := types.ObjectType{AttrTypes: map[string]attr.Type{"weight": types.Int64Type, "ip": types.StringType}}
+
+	// the functions render the snippets of the resources
+	for golden, call := range map[string]func() (string, error){
+		"ifportup_addresses": func() (string, error) {
+			return testRunFunction(newIfPortUpFunction(), types.Int64Value(443), testStrings("192.168.1.1", "192.168.1.2", "192.168.1.3"),
+				testOptions(map[string]attr.Value{"timeout": types.NumberValue(big.NewFloat(10))}))
+		},
+		"ifportup_ipv6": func() (string, error) {
+			return testRunFunction(newIfPortUpFunction(), types.Int64Value(65535), testStrings("2001:db8::1", "2001:db8::2"),
+				testOptions(map[string]attr.Value{"timeout": types.NumberValue(big.NewFloat(1))}))
+		},
+		"ifurlup_primary": func() (string, error) {
+			return testRunFunction(newIfUrlUpFunction(), types.StringValue("https://www.example.com/"),
+				types.ListValueMust(types.ListType{ElemType: types.StringType}, []attr.Value{testStrings("10.0.0.210", "10.0.0.211")}),
+				testOptions(map[string]attr.Value{"timeout": types.NumberValue(big.NewFloat(10))}))
+		},
+		"ifurlup_stringmatch": func() (string, error) {
+			return testRunFunction(newIfUrlUpFunction(), types.StringValue("http://www.example.com:8080/health?full=1"),
+				types.ListValueMust(types.ListType{ElemType: types.StringType}, []attr.Value{testStrings("2001:db8::1"), testStrings("2001:db8::2")}),
+				testOptions(map[string]attr.Value{"stringmatch": types.StringValue("status: ok")}))
+		},
+		"ifurlup_escapes": func() (string, error) {
+			return testRunFunction(newIfUrlUpFunction(), types.StringValue("https://www.example.com/it's"),
+				types.ListValueMust(types.ListType{ElemType: types.StringType}, []attr.Value{testStrings("10.0.0.210")}),
+				testOptions(map[string]attr.Value{"stringmatch": types.StringValue("a\\b\tc'")}))
+		},
+		"pickrandom_addresses": func() (string, error) {
+			return testRunFunction(newPickRandomFunction(), testStrings("127.0.0.1", "127.0.0.7", "127.0.0.8"))
+		},
+		"pickwrandom_addresses": func() (string, error) {
+			return testRunFunction(newPickWrandomFunction(), types.ListValueMust(weightType, []attr.Value{
+				weighted(10, "192.168.1.1"), weighted(100, "192.168.1.2"), weighted(1000, "192.168.1.3"),
+			}))
+		},
+	} {
+		snippet, err := call()
+		if err != nil {
+			t.Errorf("%s: %s", golden, err)
+			continue
+		}
+		expected, err := os.ReadFile(filepath.Join("testdata", "snippets", golden+".golden"))
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if snippet != strings.TrimSpace(string(expected)) {
+			t.Errorf("%s: expected %s, got %s", golden, expected, snippet)
+		}
+	}
+}
+
+func TestFunctionsErrors(t *testing.T) {
+	urls := types.ListValueMust(types.ListType{ElemType: types.StringType}, []attr.Value{testStrings("10.0.0.210")})
+	for name, tc := range map[string]struct {
+		f        function.Function
+		args     []attr.Value
+		expected string
+	}{
+		"port":          {newIfPortUpFunction(), []attr.Value{types.Int64Value(0), testStrings("192.168.1.1"), types.DynamicNull()}, "invalid port"},
+		"no address":    {newPickRandomFunction(), []attr.Value{testStrings()}, "at least one address"},
+		"address":       {newPickRandomFunction(), []attr.Value{testStrings("192.168.1")}, "invalid IP address"},
+		"duplicated":    {newPickRandomFunction(), []attr.Value{testStrings("::1", "0::1")}, "duplicated"},
+		"unknown":       {newIfPortUpFunction(), []attr.Value{types.Int64Value(443), testStrings("192.168.1.1"), testOptions(map[string]attr.Value{"interval": types.NumberValue(big.NewFloat(1))})}, "unknown option interval"},
+		"timeout":       {newIfPortUpFunction(), []attr.Value{types.Int64Value(443), testStrings("192.168.1.1"), testOptions(map[string]attr.Value{"timeout": types.NumberValue(big.NewFloat(1.5))})}, "positive integer"},
+		"url":           {newIfUrlUpFunction(), []attr.Value{types.StringValue(""), urls, types.DynamicNull()}, "invalid empty url"},
+		"address group": {newIfUrlUpFunction(), []attr.Value{types.StringValue("https://www.example.com/"), types.ListValueMust(types.ListType{ElemType: types.StringType}, nil), types.DynamicNull()}, "primary addresses"},
+		"length":        {newIfUrlUpFunction(), []attr.Value{types.StringValue("https://www.example.com/" + strings.Repeat("a", 255)), urls, types.DynamicNull()}, "longer than 255 bytes"},
+	} {
+		if _, err := testRunFunction(tc.f, tc.args...); err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("%s: expected an error with %q, got %v", name, tc.expected, err)
+		}
+	}
+}
+
+func TestProviderServer(t *testing.T) {
+	ctx := context.Background()
+	server, err := ProviderServer(ctx)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// the provider schemas of the sdk and the framework are the same
+	schema, err := server().GetProviderSchema(ctx, &tfprotov5.GetProviderSchemaRequest{})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for _, d := range schema.Diagnostics {
+		t.Errorf("%s: %s", d.Summary, d.Detail)
+	}
+	for _, name := range []string{"ifportup", "ifurlup", "pickrandom", "pickwrandom"} {
+		if _, ok := schema.Functions[name]; !ok {
+			t.Errorf("missing function %s", name)
+		}
+	}
+	if _, ok := schema.ResourceSchemas["powerdns-gslb_lua"]; !ok {
+		t.Errorf("missing resource powerdns-gslb_lua")
+	}
+
+	// a function called through the mux
+	addresses, err := tfprotov5.NewDynamicValue(tftypes.List{ElementType: tftypes.String}, tftypes.NewValue(
+		tftypes.List{ElementType: tftypes.String},
+		[]tftypes.Value{tftypes.NewValue(tftypes.String, "127.0.0.1")},
+	))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	resp, err := server().CallFunction(ctx, &tfprotov5.CallFunctionRequest{
+		Name:      "pickrandom",
+		Arguments: []*tfprotov5.DynamicValue{&addresses},
+	})
+	if err != nil || resp.Error != nil {
+		t.Fatalf("err: %v %v", err, resp.Error)
+	}
+	result, err := resp.Result.Unmarshal(tftypes.String)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	var snippet string
+	result.As(&snippet)
+	if snippet != "pickrandom({'127.0.0.1'})" {
+		t.Errorf("unexpected snippet %s", snippet)
+	}
+}
+
+func TestProviderServerConfigure(t *testing.T) {
+	ctx := context.Background()
+	server, err := ProviderServer(ctx)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	schema, err := server().GetProviderSchema(ctx, &tfprotov5.GetProviderSchemaRequest{})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// the configuration is validated and applied by both servers
+	configType := schema.Provider.ValueType().(tftypes.Object)
+	attributes := make(map[string]tftypes.Value)
+	for name, attributeType := range configType.AttributeTypes {
+		attributes[name] = tftypes.NewValue(attributeType, nil)
+	}
+	attributes["server"] = tftypes.NewValue(tftypes.String, "127.0.0.1")
+	attributes["key_name"] = tftypes.NewValue(tftypes.String, testKeyName)
+	attributes["key_algo"] = tftypes.NewValue(tftypes.String, testKeyAlgo)
+	attributes["key_secret"] = tftypes.NewValue(tftypes.String, testKeySecret)
+	config, err := tfprotov5.NewDynamicValue(configType, tftypes.NewValue(configType, attributes))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	prepared, err := server().PrepareProviderConfig(ctx, &tfprotov5.PrepareProviderConfigRequest{Config: &config})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for _, d := range prepared.Diagnostics {
+		t.Errorf("prepare: %s: %s", d.Summary, d.Detail)
+	}
+	configured, err := server().ConfigureProvider(ctx, &tfprotov5.ConfigureProviderRequest{Config: &config})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for _, d := range configured.Diagnostics {
+		if d.Severity == tfprotov5.DiagnosticSeverityError {
+			t.Errorf("configure: %s: %s", d.Summary, d.Detail)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package pdnsgslb
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/miekg/dns"
+)
+
+// luaFunctionResources are the typed resources of the LUA functions, the
+// other snippets are managed with the lua resource
+var luaFunctionResources = map[string]string{
+	"ifportup":    "powerdns-gslb_ifportup",
+	"ifurlup":     "powerdns-gslb_ifurlup",
+	"pickrandom":  "powerdns-gslb_pickrandom",
+	"pickwrandom": "powerdns-gslb_pickwrandom",
+}
+
+// importRecord returns the importer of a resource. The import id is the fqdn
+// of the record, zone/name or zone/name/rrtype. The LUA records of the name
+// are checked before the import, they must be snippets of the function of a
+// typed resource, parse is nil for the lua resource which accepts any snippet.
+// With a rrtype, the name must have no LUA record of another rrtype.
+func importRecord(resourceType string, parse func(string) (map[string]interface{}, error)) *schema.ResourceImporter {
+	return &schema.ResourceImporter{
+		StateContext: func(ctx context.Context, d *schema.ResourceData, m interface{}) ([]*schema.ResourceData, error) {
+			c := m.(*Client)
+
+			recordId, rrtype, err := parseImportId(d.Id())
+			if err != nil {
+				return nil, err
+			}
+
+			rr_lua, _, err := c.doTransfer(ctx, recordId)
+			if err != nil {
+				return nil, fmt.Errorf("Error importing %s: %w", recordId, err)
+			}
+
+			matched := false
+			var others []string
+			var otherTypes []string
+			for _, rr := range rr_lua {
+				rr_type, snippet := decodeLua(rr)
+				if !matchImportRrtype(rrtype, rr_type) {
+					if !slices.Contains(otherTypes, rr_type) {
+						otherTypes = append(otherTypes, rr_type)
+					}
+					continue
+				}
+				if parse == nil {
+					matched = true
+					continue
+				}
+				if _, err := parse(snippet); err == nil {
+					matched = true
+					continue
+				}
+				others = append(others, fmt.Sprintf("the %s record %q is managed with %s", rr_type, snippet, luaResource(snippet)))
+			}
+
+			// the records the resource can not read would be removed by the
+			// next update, even next to records it reads
+			if len(others) > 0 {
+				return nil, fmt.Errorf("Error importing %s with %s: %s", recordId, resourceType, strings.Join(others, ", "))
+			}
+			if !matched {
+				return nil, fmt.Errorf("Error importing %s: no LUA %s record", recordId, rrtype)
+			}
+			// the resource manages the records of every rrtype of the name,
+			// the others would be removed by the next update
+			if len(otherTypes) > 0 {
+				sort.Strings(otherTypes)
+				return nil, fmt.Errorf("Error importing %s as %s records: the name also has LUA %s records managed by the same resource, import it without rrtype", recordId, rrtype, strings.Join(otherTypes, ", "))
+			}
+
+			d.SetId(recordId)
+			d.Set("adopt_existing", false)
+			return []*schema.ResourceData{d}, nil
+		},
+	}
+}
+
+// parseImportId returns the fqdn of the record and the optional rrtype of an
+// import id, the fqdn of the record, zone/name or zone/name/rrtype.
+func parseImportId(id string) (string, string, error) {
+	parts := strings.Split(id, "/")
+	if len(parts) == 1 {
+		if !dns.IsFqdn(id) || dns.CountLabel(id) < 2 {
+			return "", "", fmt.Errorf("Error invalid import id %q, expected zone/name, zone/name/rrtype or the fully-qualified name of the record", id)
+		}
+		return id, "", nil
+	}
+	if len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("Error invalid import id %q, expected zone/name or zone/name/rrtype", id)
+	}
+
+	zone := dns.Fqdn(parts[0])
+	name := parts[1]
+	if dns.IsFqdn(name) {
+		// the fully-qualified name of the record in the zone
+		if !dns.IsSubDomain(zone, name) || dns.CountLabel(name) != dns.CountLabel(zone)+1 {
+			return "", "", fmt.Errorf("Error invalid import id %q, %s is not a name of the zone %s", id, name, zone)
+		}
+		name = dns.SplitDomainName(name)[0]
+	}
+	if strings.Contains(name, ".") {
+		return "", "", fmt.Errorf("Error invalid import id %q, the name %s must be a single label of the zone %s", id, name, zone)
+	}
+
+	rrtype := ""
+	if len(parts) == 3 {
+		rrtype = strings.ToUpper(parts[2])
+		if _, ok := dns.StringToType[rrtype]; !ok && rrtype != rrtypeDualStack {
+			return "", "", fmt.Errorf("Error invalid import id %q, unknown rrtype %s", id, parts[2])
+		}
+	}
+	return fmt.Sprintf("%s.%s", name, zone), rrtype, nil
+}
+
+// matchImportRrtype returns true when the LUA record of the rrtype is
+// imported, any rrtype without rrtype in the import id
+func matchImportRrtype(imported string, rrtype string) bool {
+	switch imported {
+	case "":
+		return true
+	case rrtypeDualStack:
+		return rrtype == "A" || rrtype == "AAAA"
+	}
+	return imported == rrtype
+}
+
+// decodeLua returns the rrtype and the snippet of a LUA record
+func decodeLua(rr *dns.RFC3597) (string, string) {
+	if len(rr.Rdata) < 6 {
+		return "", ""
+	}
+	rrtype_int, _ := strconv.ParseInt(rr.Rdata[0:4], 16, 64)
+	snippet, _ := hex.DecodeString(rr.Rdata[6:])
+	return dns.TypeToString[uint16(rrtype_int)], string(snippet)
+}
+
+// luaResource returns the resource type managing a snippet, the typed
+// resource of its function or the lua resource
+func luaResource(snippet string) string {
+	re := regexp.MustCompile(`^\s*(?P<function>[a-zA-Z_]\w*)\s*\(`)
+	if matches := re.FindStringSubmatch(snippet); len(matches) > 0 {
+		if resourceType, ok := luaFunctionResources[matches[re.SubexpIndex("function")]]; ok {
+			return resourceType
+		}
+	}
+	return "powerdns-gslb_lua"
+}
@@ -0,0 +1,109 @@
+package pdnsgslb
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseImportId(t *testing.T) {
+	for id, expected := range map[string][2]string{
+		"www.test.internal.":                {"www.test.internal.", ""},
+		"test.internal/www":                 {"www.test.internal.", ""},
+		"test.internal./www":                {"www.test.internal.", ""},
+		"test.internal./www.test.internal.": {"www.test.internal.", ""},
+		"test.internal/www/a":               {"www.test.internal.", "A"},
+		"test.internal/www/A+AAAA":          {"www.test.internal.", rrtypeDualStack},
+	} {
+		recordId, rrtype, err := parseImportId(id)
+		if err != nil {
+			t.Errorf("%s: %s", id, err)
+			continue
+		}
+		if recordId != expected[0] || rrtype != expected[1] {
+			t.Errorf("%s: expected %v, got %s %s", id, expected, recordId, rrtype)
+		}
+	}
+
+	for id, expected := range map[string]string{
+		"www.test.internal":              "fully-qualified",
+		"test.internal/":                 "expected zone/name",
+		"test.internal/www/A/more":       "expected zone/name",
+		"test.internal/www.sub":          "single label",
+		"test.internal/www.example.com.": "not a name of the zone",
+		"test.internal/www/NOTATYPE":     "unknown rrtype",
+	} {
+		if _, _, err := parseImportId(id); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected an error with %q, got %v", id, expected, err)
+		}
+	}
+}
+
+func TestImportRecord(t *testing.T) {
+	srv, c := testFakeServer(t)
+	for _, record := range []string{
+		// pickrandom({'192.168.1.1'}) and os.date()
+		"www.test.internal. 30 IN TYPE65402 \\# 30 0001 1b7069636b72616e646f6d287b273139322e3136382e312e31277d29",
+		"www.test.internal. 30 IN TYPE65402 \\# 12 0010 096f732e646174652829",
+		// pickrandom({'192.168.1.2'}) alone
+		"api.test.internal. 30 IN TYPE65402 \\# 30 0001 1b7069636b72616e646f6d287b273139322e3136382e312e32277d29",
+		// pickrandom({'192.168.1.3'}) and os.date() answering A
+		"mixed.test.internal. 30 IN TYPE65402 \\# 30 0001 1b7069636b72616e646f6d287b273139322e3136382e312e33277d29",
+		"mixed.test.internal. 30 IN TYPE65402 \\# 12 0001 096f732e646174652829",
+	} {
+		if err := srv.AddRecord(record); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	for name, tc := range map[string]struct {
+		resourceType string
+		id           string
+		expected     string
+	}{
+		"pickrandom":      {"powerdns-gslb_pickrandom", "test.internal/api", ""},
+		"pickrandom www":  {"powerdns-gslb_pickrandom", "test.internal/www", `the TXT record "os.date()" is managed with powerdns-gslb_lua`},
+		"pickrandom A":    {"powerdns-gslb_pickrandom", "test.internal/api/A", ""},
+		"pickrandom dual": {"powerdns-gslb_pickrandom", "test.internal/api/A+AAAA", ""},
+		"pickrandom mix":  {"powerdns-gslb_pickrandom", "test.internal/www/A", "the name also has LUA TXT records"},
+		"pickrandom TXT":  {"powerdns-gslb_pickrandom", "test.internal/www/TXT", `the TXT record "os.date()" is managed with powerdns-gslb_lua`},
+		"pickrandom part": {"powerdns-gslb_pickrandom", "test.internal/mixed/A", `the A record "os.date()" is managed with powerdns-gslb_lua`},
+		"pickrandom some": {"powerdns-gslb_pickrandom", "test.internal/mixed", `the A record "os.date()" is managed with powerdns-gslb_lua`},
+		"pickrandom AAAA": {"powerdns-gslb_pickrandom", "test.internal/www/AAAA", "no LUA AAAA record"},
+		"ifportup":        {"powerdns-gslb_ifportup", "www.test.internal.", "is managed with powerdns-gslb_pickrandom"},
+		"lua":             {"powerdns-gslb_lua", "test.internal/www", ""},
+		"lua TXT":         {"powerdns-gslb_lua", "test.internal/www/TXT", "the name also has LUA A records"},
+		"lua missing":     {"powerdns-gslb_lua", "test.internal/missing", "no LUA record retrieved"},
+	} {
+		r := Provider().ResourcesMap[tc.resourceType]
+		d := r.TestResourceData()
+		d.SetId(tc.id)
+
+		states, err := r.Importer.StateContext(context.Background(), d, c)
+		if tc.expected != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("%s: expected an error with %q, got %v", name, tc.expected, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		recordId, _, _ := parseImportId(tc.id)
+		if len(states) != 1 || states[0].Id() != recordId {
+			t.Errorf("%s: unexpected states %v", name, states)
+			continue
+		}
+
+		// the state read after the import
+		if diags := r.ReadContext(context.Background(), states[0], c); diags.HasError() {
+			t.Errorf("%s: read: %v", name, diags)
+		}
+		if states[0].Get("zone") != "test.internal." || states[0].Get("name") != dns.SplitDomainName(recordId)[0] {
+			t.Errorf("%s: unexpected zone and name %v %v", name, states[0].Get("zone"), states[0].Get("name"))
+		}
+	}
+}
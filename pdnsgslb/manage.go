@@ -0,0 +1,182 @@
+package pdnsgslb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Record is a LUA record of a name, managed outside of terraform
+type Record struct {
+	Name    string
+	Rrtype  string
+	TTL     int
+	Snippet string
+}
+
+// String returns the record as a line of a zone file
+func (r Record) String() string {
+	lua := &LUA{Rrtype: dns.StringToType[r.Rrtype], Snippet: r.Snippet}
+	return fmt.Sprintf("%s\t%d\tIN\tLUA\t%s", r.Name, r.TTL, lua)
+}
+
+// RR returns the LUA record as a generic record of the dns package
+func (r Record) RR() dns.RR {
+	lua := &LUA{Rrtype: dns.StringToType[r.Rrtype], Snippet: r.Snippet}
+	return &dns.RFC3597{
+		Hdr:   dns.RR_Header{Name: r.Name, Rrtype: TYPE_LUA, Class: dns.ClassINET, Ttl: uint32(r.TTL)},
+		Rdata: lua.rdata(),
+	}
+}
+
+// luaFunctions are the LUA functions of the typed resources rendering the
+// fields of their records, the lua function takes the snippet as is
+var luaFunctions = map[string]func([]interface{}) []interface{}{
+	"ifportup":    ifPortUpToLuaSnippet,
+	"ifurlup":     ifUrlUpToLuaSnippet,
+	"pickrandom":  pickRandomToLuaSnippet,
+	"pickwrandom": PickWrandomToLuaSnippet,
+	"lua":         func(records []interface{}) []interface{} { return records },
+}
+
+// ParseRecordName returns the fqdn of a record and the optional rrtype of its
+// LUA records, from the fqdn, zone/name or zone/name/rrtype
+func ParseRecordName(id string) (string, string, error) {
+	return parseImportId(id)
+}
+
+// ListRecords returns the LUA records of all the names of the zone
+func ListRecords(ctx context.Context, c *Client, zone string) ([]Record, error) {
+	lua_records, _, err := c.doTransferZone(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	return toRecords(lua_records, ""), nil
+}
+
+// GetRecords returns the LUA records of the name with the rrtype, of any
+// rrtype when empty, no record when the name has no LUA record
+func GetRecords(ctx context.Context, c *Client, name string, rrtype string) ([]Record, error) {
+	labels := dns.SplitDomainName(name)
+	zone := dns.Fqdn(strings.Join(labels[1:], "."))
+
+	lua_records, _, err := c.transfer(ctx, zone, name)
+	if err != nil {
+		return nil, err
+	}
+	return toRecords(lua_records, rrtype), nil
+}
+
+// RenderRecords returns the LUA records of the name rendered by the function
+// of a typed resource from the fields of a record, the fields of the resource
+// schema. The addresses are checked as on plan, a dual stack record is split
+// in an A and an AAAA record.
+func RenderRecords(name string, function string, fields map[string]interface{}) ([]Record, error) {
+	render, ok := luaFunctions[function]
+	if !ok {
+		return nil, fmt.Errorf("Error unknown function %s", function)
+	}
+
+	rrtype, _ := fields["rrtype"].(string)
+	if function == "lua" && rrtype == rrtypeDualStack {
+		return nil, fmt.Errorf("Error the rrtype %s is not supported by a snippet", rrtypeDualStack)
+	}
+	if err := checkAddresses(rrtype, recordAddresses(fields)); err != nil {
+		return nil, fmt.Errorf("Error record %s: %w", rrtype, err)
+	}
+
+	var records []Record
+	for _, rr := range render(splitDualStack([]interface{}{fields})) {
+		rec := rr.(map[string]interface{})
+		records = append(records, Record{
+			Name:    name,
+			Rrtype:  rec["rrtype"].(string),
+			TTL:     rec["ttl"].(int),
+			Snippet: rec["snippet"].(string),
+		})
+	}
+	return records, nil
+}
+
+// ReplaceRecords returns the records with the records of the rrtype replaced,
+// all the records when rrtype is empty. The A and AAAA records are replaced
+// for a dual stack rrtype.
+func ReplaceRecords(current []Record, rrtype string, records []Record) []Record {
+	var replaced []Record
+	for _, r := range current {
+		if !matchImportRrtype(rrtype, r.Rrtype) {
+			replaced = append(replaced, r)
+		}
+	}
+	return append(replaced, records...)
+}
+
+// SetRecords replaces the LUA records of the name, the name is deleted
+// without record
+func SetRecords(ctx context.Context, c *Client, name string, records []Record) error {
+	if len(records) == 0 {
+		_, err := c.doDelete(ctx, name)
+		return err
+	}
+
+	var rrset []interface{}
+	for _, r := range records {
+		rrset = append(rrset, map[string]interface{}{
+			"rrtype":  r.Rrtype,
+			"ttl":     r.TTL,
+			"snippet": r.Snippet,
+		})
+	}
+	_, err := c.doUpdate(ctx, name, rrset)
+	return err
+}
+
+// DiffRecords returns the records to remove prefixed by "-" and the records
+// to add prefixed by "+", the records are compared in any order
+func DiffRecords(current []Record, desired []Record) []string {
+	count := make(map[Record]int)
+	for _, r := range current {
+		count[r]++
+	}
+	var added []string
+	for _, r := range desired {
+		if count[r] > 0 {
+			count[r]--
+			continue
+		}
+		added = append(added, "+ "+r.String())
+	}
+
+	var removed []string
+	for _, r := range current {
+		if count[r] > 0 {
+			count[r]--
+			removed = append(removed, "- "+r.String())
+		}
+	}
+	sort.Strings(removed)
+	sort.Strings(added)
+	return append(removed, added...)
+}
+
+// toRecords returns the records of the LUA records with the rrtype, of any
+// rrtype when empty
+func toRecords(lua_records []*dns.RFC3597, rrtype string) []Record {
+	var records []Record
+	for _, rr := range lua_records {
+		rr_type, snippet := decodeLua(rr)
+		if !matchImportRrtype(rrtype, rr_type) {
+			continue
+		}
+		records = append(records, Record{
+			Name:    rr.Hdr.Name,
+			Rrtype:  rr_type,
+			TTL:     int(rr.Hdr.Ttl),
+			Snippet: snippet,
+		})
+	}
+	return records
+}
@@ -0,0 +1,89 @@
+package pdnsgslb
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestManageRecords(t *testing.T) {
+	srv, c := testFakeServer(t)
+	ctx := context.Background()
+	name := "www.test.internal."
+	if err := srv.AddRecord(testLuaRecord(name, 30, "TXT", "os.date()")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// a dual stack pickrandom record next to the TXT record
+	records, err := RenderRecords(name, "pickrandom", map[string]interface{}{
+		"rrtype": rrtypeDualStack, "ttl": 60, "addresses": []interface{}{"127.0.0.1", "::1"},
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	current, err := GetRecords(ctx, c, name, "")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	desired := ReplaceRecords(current, rrtypeDualStack, records)
+
+	expected := []string{
+		"+ www.test.internal.\t60\tIN\tLUA\tA \"pickrandom({'127.0.0.1'})\"",
+		"+ www.test.internal.\t60\tIN\tLUA\tAAAA \"pickrandom({'::1'})\"",
+	}
+	if changes := DiffRecords(current, desired); !reflect.DeepEqual(changes, expected) {
+		t.Errorf("unexpected changes %q", changes)
+	}
+	if err := SetRecords(ctx, c, name, desired); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected = []string{"A pickrandom({'127.0.0.1'})", "AAAA pickrandom({'::1'})", "TXT os.date()"}
+	if snippets := testFakeSnippets(t, srv, name); !reflect.DeepEqual(snippets, expected) {
+		t.Errorf("unexpected records %v", snippets)
+	}
+
+	// the records of the rrtype only
+	aaaa, err := GetRecords(ctx, c, name, "AAAA")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(aaaa) != 1 || aaaa[0].Snippet != "pickrandom({'::1'})" {
+		t.Errorf("unexpected AAAA records %v", aaaa)
+	}
+
+	// a delete of the rrtype keeps the other records
+	current, _ = GetRecords(ctx, c, name, "")
+	if changes := DiffRecords(current, current); len(changes) != 0 {
+		t.Errorf("unexpected changes %q", changes)
+	}
+	if err := SetRecords(ctx, c, name, ReplaceRecords(current, "TXT", nil)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if snippets := testFakeSnippets(t, srv, name); len(snippets) != 2 {
+		t.Errorf("unexpected records %v", snippets)
+	}
+
+	// the name is deleted without record
+	if err := SetRecords(ctx, c, name, nil); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if records, err := GetRecords(ctx, c, name, ""); err != nil || len(records) != 0 {
+		t.Errorf("unexpected records %v %v", records, err)
+	}
+}
+
+func TestRenderRecordsErrors(t *testing.T) {
+	for function, tc := range map[string]struct {
+		fields   map[string]interface{}
+		expected string
+	}{
+		"unknown":    {map[string]interface{}{"rrtype": "A", "ttl": 0}, "unknown function"},
+		"pickrandom": {map[string]interface{}{"rrtype": "A", "ttl": 0, "addresses": []interface{}{"::1"}}, "not an IPv4 address"},
+		"lua":        {map[string]interface{}{"rrtype": rrtypeDualStack, "ttl": 0, "snippet": "os.date()"}, "not supported"},
+	} {
+		if _, err := RenderRecords("www.test.internal.", function, tc.fields); err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("%s: expected an error with %q, got %v", function, tc.expected, err)
+		}
+	}
+}
@@ -2,16 +2,40 @@ package pdnsgslb
 
 import (
 	"context"
-	"strconv"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/hashicorp/go-cty/cty"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"github.com/miekg/dns"
 )
 
 const (
-	defaultPort      = "53"
-	defaultTransport = "tcp"
-	defaultRetries   = "2"
+	defaultPort          = 53
+	defaultTransport     = "tcp"
+	defaultRetries       = 2
+	defaultTLSMinVersion = "1.2"
+	defaultAuthMode      = authModeTsig
+	defaultFailover      = failoverOrdered
+
+	// default timeout of the resource operations, retries included
+	defaultResourceTimeout = 5 * time.Minute
+)
+
+var (
+	validTransports  = []string{"udp", "udp4", "udp6", "tcp", "tcp4", "tcp6", "tcp-tls"}
+	validTLSVersions = []string{"1.0", "1.1", "1.2", "1.3"}
+	validAuthModes   = []string{authModeTsig, authModeSig0, authModeGss}
+	validFailovers   = []string{failoverOrdered, failoverHealth}
+	validRetryRcodes = []string{"SERVFAIL", "REFUSED", "NOTIMP", "NOTAUTH"}
 )
 
 // Provider -
@@ -20,38 +44,208 @@ func Provider() *schema.Provider {
 		Schema: map[string]*schema.Schema{
 			"server": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
 				DefaultFunc: schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_SERVER", nil),
 			},
+			"servers": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Schema{
+					Type:             schema.TypeString,
+					ValidateDiagFunc: validation.ToDiagFunc(validation.StringIsNotEmpty),
+				},
+			},
+			"failover_policy": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				DefaultFunc:      schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_FAILOVER_POLICY", defaultFailover),
+				ValidateDiagFunc: validation.ToDiagFunc(validation.StringInSlice(validFailovers, false)),
+			},
 			"port": {
+				Type:             schema.TypeInt,
+				Optional:         true,
+				DefaultFunc:      schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_PORT", defaultPort),
+				ValidateDiagFunc: validation.ToDiagFunc(validation.IsPortNumber),
+			},
+			"transport": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				DefaultFunc:      schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_TRANSPORT", defaultTransport),
+				ValidateDiagFunc: validation.ToDiagFunc(validation.StringInSlice(validTransports, false)),
+			},
+			"retries": {
+				Type:             schema.TypeInt,
+				Optional:         true,
+				DefaultFunc:      schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_RETRIES", defaultRetries),
+				ValidateDiagFunc: validation.ToDiagFunc(validation.IntAtLeast(0)),
+			},
+			"dial_timeout": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				DefaultFunc:      schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_DIAL_TIMEOUT", dnsTimeout.String()),
+				ValidateDiagFunc: validateDuration,
+			},
+			"read_timeout": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				DefaultFunc:      schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_READ_TIMEOUT", dnsTimeout.String()),
+				ValidateDiagFunc: validateDuration,
+			},
+			"write_timeout": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				DefaultFunc:      schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_WRITE_TIMEOUT", dnsTimeout.String()),
+				ValidateDiagFunc: validateDuration,
+			},
+			"retry_base_delay": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				DefaultFunc:      schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_RETRY_BASE_DELAY", defaultRetryPolicy.BaseDelay.String()),
+				ValidateDiagFunc: validateDuration,
+			},
+			"retry_max_delay": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				DefaultFunc:      schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_RETRY_MAX_DELAY", defaultRetryPolicy.MaxDelay.String()),
+				ValidateDiagFunc: validateDuration,
+			},
+			"retry_jitter": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  defaultRetryPolicy.Jitter,
+			},
+			"retry_rcodes": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Schema{
+					Type:             schema.TypeString,
+					ValidateDiagFunc: validation.ToDiagFunc(validation.StringInSlice(validRetryRcodes, false)),
+				},
+			},
+			"key_name": {
 				Type:        schema.TypeString,
 				Optional:    true,
-				DefaultFunc: schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_PORT", defaultPort),
+				DefaultFunc: schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_KEYNAME", nil),
 			},
-			"transport": {
+			"key_algo": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				DefaultFunc:      schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_KEYALGORITHM", nil),
+				ValidateDiagFunc: validateTsigAlgo,
+			},
+			"key_secret": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				DefaultFunc:      schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_KEYSECRET", nil),
+				Sensitive:        true,
+				ValidateDiagFunc: validation.ToDiagFunc(validation.StringIsBase64),
+			},
+			"key_file": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				DefaultFunc:   schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_KEYFILE", nil),
+				ConflictsWith: []string{"key_algo", "key_secret"},
+			},
+			"auth_mode": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				DefaultFunc:      schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_AUTHMODE", defaultAuthMode),
+				ValidateDiagFunc: validation.ToDiagFunc(validation.StringInSlice(validAuthModes, false)),
+			},
+			"sig0_key_file": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				DefaultFunc:   schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_SIG0_KEYFILE", nil),
+				ConflictsWith: []string{"sig0_public_key", "sig0_private_key"},
+			},
+			"sig0_public_key": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"sig0_private_key": {
+				Type:      schema.TypeString,
+				Optional:  true,
+				Sensitive: true,
+			},
+			"krb_realm": {
 				Type:        schema.TypeString,
 				Optional:    true,
-				DefaultFunc: schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_TRANSPORT", defaultTransport),
+				DefaultFunc: schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_KRB_REALM", ""),
 			},
-			"retries": {
+			"krb_username": {
 				Type:        schema.TypeString,
 				Optional:    true,
-				DefaultFunc: schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_RETRIES", defaultRetries),
+				DefaultFunc: schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_KRB_USERNAME", ""),
 			},
-			"key_name": {
+			"krb_password": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				Sensitive:     true,
+				DefaultFunc:   schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_KRB_PASSWORD", nil),
+				ConflictsWith: []string{"krb_keytab", "krb_ccache"},
+			},
+			"krb_keytab": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				DefaultFunc:   schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_KRB_KEYTAB", nil),
+				ConflictsWith: []string{"krb_ccache"},
+			},
+			"krb_ccache": {
 				Type:        schema.TypeString,
-				Required:    true,
-				DefaultFunc: schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_KEYNAME", nil),
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_KRB_CCACHE", ""),
 			},
-			"key_algo": {
+			"krb_kdc": {
 				Type:        schema.TypeString,
-				Required:    true,
-				DefaultFunc: schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_KEYALGORITHM", nil),
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_KRB_KDC", ""),
 			},
-			"key_secret": {
+			"krb_config": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				DefaultFunc:   schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_KRB_CONFIG", nil),
+				ConflictsWith: []string{"krb_kdc"},
+			},
+			"adopt_existing": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"debug_dump_dir": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_DEBUG_DUMP_DIR", nil),
+			},
+			"verify_on_configure": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"tls_ca_file": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_TLS_CAFILE", ""),
+			},
+			"tls_cert_file": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_TLS_CERTFILE", ""),
+			},
+			"tls_key_file": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_TLS_KEYFILE", ""),
+			},
+			"tls_server_name": {
 				Type:        schema.TypeString,
-				Required:    true,
-				DefaultFunc: schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_KEYSECRET", nil),
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_TLS_SERVERNAME", ""),
+			},
+			"tls_min_version": {
+				Type:             schema.TypeString,
+				Optional:         true,
+				DefaultFunc:      schema.EnvDefaultFunc("PDNSGLSB_DNSUPDATE_TLS_MINVERSION", defaultTLSMinVersion),
+				ValidateDiagFunc: validation.ToDiagFunc(validation.StringInSlice(validTLSVersions, false)),
 			},
 		},
 		ResourcesMap: map[string]*schema.Resource{
@@ -61,7 +255,9 @@ func Provider() *schema.Provider {
 			"powerdns-gslb_ifportup":    resourceIfPortUp(),
 			"powerdns-gslb_ifurlup":     resourceIfUrlUp(),
 		},
-		DataSourcesMap:       map[string]*schema.Resource{},
+		DataSourcesMap: map[string]*schema.Resource{
+			"powerdns-gslb_zonefile": dataSourceZoneFile(),
+		},
 		ConfigureContextFunc: providerConfigure,
 	}
 }
@@ -72,25 +268,97 @@ func providerConfigure(ctx context.Context, data *schema.ResourceData) (interfac
 	keyname := data.Get("key_name").(string)
 	keyalgo := data.Get("key_algo").(string)
 	keysecret := data.Get("key_secret").(string)
+	port := data.Get("port").(int)
+	retries := data.Get("retries").(int)
+	authmode := data.Get("auth_mode").(string)
 
-	// convert string port to int
-	port := data.Get("port").(string)
-	port_int, err := strconv.Atoi(port)
-	if err != nil {
-		return nil, diag.Errorf("invalid port: %s", port)
+	// Warning or errors can be collected in a slice type
+	var diags diag.Diagnostics
+
+	// a list of servers with failover, or a single server
+	var servers []string
+	for _, v := range data.Get("servers").([]interface{}) {
+		servers = append(servers, v.(string))
+	}
+	if len(servers) == 0 && server != "" {
+		servers = []string{server}
+	} else if server != "" {
+		diags = append(diags, diag.Diagnostic{
+			Severity:      diag.Warning,
+			Summary:       "DNS server ignored",
+			Detail:        fmt.Sprintf("server %s is ignored, the servers %s are used instead. Remove server or PDNSGLSB_DNSUPDATE_SERVER.", server, strings.Join(servers, ", ")),
+			AttributePath: cty.GetAttrPath("server"),
+		})
 	}
+	if len(servers) == 0 {
+		diags = append(diags, diag.Diagnostic{
+			Severity:      diag.Error,
+			Summary:       "Missing DNS server",
+			Detail:        "server or servers is required",
+			AttributePath: cty.GetAttrPath("server"),
+		})
+		return nil, diags
+	}
+	// the tsig key is required with the tsig authentication mode only
+	if authmode == authModeTsig {
+		// key name, algorithm and secret from a key file
+		if keyfile := data.Get("key_file").(string); keyfile != "" {
+			key, err := readTsigKeyFile(keyfile, keyname)
+			if err != nil {
+				diags = append(diags, diag.Diagnostic{
+					Severity:      diag.Error,
+					Summary:       "Unable to load the TSIG key file",
+					Detail:        err.Error(),
+					AttributePath: cty.GetAttrPath("key_file"),
+				})
+				return nil, diags
+			}
+			keyname, keyalgo, keysecret = dns.Fqdn(key.Name), key.Algorithm, key.Secret
+		}
 
-	// convert string retries value to int
-	retries := data.Get("retries").(string)
-	retries_int, err := strconv.Atoi(retries)
-	if err != nil {
-		return nil, diag.Errorf("invalid retries: %s", retries)
+		for _, setting := range []struct{ attr, value string }{
+			{"key_name", keyname},
+			{"key_algo", keyalgo},
+			{"key_secret", keysecret},
+		} {
+			if setting.value == "" {
+				diags = append(diags, diag.Diagnostic{
+					Severity:      diag.Error,
+					Summary:       "Missing TSIG key setting",
+					Detail:        fmt.Sprintf("%s is required with the %s authentication mode", setting.attr, authmode),
+					AttributePath: cty.GetAttrPath(setting.attr),
+				})
+			}
+		}
+		if diags.HasError() {
+			return nil, diags
+		}
+	} else {
+		keyname, keyalgo, keysecret = "", "", ""
 	}
 
-	// Warning or errors can be collected in a slice type
-	var diags diag.Diagnostics
+	// tls settings for dns over tls
+	var tlsconfig *tls.Config
+	if transport == "tcp-tls" {
+		var err error
+		tlsconfig, err = newTLSConfig(
+			data.Get("tls_ca_file").(string),
+			data.Get("tls_cert_file").(string),
+			data.Get("tls_key_file").(string),
+			data.Get("tls_server_name").(string),
+			data.Get("tls_min_version").(string),
+		)
+		if err != nil {
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Error,
+				Summary:  "Unable to load TLS settings",
+				Detail:   err.Error(),
+			})
+			return nil, diags
+		}
+	}
 
-	c, err := NewClient(server, port_int, transport, keyname, keysecret, keyalgo, retries_int)
+	c, err := NewClient(servers[0], port, transport, keyname, keysecret, keyalgo, retries, tlsconfig)
 	if err != nil {
 		diags = append(diags, diag.Diagnostic{
 			Severity: diag.Error,
@@ -99,6 +367,420 @@ func providerConfigure(ctx context.Context, data *schema.ResourceData) (interfac
 		})
 		return nil, diags
 	}
+	c.AdoptExisting = data.Get("adopt_existing").(bool)
+
+	if dumpdir := data.Get("debug_dump_dir").(string); dumpdir != "" {
+		if err := os.MkdirAll(dumpdir, 0700); err != nil {
+			diags = append(diags, diag.Diagnostic{
+				Severity:      diag.Error,
+				Summary:       "Unable to create the debug dump directory",
+				Detail:        err.Error(),
+				AttributePath: cty.GetAttrPath("debug_dump_dir"),
+			})
+			return nil, diags
+		}
+		c.DumpDir = dumpdir
+		diags = append(diags, diag.Diagnostic{
+			Severity:      diag.Warning,
+			Summary:       "DNS messages are dumped to " + dumpdir,
+			Detail:        "The dumps contain the names and the snippets of the records, the TSIG MAC is masked. Disable debug_dump_dir once the issue is diagnosed.",
+			AttributePath: cty.GetAttrPath("debug_dump_dir"),
+		})
+	}
+
+	// network timeouts and delay between the attempts, the durations are
+	// checked by the schema
+	c.DNSClient.DialTimeout, _ = time.ParseDuration(data.Get("dial_timeout").(string))
+	c.DNSClient.ReadTimeout, _ = time.ParseDuration(data.Get("read_timeout").(string))
+	c.DNSClient.WriteTimeout, _ = time.ParseDuration(data.Get("write_timeout").(string))
+	c.RetryPolicy.BaseDelay, _ = time.ParseDuration(data.Get("retry_base_delay").(string))
+	c.RetryPolicy.MaxDelay, _ = time.ParseDuration(data.Get("retry_max_delay").(string))
+	c.RetryPolicy.Jitter = data.Get("retry_jitter").(bool)
+	if v := data.Get("retry_rcodes").([]interface{}); len(v) > 0 {
+		var names []string
+		for _, name := range v {
+			names = append(names, name.(string))
+		}
+		c.RetryPolicy.Rcodes, err = convertRcodes(names)
+		if err != nil {
+			diags = append(diags, diag.Diagnostic{
+				Severity:      diag.Error,
+				Summary:       "Invalid retry setting",
+				Detail:        err.Error(),
+				AttributePath: cty.GetAttrPath("retry_rcodes"),
+			})
+			return nil, diags
+		}
+	}
+
+	if err := c.useServers(servers, port, data.Get("failover_policy").(string)); err != nil {
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Error,
+			Summary:  "Unable to configure the DNS servers",
+			Detail:   err.Error(),
+		})
+		return nil, diags
+	}
+
+	// sig0 key pair, from the key files or the attributes
+	if authmode == authModeSig0 {
+		publickey := data.Get("sig0_public_key").(string)
+		privatekey := data.Get("sig0_private_key").(string)
+		if keyfile := data.Get("sig0_key_file").(string); keyfile != "" {
+			publickey, privatekey, err = readSig0KeyFile(keyfile)
+		}
+		if err == nil {
+			err = c.useSig0(publickey, privatekey)
+		}
+		if err != nil {
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Error,
+				Summary:  "Unable to load the SIG(0) key",
+				Detail:   err.Error(),
+			})
+			return nil, diags
+		}
+	}
+
+	// gss-tsig with kerberos credentials
+	if authmode == authModeGss {
+		err = c.useGss(gssConfig{
+			Realm:      data.Get("krb_realm").(string),
+			Username:   data.Get("krb_username").(string),
+			Password:   data.Get("krb_password").(string),
+			Keytab:     data.Get("krb_keytab").(string),
+			CCache:     data.Get("krb_ccache").(string),
+			KDC:        data.Get("krb_kdc").(string),
+			ConfigFile: data.Get("krb_config").(string),
+		})
+		if err != nil {
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Error,
+				Summary:  "Unable to configure the GSS-TSIG authentication",
+				Detail:   err.Error(),
+			})
+			return nil, diags
+		}
+	}
+
+	// optional self-test of the connectivity and the key
+	if data.Get("verify_on_configure").(bool) {
+		if err := c.doVerify(ctx); err != nil {
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Error,
+				Summary:  "Unable to verify the DNS server and the key",
+				Detail:   err.Error(),
+			})
+			return nil, diags
+		}
+	}
 
 	return c, diags
 }
+
+// ConfigureClient returns the client of a provider configuration outside of
+// terraform, the attributes not in the configuration are read from the
+// environment variables of the provider.
+func ConfigureClient(ctx context.Context, config map[string]interface{}) (*Client, diag.Diagnostics) {
+	p := Provider()
+	rc := terraform.NewResourceConfigRaw(config)
+
+	diags := p.Validate(rc)
+	if diags.HasError() {
+		return nil, diags
+	}
+	diags = append(diags, p.Configure(ctx, rc)...)
+	if diags.HasError() {
+		return nil, diags
+	}
+	return p.Meta().(*Client), diags
+}
+
+// validateTsigAlgo checks the TSIG algorithm is supported before converting it
+func validateTsigAlgo(v interface{}, path cty.Path) diag.Diagnostics {
+	if _, err := convertTsigAlgo(v.(string)); err != nil {
+		return diag.Diagnostics{
+			{
+				Severity:      diag.Error,
+				Summary:       "Invalid TSIG algorithm",
+				Detail:        err.Error(),
+				AttributePath: path,
+			},
+		}
+	}
+	return nil
+}
+
+// failoverDiagnostics warns when an operation was served by another server
+// than the first one of the list, the first server being unavailable.
+func failoverDiagnostics(c *Client, server string, operation string, record string) diag.Diagnostics {
+	if server == "" || server == c.SrvAddr {
+		return nil
+	}
+	return diag.Diagnostics{
+		{
+			Severity: diag.Warning,
+			Summary:  "DNS server failover",
+			Detail:   fmt.Sprintf("The %s of %s was served by %s, the primary server %s is unavailable", operation, record, server, c.SrvAddr),
+		},
+	}
+}
+
+// validateDuration checks the value is a positive duration such as 100ms or 5s
+func validateDuration(v interface{}, path cty.Path) diag.Diagnostics {
+	if d, err := time.ParseDuration(v.(string)); err != nil || d < 0 {
+		return diag.Diagnostics{
+			{
+				Severity:      diag.Error,
+				Summary:       "Invalid duration",
+				Detail:        fmt.Sprintf("%q is not a valid duration, such as 100ms or 5s", v),
+				AttributePath: path,
+			},
+		}
+	}
+	return nil
+}
+
+// validateRecords checks the addresses of the records, and the length of the
+// snippets rendered by toLua. The records are read from the raw configuration
+// with the defaults of the schema, the snippets of the records unknown at plan
+// time are checked at apply time.
+func validateRecords(resource map[string]*schema.Schema, toLua func([]interface{}) []interface{}) schema.CustomizeDiffFunc {
+	fields := resource["record"].Elem.(*schema.Resource).Schema
+	return func(ctx context.Context, d *schema.ResourceDiff, m interface{}) error {
+		if err := validateRecordAddresses(ctx, d, m); err != nil {
+			return err
+		}
+		raw := d.GetRawConfig().GetAttr("record")
+		if !raw.IsWhollyKnown() {
+			return nil
+		}
+
+		records, _ := ctyValue(raw).([]interface{})
+		for _, rec := range records {
+			record := rec.(map[string]interface{})
+			for k, v := range record {
+				if v == nil {
+					record[k] = fields[k].Default
+				}
+			}
+		}
+
+		var errs []error
+		for _, rr := range toLua(splitDualStack(records)) {
+			lua_rr := rr.(map[string]interface{})
+			if err := checkSnippet(lua_rr["snippet"].(string)); err != nil {
+				errs = append(errs, fmt.Errorf("record %s: %w", lua_rr["rrtype"], err))
+			}
+		}
+		return errors.Join(errs...)
+	}
+}
+
+// validateRecordAddresses checks the addresses of each record are of the
+// family of the rrtype, IPv4 for A and IPv6 for AAAA, and are not duplicated.
+// The addresses unknown at plan time are checked at apply time. The records
+// are read from the raw configuration, the lists nested in the set of records
+// are not known to the diff before the apply.
+func validateRecordAddresses(ctx context.Context, d *schema.ResourceDiff, m interface{}) error {
+	records, _ := ctyValue(d.GetRawConfig().GetAttr("record")).([]interface{})
+
+	var errs []error
+	for _, rec := range records {
+		record, ok := rec.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		rrtype, _ := record["rrtype"].(string)
+		if err := checkAddresses(rrtype, recordAddresses(record)); err != nil {
+			errs = append(errs, fmt.Errorf("record %s: %w", rrtype, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ctyValue returns the value of a configuration as the values of the schema,
+// nil for the null and unknown values
+func ctyValue(v cty.Value) interface{} {
+	if v.IsNull() || !v.IsKnown() {
+		return nil
+	}
+
+	t := v.Type()
+	switch {
+	case t == cty.String:
+		return v.AsString()
+	case t == cty.Number:
+		i, _ := v.AsBigFloat().Int64()
+		return int(i)
+	case t == cty.Bool:
+		return v.True()
+	case t.IsListType() || t.IsSetType() || t.IsTupleType():
+		list := []interface{}{}
+		for it := v.ElementIterator(); it.Next(); {
+			_, e := it.Element()
+			list = append(list, ctyValue(e))
+		}
+		return list
+	case t.IsObjectType() || t.IsMapType():
+		m := map[string]interface{}{}
+		for it := v.ElementIterator(); it.Next(); {
+			k, e := it.Element()
+			m[k.AsString()] = ctyValue(e)
+		}
+		return m
+	}
+	return nil
+}
+
+// recordAddresses returns the addresses of a record of a typed resource, the
+// addresses list, the primary and backup lists, or the ip of the weighted
+// addresses.
+func recordAddresses(record map[string]interface{}) []string {
+	var addresses []string
+	collect := func(values interface{}) {
+		list, _ := values.([]interface{})
+		for _, v := range list {
+			if addr, ok := v.(string); ok {
+				addresses = append(addresses, addr)
+			}
+		}
+	}
+
+	list, _ := record["addresses"].([]interface{})
+	collect(list)
+	for _, v := range list {
+		if group, ok := v.(map[string]interface{}); ok {
+			collect(group["primary"])
+			collect(group["backup"])
+		}
+	}
+
+	weighted, _ := record["ipaddress"].([]interface{})
+	for _, v := range weighted {
+		if wp, ok := v.(map[string]interface{}); ok {
+			collect([]interface{}{wp["ip"]})
+		}
+	}
+	return addresses
+}
+
+// checkAddresses returns an error when an address is not of the family of the
+// rrtype or is duplicated, the empty addresses are unknown and skipped. The
+// IPv4-mapped IPv6 addresses belong to no family of a dual stack record.
+func checkAddresses(rrtype string, addresses []string) error {
+	seen := make(map[netip.Addr]string)
+	for _, address := range addresses {
+		if address == "" {
+			continue
+		}
+		addr, err := netip.ParseAddr(address)
+		if err != nil {
+			return fmt.Errorf("invalid IP address %q", address)
+		}
+		switch strings.ToUpper(rrtype) {
+		case "A":
+			if !addr.Is4() {
+				return fmt.Errorf("address %s is not an IPv4 address, required by rrtype A", address)
+			}
+		case "AAAA":
+			if !addr.Is6() || addr.Is4In6() {
+				return fmt.Errorf("address %s is not an IPv6 address, required by rrtype AAAA", address)
+			}
+		case rrtypeDualStack:
+			// neither in the A nor in the AAAA record once split
+			if addr.Is4In6() {
+				return fmt.Errorf("address %s is an IPv4-mapped IPv6 address, use %s with rrtype %s", address, addr.Unmap(), rrtypeDualStack)
+			}
+		}
+		if previous, ok := seen[addr]; ok {
+			return fmt.Errorf("address %s is duplicated (%s)", address, previous)
+		}
+		seen[addr] = address
+	}
+	return nil
+}
+
+// resourceTimeouts returns the default timeouts of the resource operations
+func resourceTimeouts() *schema.ResourceTimeout {
+	return &schema.ResourceTimeout{
+		Create: schema.DefaultTimeout(defaultResourceTimeout),
+		Read:   schema.DefaultTimeout(defaultResourceTimeout),
+		Update: schema.DefaultTimeout(defaultResourceTimeout),
+		Delete: schema.DefaultTimeout(defaultResourceTimeout),
+	}
+}
+
+// operationPhaseKey is the context key of the phase of a read ending a
+// create or an update
+type operationPhaseKey struct{}
+
+// withOperationPhase returns the context of the read ending the operation,
+// the failures of the read are reported in the phase of the operation whose
+// timeout bounds the read.
+func withOperationPhase(ctx context.Context, phase string) context.Context {
+	return context.WithValue(ctx, operationPhaseKey{}, phase)
+}
+
+// operationDiagnostics returns the error of a dns operation on the record,
+// the phase is named when the timeout of the resource operation expired.
+func operationDiagnostics(ctx context.Context, d *schema.ResourceData, phase string, record string, err error) diag.Diagnostics {
+	if p, ok := ctx.Value(operationPhaseKey{}).(string); ok {
+		phase = p
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return diag.Diagnostics{
+			{
+				Severity: diag.Error,
+				Summary:  fmt.Sprintf("Timeout during the %s of %s", phase, record),
+				Detail:   fmt.Sprintf("The %s did not complete within %s, retries included, increase timeouts.%s of the resource if the server is slow: %s", phase, d.Timeout(phase), phase, err),
+			},
+		}
+	}
+
+	// known failures with a hint to fix them
+	for _, failure := range operationFailures {
+		if errors.Is(err, failure.err) {
+			return diag.Diagnostics{
+				{
+					Severity: diag.Error,
+					Summary:  fmt.Sprintf("%s during the %s of %s", failure.summary, phase, record),
+					Detail:   fmt.Sprintf("%s\n\n%s", err, failure.hint),
+				},
+			}
+		}
+	}
+	return diag.FromErr(err)
+}
+
+// operationFailures are the failures of the dns operations with their
+// remediation, the most specific ones first.
+var operationFailures = []struct {
+	err     error
+	summary string
+	hint    string
+}{
+	{errTsigUnknownKey, "TSIG key unknown", "Check key_name, the key must exist on the server with the same name (pdnsutil list-tsig-keys)."},
+	{errTsigBadSignature, "TSIG signature rejected", "Check key_secret and key_algo match the key configured on the server."},
+	{errTsigClockSkew, "TSIG clock skew", "Synchronize the clocks of this host and of the DNS server, with NTP for instance."},
+	{errNotAuth, "Update not authorized", "Check the server is authoritative for the zone and the TSIG-ALLOW-DNSUPDATE metadata of the zone allows the key (pdnsutil set-meta <zone> TSIG-ALLOW-DNSUPDATE <key>)."},
+	{errRefused, "Request refused", "Check dnsupdate=yes in the server configuration, the ALLOW-DNSUPDATE-FROM metadata of the zone for updates, and the ALLOW-AXFR-FROM or TSIG-ALLOW-AXFR metadata for zone transfers."},
+	{errNotZone, "Name not in zone", "Check the zone of the resource exists on the server and the name belongs to this zone."},
+	{errYXRRSet, "LUA record already exists", "Import the existing record with terraform import, or set adopt_existing to take it over."},
+	{errNXRRSet, "LUA record does not exist", "The record was removed outside of Terraform, refresh the state."},
+	{errTimeout, "DNS server timeout", "Check the server is reachable with this transport and port, or increase dial_timeout and read_timeout for a slow server."},
+	{errConnRefused, "Connection refused", "Check the server listens on this port with this transport, tcp-tls usually listens on port 853."},
+	{errServerUnreachable, "DNS server unreachable", "Check the server address and the network path to the server."},
+}
+
+// noRecordsDiagnostics returns the error of a read finding no LUA record of
+// the resource type, with the snippets which did not parse.
+func noRecordsDiagnostics(record string, parseErrors []string) diag.Diagnostics {
+	return diag.Diagnostics{
+		{
+			Severity: diag.Error,
+			Summary:  "No LUA records detected",
+			Detail:   fmt.Sprintf("No LUA record of %s matches the resource type.\n\n%s", record, strings.Join(parseErrors, "\n")),
+		},
+	}
+}
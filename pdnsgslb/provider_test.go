@@ -1,10 +1,28 @@
 package pdnsgslb
 
 import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/dmachard/terraform-provider-powerdns-gslb/internal/fakepdns"
+	"github.com/hashicorp/go-cty/cty"
+	ctyjson "github.com/hashicorp/go-cty/cty/json"
+	"github.com/hashicorp/go-cty/cty/msgpack"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov5"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"github.com/miekg/dns"
 )
 
 var testAccProviders map[string]*schema.Provider
@@ -27,6 +45,121 @@ func TestProvider_impl(t *testing.T) {
 	var _ *schema.Provider = Provider()
 }
 
+func testProviderConfig(overrides map[string]interface{}) *terraform.ResourceConfig {
+	raw := map[string]interface{}{
+		"server":     "127.0.0.1",
+		"port":       5353,
+		"transport":  "tcp",
+		"retries":    2,
+		"key_name":   testKeyName,
+		"key_algo":   testKeyAlgo,
+		"key_secret": testKeySecret,
+	}
+	for k, v := range overrides {
+		raw[k] = v
+	}
+	return terraform.NewResourceConfigRaw(raw)
+}
+
+func TestProviderValidate(t *testing.T) {
+	if diags := Provider().Validate(testProviderConfig(nil)); diags.HasError() {
+		t.Fatalf("unexpected errors: %v", diags)
+	}
+
+	// attributes with conflicts set without the conflicting ones
+	for attr, value := range map[string]interface{}{
+		"sig0_public_key": "pipeline.test.internal. IN KEY 512 3 13 AAAA",
+		"krb_password":    "secret",
+		"krb_kdc":         "kdc.test.internal",
+	} {
+		if diags := Provider().Validate(testProviderConfig(map[string]interface{}{attr: value})); diags.HasError() {
+			t.Errorf("%s: unexpected errors: %v", attr, diags)
+		}
+	}
+
+	for attr, value := range map[string]interface{}{
+		"port":            70000,
+		"retries":         -1,
+		"transport":       "udp4x",
+		"key_algo":        "hmac-sha3",
+		"key_secret":      "not base64!",
+		"tls_min_version": "1.4",
+	} {
+		diags := Provider().Validate(testProviderConfig(map[string]interface{}{attr: value}))
+		if !diags.HasError() {
+			t.Errorf("%s: expected an error for %v", attr, value)
+			continue
+		}
+		if !diags[0].AttributePath.Equals(cty.GetAttrPath(attr)) {
+			t.Errorf("%s: diagnostic not scoped to the attribute: %#v", attr, diags[0].AttributePath)
+		}
+	}
+}
+
+func TestProviderConfigureMissingTsigKey(t *testing.T) {
+	for _, env := range []string{"PDNSGLSB_DNSUPDATE_KEYNAME", "PDNSGLSB_DNSUPDATE_KEYALGORITHM", "PDNSGLSB_DNSUPDATE_KEYSECRET"} {
+		t.Setenv(env, "")
+	}
+
+	d := schema.TestResourceDataRaw(t, Provider().Schema, map[string]interface{}{
+		"server":   "127.0.0.1",
+		"key_name": testKeyName,
+	})
+	_, diags := providerConfigure(context.Background(), d)
+	if len(diags) != 2 {
+		t.Fatalf("expected 2 diagnostics, got %v", diags)
+	}
+	for i, attr := range []string{"key_algo", "key_secret"} {
+		if !diags[i].AttributePath.Equals(cty.GetAttrPath(attr)) {
+			t.Errorf("expected a diagnostic for %s, got %#v", attr, diags[i].AttributePath)
+		}
+	}
+}
+
+func TestProviderConfigureSig0(t *testing.T) {
+	_, publickey, privatekey := testSig0Key(t, "pipeline.test.internal.")
+
+	d := schema.TestResourceDataRaw(t, Provider().Schema, map[string]interface{}{
+		"server":           "127.0.0.1",
+		"auth_mode":        "sig0",
+		"sig0_public_key":  publickey,
+		"sig0_private_key": privatekey,
+	})
+	m, diags := providerConfigure(context.Background(), d)
+	if diags.HasError() {
+		t.Fatalf("unexpected errors: %v", diags)
+	}
+	if c := m.(*Client); c.AuthMode != authModeSig0 || c.Sig0Signer == nil {
+		t.Errorf("client not in sig0 mode: %s", c.AuthMode)
+	}
+}
+
+func TestProviderConfigureServers(t *testing.T) {
+	config := map[string]interface{}{
+		"servers":    []interface{}{"10.0.0.1", "10.0.0.2"},
+		"key_name":   testKeyName,
+		"key_algo":   testKeyAlgo,
+		"key_secret": testKeySecret,
+	}
+
+	// servers alone
+	t.Setenv("PDNSGLSB_DNSUPDATE_SERVER", "")
+	_, diags := providerConfigure(context.Background(), schema.TestResourceDataRaw(t, Provider().Schema, config))
+	if len(diags) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", diags)
+	}
+
+	// server from the environment is ignored with a warning
+	t.Setenv("PDNSGLSB_DNSUPDATE_SERVER", "10.0.0.3")
+	m, diags := providerConfigure(context.Background(), schema.TestResourceDataRaw(t, Provider().Schema, config))
+	if len(diags) != 1 || diags[0].Severity != diag.Warning || !diags[0].AttributePath.Equals(cty.GetAttrPath("server")) {
+		t.Fatalf("expected a warning on server, got %v", diags)
+	}
+	if c := m.(*Client); c.SrvAddr != "10.0.0.1:53" || len(c.SrvAddrs) != 2 {
+		t.Errorf("unexpected servers: %s %v", c.SrvAddr, c.SrvAddrs)
+	}
+}
+
 func testAccPreCheck(t *testing.T) {
 	if err := os.Getenv("PDNSGLSB_DNSUPDATE_SERVER"); err == "" {
 		t.Fatal("PDNSGLSB_DNSUPDATE_SERVER must be set for acceptance tests")
@@ -44,3 +177,261 @@ func testAccPreCheck(t *testing.T) {
 		t.Fatal("PDNSGLSB_DNSUPDATE_KEYSECRET must be set for acceptance tests")
 	}
 }
+
+func TestProviderConfigureTimeouts(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, Provider().Schema, map[string]interface{}{
+		"server":          "127.0.0.1",
+		"key_name":        testKeyName,
+		"key_algo":        testKeyAlgo,
+		"key_secret":      testKeySecret,
+		"dial_timeout":    "1s",
+		"read_timeout":    "500ms",
+		"write_timeout":   "3s",
+		"retry_max_delay": "1m",
+		"retry_rcodes":    []interface{}{"SERVFAIL", "REFUSED"},
+	})
+	m, diags := providerConfigure(context.Background(), d)
+	if diags.HasError() {
+		t.Fatalf("unexpected errors: %v", diags)
+	}
+
+	c := m.(*Client)
+	if c.DNSClient.DialTimeout != time.Second || c.DNSClient.ReadTimeout != 500*time.Millisecond || c.DNSClient.WriteTimeout != 3*time.Second {
+		t.Errorf("unexpected timeouts: %+v", c.DNSClient)
+	}
+	if c.RetryPolicy.MaxDelay != time.Minute || len(c.RetryPolicy.Rcodes) != 2 {
+		t.Errorf("unexpected retry policy: %+v", c.RetryPolicy)
+	}
+}
+
+func TestOperationDiagnostics(t *testing.T) {
+	d := resourceLua().TestResourceData()
+	d.SetId("test.test.internal.")
+	err := errors.New("read tcp 127.0.0.1:53: i/o timeout")
+
+	diags := operationDiagnostics(context.Background(), d, schema.TimeoutUpdate, d.Id(), err)
+	if len(diags) != 1 || diags[0].Summary != err.Error() {
+		t.Errorf("expected the error, got %v", diags)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	diags = operationDiagnostics(ctx, d, schema.TimeoutUpdate, d.Id(), err)
+	if len(diags) != 1 || !strings.Contains(diags[0].Summary, "Timeout during the update") || !strings.Contains(diags[0].Detail, "timeouts.update") {
+		t.Errorf("expected a timeout of the update phase, got %v", diags)
+	}
+
+	// the read ending a create is reported with the timeout of the create
+	diags = operationDiagnostics(withOperationPhase(ctx, schema.TimeoutCreate), d, schema.TimeoutRead, d.Id(), err)
+	if len(diags) != 1 || !strings.Contains(diags[0].Summary, "Timeout during the create") || !strings.Contains(diags[0].Detail, "timeouts.create") {
+		t.Errorf("expected a timeout of the create phase, got %v", diags)
+	}
+
+	// typed errors come with a remediation hint
+	err = fmt.Errorf("Error updating DNS LUA record: %w", rcodeError(dns.RcodeNotAuth, "127.0.0.1:53"))
+	diags = operationDiagnostics(context.Background(), d, schema.TimeoutUpdate, d.Id(), err)
+	if len(diags) != 1 || !strings.Contains(diags[0].Summary, "Update not authorized during the update") || !strings.Contains(diags[0].Detail, "TSIG-ALLOW-DNSUPDATE") {
+		t.Errorf("expected a NOTAUTH diagnostic with a hint, got %v", diags)
+	}
+}
+
+// testFakeServer starts a fake PowerDNS server with the test.internal. zone
+// and returns a client configured for it
+func testFakeServer(t *testing.T) (*fakepdns.Server, *Client) {
+	srv := fakepdns.New(t, map[string]string{testKeyName: testKeySecret})
+	srv.AddZone("test.internal.")
+
+	c, err := NewClient("127.0.0.1", srv.Port(), "tcp", testKeyName, testKeySecret, testKeyAlgo, 2, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	c.RetryPolicy.BaseDelay = time.Millisecond
+	c.RetryPolicy.MaxDelay = 10 * time.Millisecond
+	return srv, c
+}
+
+// testFakeSnippets returns the rrtypes and snippets of the LUA records of the
+// name on the fake server, "rrtype snippet" sorted
+func testFakeSnippets(t *testing.T, srv *fakepdns.Server, name string) []string {
+	var snippets []string
+	for _, rr := range srv.Records(name, TYPE_LUA) {
+		lua := new(dns.RFC3597)
+		if err := lua.ToRFC3597(rr); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		rrtype, _ := strconv.ParseUint(lua.Rdata[0:4], 16, 16)
+		snippet, _ := hex.DecodeString(lua.Rdata[6:])
+		snippets = append(snippets, dns.TypeToString[uint16(rrtype)]+" "+string(snippet))
+	}
+	sort.Strings(snippets)
+	return snippets
+}
+
+// testResourceLifecycle creates, reads, updates and deletes the resource on the
+// fake server, the LUA records are checked after each step
+func testResourceLifecycle(t *testing.T, r *schema.Resource, create, update map[string]interface{}, created, updated []string) {
+	srv, c := testFakeServer(t)
+	ctx := context.Background()
+	recordId := fmt.Sprintf("%s.%s", create["name"], create["zone"])
+
+	d := schema.TestResourceDataRaw(t, r.Schema, create)
+	if diags := r.CreateContext(ctx, d, c); diags.HasError() {
+		t.Fatalf("create: %v", diags)
+	}
+	if d.Id() != recordId {
+		t.Errorf("expected id %s, got %s", recordId, d.Id())
+	}
+	if got := testFakeSnippets(t, srv, recordId); !reflect.DeepEqual(got, created) {
+		t.Errorf("create: expected %q, got %q", created, got)
+	}
+
+	d = schema.TestResourceDataRaw(t, r.Schema, update)
+	d.SetId(recordId)
+	if diags := r.UpdateContext(ctx, d, c); diags.HasError() {
+		t.Fatalf("update: %v", diags)
+	}
+	if got := testFakeSnippets(t, srv, recordId); !reflect.DeepEqual(got, updated) {
+		t.Errorf("update: expected %q, got %q", updated, got)
+	}
+	if records := d.Get("record").(*schema.Set).List(); len(records) == 0 {
+		t.Errorf("update: the records were not read back")
+	}
+
+	if diags := r.DeleteContext(ctx, d, c); diags.HasError() {
+		t.Fatalf("delete: %v", diags)
+	}
+	if got := srv.Records(recordId, dns.TypeANY); len(got) != 0 {
+		t.Errorf("delete: expected no record, got %v", got)
+	}
+}
+
+func TestValidateRecordAddresses(t *testing.T) {
+	for name, tc := range map[string]struct {
+		resource string
+		record   map[string]interface{}
+		expected string
+	}{
+		"pickrandom ipv4": {"powerdns-gslb_pickrandom", map[string]interface{}{
+			"rrtype": "A", "addresses": []interface{}{"192.168.1.1", "192.168.1.2"},
+		}, ""},
+		"pickrandom ipv6 in A": {"powerdns-gslb_pickrandom", map[string]interface{}{
+			"rrtype": "A", "addresses": []interface{}{"192.168.1.1", "2001:db8::1"},
+		}, "not an IPv4 address"},
+		"pickrandom ipv4 in AAAA": {"powerdns-gslb_pickrandom", map[string]interface{}{
+			"rrtype": "AAAA", "addresses": []interface{}{"192.168.1.1"},
+		}, "not an IPv6 address"},
+		"pickrandom duplicate": {"powerdns-gslb_pickrandom", map[string]interface{}{
+			"rrtype": "AAAA", "addresses": []interface{}{"2001:db8::1", "2001:DB8:0::1"},
+		}, "duplicated"},
+		"pickrandom dual stack": {"powerdns-gslb_pickrandom", map[string]interface{}{
+			"rrtype": "A+AAAA", "addresses": []interface{}{"192.168.1.1", "2001:db8::1"},
+		}, ""},
+		"pickrandom ipv4-mapped in A+AAAA": {"powerdns-gslb_pickrandom", map[string]interface{}{
+			"rrtype": "A+AAAA", "addresses": []interface{}{"192.168.1.1", "::ffff:192.168.1.2"},
+		}, "use 192.168.1.2 with rrtype A+AAAA"},
+		"pickrandom invalid": {"powerdns-gslb_pickrandom", map[string]interface{}{
+			"rrtype": "A", "addresses": []interface{}{"192.168.1.300"},
+		}, "to contain a valid IP"},
+		"ifportup port": {"powerdns-gslb_ifportup", map[string]interface{}{
+			"rrtype": "A", "port": 70000, "addresses": []interface{}{"192.168.1.1"},
+		}, "port"},
+		"ifportup duplicate": {"powerdns-gslb_ifportup", map[string]interface{}{
+			"rrtype": "A", "port": 443, "addresses": []interface{}{"192.168.1.1", "192.168.1.1"},
+		}, "duplicated"},
+		"ifurlup backup duplicate": {"powerdns-gslb_ifurlup", map[string]interface{}{
+			"rrtype": "A", "url": "https://www.example.com/",
+			"addresses": []interface{}{map[string]interface{}{"primary": []interface{}{"10.0.0.1"}, "backup": []interface{}{"10.0.0.1"}}},
+		}, "duplicated"},
+		"ifurlup ipv6 in A": {"powerdns-gslb_ifurlup", map[string]interface{}{
+			"rrtype": "A", "url": "https://www.example.com/",
+			"addresses": []interface{}{map[string]interface{}{"primary": []interface{}{"10.0.0.1"}, "backup": []interface{}{"::1"}}},
+		}, "not an IPv4 address"},
+		"ifurlup snippet length": {"powerdns-gslb_ifurlup", map[string]interface{}{
+			"rrtype": "A", "url": "https://www.example.com/" + strings.Repeat("a", 255),
+			"addresses": []interface{}{map[string]interface{}{"primary": []interface{}{"10.0.0.1"}, "backup": []interface{}{}}},
+		}, "longer than 255 bytes"},
+		"pickrandom snippet length": {"powerdns-gslb_pickrandom", map[string]interface{}{
+			"rrtype": "A+AAAA", "addresses": testAddresses("192.168.1.%d", 20),
+		}, "record A: Error LUA snippet of 304 bytes"},
+		"lua snippet length": {"powerdns-gslb_lua", map[string]interface{}{
+			"rrtype": "TXT", "snippet": strings.Repeat("a", 256),
+		}, "expected length of snippet"},
+		"pickwrandom weight": {"powerdns-gslb_pickwrandom", map[string]interface{}{
+			"rrtype": "A", "ipaddress": []interface{}{map[string]interface{}{"weight": 0, "ip": "192.168.1.1"}},
+		}, "weight"},
+		"pickwrandom ipv4 in AAAA": {"powerdns-gslb_pickwrandom", map[string]interface{}{
+			"rrtype": "AAAA", "ipaddress": []interface{}{map[string]interface{}{"weight": 10, "ip": "::ffff:192.168.1.1"}},
+		}, "not an IPv6 address"},
+	} {
+		config := map[string]interface{}{
+			"zone":   "test.internal.",
+			"name":   "test",
+			"record": []interface{}{tc.record},
+		}
+
+		var err error
+		if diags := Provider().ResourcesMap[tc.resource].Validate(terraform.NewResourceConfigRaw(config)); diags.HasError() {
+			err = fmt.Errorf("%s: %s", diags[0].Summary, diags[0].Detail)
+		} else {
+			err = testPlanCreate(t, tc.resource, config)
+		}
+
+		switch {
+		case tc.expected == "" && err != nil:
+			t.Errorf("%s: unexpected error %s", name, err)
+		case tc.expected != "" && err == nil:
+			t.Errorf("%s: expected an error", name)
+		case tc.expected != "" && !strings.Contains(err.Error(), tc.expected):
+			t.Errorf("%s: expected %q in %s", name, tc.expected, err)
+		}
+	}
+}
+
+// testAddresses returns n addresses of the format
+func testAddresses(format string, n int) []interface{} {
+	var addresses []interface{}
+	for i := 1; i <= n; i++ {
+		addresses = append(addresses, fmt.Sprintf(format, i))
+	}
+	return addresses
+}
+
+// testPlanCreate plans the creation of a resource through the grpc server of
+// the provider as terraform does, and returns the error of the plan
+func testPlanCreate(t *testing.T, typeName string, config map[string]interface{}) error {
+	block := Provider().ResourcesMap[typeName].CoreConfigSchema()
+	ty := block.ImpliedType()
+
+	raw, err := json.Marshal(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	value, err := ctyjson.Unmarshal(raw, ty)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	proposed, err := msgpack.Marshal(value, ty)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	prior, err := msgpack.Marshal(cty.NullVal(ty), ty)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	resp, err := schema.NewGRPCProviderServer(Provider()).PlanResourceChange(context.Background(), &tfprotov5.PlanResourceChangeRequest{
+		TypeName:         typeName,
+		PriorState:       &tfprotov5.DynamicValue{MsgPack: prior},
+		ProposedNewState: &tfprotov5.DynamicValue{MsgPack: proposed},
+		Config:           &tfprotov5.DynamicValue{MsgPack: proposed},
+	})
+	if err != nil {
+		return err
+	}
+	for _, d := range resp.Diagnostics {
+		if d.Severity == tfprotov5.DiagnosticSeverityError {
+			return fmt.Errorf("%s: %s", d.Summary, d.Detail)
+		}
+	}
+	return nil
+}
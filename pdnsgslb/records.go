@@ -0,0 +1,267 @@
+package pdnsgslb
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// rrtypeDualStack is the rrtype of a typed record with IPv4 and IPv6
+// addresses, split into an A and an AAAA LUA record.
+const rrtypeDualStack = "A+AAAA"
+
+// addressFamilies are the rrtypes of a dual stack record with the addresses
+// they answer
+var addressFamilies = []struct {
+	rrtype string
+	match  func(netip.Addr) bool
+}{
+	{"A", func(addr netip.Addr) bool { return addr.Is4() }},
+	{"AAAA", func(addr netip.Addr) bool { return addr.Is6() && !addr.Is4In6() }},
+}
+
+// splitDualStack returns the records with each dual stack record replaced by
+// an A record and an AAAA record, a family without address is left out.
+func splitDualStack(records []interface{}) []interface{} {
+	var split []interface{}
+	for _, rr := range records {
+		rec := rr.(map[string]interface{})
+		if rec["rrtype"] != rrtypeDualStack {
+			split = append(split, rec)
+			continue
+		}
+
+		for _, family := range addressFamilies {
+			lists := make(map[string][]interface{})
+			count := 0
+			for location, list := range addressLists(rec) {
+				filtered := []interface{}{}
+				for _, v := range list {
+					if addr, err := netip.ParseAddr(addressOf(v)); err == nil && family.match(addr) {
+						filtered = append(filtered, v)
+					}
+				}
+				lists[location] = filtered
+				count += len(filtered)
+			}
+			if count == 0 {
+				continue
+			}
+
+			record := withAddressLists(rec, lists)
+			record["rrtype"] = family.rrtype
+			split = append(split, record)
+		}
+	}
+	return split
+}
+
+// mergeDualStack returns the records read from the server with the A and AAAA
+// records merged back into the dual stack records of the previous state. The
+// order of the previous addresses is kept when the server has the same ones.
+func mergeDualStack(records []interface{}, previous []interface{}) []interface{} {
+	for _, p := range previous {
+		prev, ok := p.(map[string]interface{})
+		if !ok || prev["rrtype"] != rrtypeDualStack {
+			continue
+		}
+
+		// the records of the families with the same settings
+		var parts []int
+		for _, family := range addressFamilies {
+			for i, rr := range records {
+				rec := rr.(map[string]interface{})
+				if rec["rrtype"] == family.rrtype && sameSettings(rec, prev) {
+					parts = append(parts, i)
+					break
+				}
+			}
+		}
+		if len(parts) == 0 {
+			continue
+		}
+
+		lists := make(map[string][]interface{})
+		for _, i := range parts {
+			for location, list := range addressLists(records[i].(map[string]interface{})) {
+				lists[location] = append(lists[location], list...)
+			}
+		}
+		if sameAddresses(lists, addressLists(prev)) {
+			lists = addressLists(prev)
+		}
+		merged := withAddressLists(records[parts[0]].(map[string]interface{}), lists)
+		merged["rrtype"] = rrtypeDualStack
+
+		// the merged record takes the place of the first part
+		var result []interface{}
+		for i, rr := range records {
+			switch {
+			case i == parts[0]:
+				result = append(result, merged)
+			case len(parts) == 2 && i == parts[1]:
+			default:
+				result = append(result, rr)
+			}
+		}
+		records = result
+	}
+	return records
+}
+
+// addressLists returns the address lists of a typed record by location, the
+// addresses list, the primary and backup lists, or the weighted addresses.
+func addressLists(record map[string]interface{}) map[string][]interface{} {
+	lists := make(map[string][]interface{})
+	if addresses, ok := record["addresses"].([]interface{}); ok {
+		if len(addresses) > 0 {
+			if group, ok := addresses[0].(map[string]interface{}); ok {
+				lists["primary"] = toList(group["primary"])
+				lists["backup"] = toList(group["backup"])
+				return lists
+			}
+		}
+		lists["addresses"] = addresses
+	}
+	if weighted, ok := record["ipaddress"].([]interface{}); ok {
+		lists["ipaddress"] = weighted
+	}
+	return lists
+}
+
+// withAddressLists returns a copy of the record with the address lists
+func withAddressLists(record map[string]interface{}, lists map[string][]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(record))
+	for k, v := range record {
+		copied[k] = v
+	}
+	for location, list := range lists {
+		switch location {
+		case "primary", "backup":
+			copied["addresses"] = []interface{}{map[string]interface{}{
+				"primary": toList(lists["primary"]),
+				"backup":  toList(lists["backup"]),
+			}}
+		default:
+			copied[location] = list
+		}
+	}
+	return copied
+}
+
+// addressOf returns the address of an element of an address list, a string
+// or a weighted address
+func addressOf(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case map[string]interface{}:
+		ip, _ := value["ip"].(string)
+		return ip
+	}
+	return ""
+}
+
+// sameSettings returns true when the record read has the settings of the
+// previous record, the rrtype and the addresses excepted
+func sameSettings(record, previous map[string]interface{}) bool {
+	for k, v := range record {
+		switch k {
+		case "rrtype", "addresses", "ipaddress":
+			continue
+		}
+		if fmt.Sprint(v) != fmt.Sprint(previous[k]) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameAddresses returns true when the address lists have the same elements
+// in any order
+func sameAddresses(a, b map[string][]interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for location, list := range a {
+		if !sameElements(list, b[location]) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameElements(a, b []interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	values := func(list []interface{}) []string {
+		var s []string
+		for _, v := range list {
+			s = append(s, fmt.Sprint(v))
+		}
+		sort.Strings(s)
+		return s
+	}
+	va, vb := values(a), values(b)
+	for i := range va {
+		if va[i] != vb[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// toList returns the list of an interface, an empty list for nil
+func toList(v interface{}) []interface{} {
+	list, _ := v.([]interface{})
+	if list == nil {
+		return []interface{}{}
+	}
+	return list
+}
+
+// recordStateUpgraders returns the state upgraders of a resource from the
+// version 0, the records were a list ordered as the server returned them.
+func recordStateUpgraders(current map[string]*schema.Schema) []schema.StateUpgrader {
+	v0 := make(map[string]*schema.Schema, len(current))
+	for k, v := range current {
+		v0[k] = v
+	}
+	record := *current["record"]
+	record.Type = schema.TypeList
+	v0["record"] = &record
+
+	return []schema.StateUpgrader{
+		{
+			Version: 0,
+			Type:    (&schema.Resource{Schema: v0}).CoreConfigSchema().ImpliedType(),
+			Upgrade: upgradeRecordsV0,
+		},
+	}
+}
+
+// upgradeRecordsV0 moves the list of records to a set, the duplicated records
+// are merged
+func upgradeRecordsV0(ctx context.Context, rawState map[string]interface{}, meta interface{}) (map[string]interface{}, error) {
+	records, ok := rawState["record"].([]interface{})
+	if !ok {
+		return rawState, nil
+	}
+
+	var unique []interface{}
+	seen := make(map[string]bool)
+	for _, record := range records {
+		key := fmt.Sprint(record)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, record)
+	}
+	rawState["record"] = unique
+	return rawState, nil
+}
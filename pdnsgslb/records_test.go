@@ -0,0 +1,153 @@
+package pdnsgslb
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestSplitDualStack(t *testing.T) {
+	records := []interface{}{
+		map[string]interface{}{"rrtype": rrtypeDualStack, "ttl": 30, "addresses": []interface{}{"192.168.1.1", "2001:db8::1", "192.168.1.2"}},
+		map[string]interface{}{"rrtype": rrtypeDualStack, "ttl": 30, "addresses": []interface{}{"2001:db8::2"}},
+		map[string]interface{}{"rrtype": "A", "ttl": 60, "addresses": []interface{}{"192.168.1.3"}},
+	}
+	expected := []interface{}{
+		map[string]interface{}{"rrtype": "A", "ttl": 30, "addresses": []interface{}{"192.168.1.1", "192.168.1.2"}},
+		map[string]interface{}{"rrtype": "AAAA", "ttl": 30, "addresses": []interface{}{"2001:db8::1"}},
+		map[string]interface{}{"rrtype": "AAAA", "ttl": 30, "addresses": []interface{}{"2001:db8::2"}},
+		map[string]interface{}{"rrtype": "A", "ttl": 60, "addresses": []interface{}{"192.168.1.3"}},
+	}
+	if got := splitDualStack(records); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	// the primary and backup addresses are split together
+	ifurlup := []interface{}{
+		map[string]interface{}{"rrtype": rrtypeDualStack, "url": "https://www.example.com/", "addresses": []interface{}{
+			map[string]interface{}{"primary": []interface{}{"10.0.0.1", "2001:db8::1"}, "backup": []interface{}{"2001:db8::2"}},
+		}},
+	}
+	expected = []interface{}{
+		map[string]interface{}{"rrtype": "A", "url": "https://www.example.com/", "addresses": []interface{}{
+			map[string]interface{}{"primary": []interface{}{"10.0.0.1"}, "backup": []interface{}{}},
+		}},
+		map[string]interface{}{"rrtype": "AAAA", "url": "https://www.example.com/", "addresses": []interface{}{
+			map[string]interface{}{"primary": []interface{}{"2001:db8::1"}, "backup": []interface{}{"2001:db8::2"}},
+		}},
+	}
+	if got := splitDualStack(ifurlup); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestMergeDualStack(t *testing.T) {
+	read := []interface{}{
+		map[string]interface{}{"rrtype": "A", "ttl": uint32(30), "ipaddress": []interface{}{map[string]interface{}{"weight": 10, "ip": "192.168.1.1"}}},
+		map[string]interface{}{"rrtype": "AAAA", "ttl": uint32(30), "ipaddress": []interface{}{map[string]interface{}{"weight": 20, "ip": "2001:db8::1"}}},
+	}
+
+	// the order of the previous addresses is kept
+	previous := []interface{}{
+		map[string]interface{}{"rrtype": rrtypeDualStack, "ttl": 30, "ipaddress": []interface{}{
+			map[string]interface{}{"weight": 20, "ip": "2001:db8::1"},
+			map[string]interface{}{"weight": 10, "ip": "192.168.1.1"},
+		}},
+	}
+	expected := []interface{}{
+		map[string]interface{}{"rrtype": rrtypeDualStack, "ttl": uint32(30), "ipaddress": []interface{}{
+			map[string]interface{}{"weight": 20, "ip": "2001:db8::1"},
+			map[string]interface{}{"weight": 10, "ip": "192.168.1.1"},
+		}},
+	}
+	if got := mergeDualStack(read, previous); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	// the addresses changed on the server
+	previous[0].(map[string]interface{})["ipaddress"] = []interface{}{map[string]interface{}{"weight": 10, "ip": "192.168.1.1"}}
+	expected = []interface{}{
+		map[string]interface{}{"rrtype": rrtypeDualStack, "ttl": uint32(30), "ipaddress": []interface{}{
+			map[string]interface{}{"weight": 10, "ip": "192.168.1.1"},
+			map[string]interface{}{"weight": 20, "ip": "2001:db8::1"},
+		}},
+	}
+	if got := mergeDualStack(read, previous); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	// separate A and AAAA records are not merged
+	previous = []interface{}{
+		map[string]interface{}{"rrtype": "A", "ttl": 30},
+		map[string]interface{}{"rrtype": "AAAA", "ttl": 30},
+	}
+	if got := mergeDualStack(read, previous); !reflect.DeepEqual(got, read) {
+		t.Errorf("expected %v, got %v", read, got)
+	}
+}
+
+func TestPdnsgslbPickrandom_fakeDualStack(t *testing.T) {
+	srv, c := testFakeServer(t)
+	r := resourcePickRandom()
+	d := schema.TestResourceDataRaw(t, r.Schema, map[string]interface{}{
+		"zone": "test.internal.",
+		"name": "dualstack",
+		"record": []interface{}{
+			map[string]interface{}{"rrtype": rrtypeDualStack, "ttl": 5, "addresses": []interface{}{"2001:db8::1", "127.0.0.1", "127.0.0.2"}},
+		},
+	})
+	if diags := r.CreateContext(context.Background(), d, c); diags.HasError() {
+		t.Fatalf("create: %v", diags)
+	}
+
+	expected := []string{"A pickrandom({'127.0.0.1','127.0.0.2'})", "AAAA pickrandom({'2001:db8::1'})"}
+	if got := testFakeSnippets(t, srv, d.Id()); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	records := d.Get("record").(*schema.Set).List()
+	if len(records) != 1 {
+		t.Fatalf("expected one merged record, got %v", records)
+	}
+	record := records[0].(map[string]interface{})
+	if record["rrtype"] != rrtypeDualStack || !reflect.DeepEqual(record["addresses"], []interface{}{"2001:db8::1", "127.0.0.1", "127.0.0.2"}) {
+		t.Errorf("unexpected merged record %v", record)
+	}
+}
+
+func TestUpgradeRecordsV0(t *testing.T) {
+	upgraders := resourcePickRandom().StateUpgraders
+	if len(upgraders) != 1 || upgraders[0].Version != 0 {
+		t.Fatalf("unexpected state upgraders %v", upgraders)
+	}
+	if ty := upgraders[0].Type.AttributeType("record"); !ty.IsListType() {
+		t.Errorf("expected the records of the version 0 to be a list, got %s", ty.FriendlyName())
+	}
+
+	a := map[string]interface{}{"rrtype": "A", "ttl": 5, "addresses": []interface{}{"127.0.0.1"}}
+	aaaa := map[string]interface{}{"rrtype": "AAAA", "ttl": 5, "addresses": []interface{}{"::1"}}
+	state, err := upgraders[0].Upgrade(context.Background(), map[string]interface{}{
+		"id":     "test.test.internal.",
+		"record": []interface{}{a, aaaa, a},
+	}, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if expected := []interface{}{a, aaaa}; !reflect.DeepEqual(state["record"], expected) {
+		t.Errorf("expected %v, got %v", expected, state["record"])
+	}
+}
+
+func TestRecordsOrderInsensitive(t *testing.T) {
+	r := resourceLua()
+	a := map[string]interface{}{"rrtype": "A", "ttl": 30, "snippet": "'192.168.1.1'"}
+	txt := map[string]interface{}{"rrtype": "TXT", "ttl": 30, "snippet": "os.date()"}
+
+	first := schema.TestResourceDataRaw(t, r.Schema, map[string]interface{}{"zone": "test.internal.", "name": "test", "record": []interface{}{a, txt}})
+	second := schema.TestResourceDataRaw(t, r.Schema, map[string]interface{}{"zone": "test.internal.", "name": "test", "record": []interface{}{txt, a}})
+	if !first.Get("record").(*schema.Set).Equal(second.Get("record")) {
+		t.Errorf("the records in another order differ")
+	}
+}
@@ -2,7 +2,6 @@ package pdnsgslb
 
 import (
 	"context"
-	"encoding/hex"
 	"fmt"
 	"regexp"
 	"strconv"
@@ -10,18 +9,19 @@ import (
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 	"github.com/miekg/dns"
 )
 
 func resourceIfPortUp() *schema.Resource {
-	return &schema.Resource{
+	r := &schema.Resource{
 		CreateContext: resourceIfPortUpCreate,
 		ReadContext:   resourceIfPortUpRead,
 		UpdateContext: resourceIfPortUpUpdate,
 		DeleteContext: resourceIfPortUpDelete,
-		Importer: &schema.ResourceImporter{
-			StateContext: schema.ImportStatePassthroughContext,
-		},
+		Timeouts:      resourceTimeouts(),
+		SchemaVersion: 1,
+		Importer:      importRecord("powerdns-gslb_ifportup", ifPortUpFromLuaSnippet),
 		Schema: map[string]*schema.Schema{
 			"zone": {
 				Type:     schema.TypeString,
@@ -33,8 +33,13 @@ func resourceIfPortUp() *schema.Resource {
 				Required: true,
 				ForceNew: true,
 			},
+			"adopt_existing": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
 			"record": {
-				Type:     schema.TypeList,
+				Type:     schema.TypeSet,
 				Required: true,
 				Elem: &schema.Resource{
 					Schema: map[string]*schema.Schema{
@@ -43,13 +48,14 @@ func resourceIfPortUp() *schema.Resource {
 							Required: true,
 						},
 						"port": {
-							Type:     schema.TypeInt,
-							Required: true,
+							Type:             schema.TypeInt,
+							Required:         true,
+							ValidateDiagFunc: validation.ToDiagFunc(validation.IsPortNumber),
 						},
 						"addresses": {
 							Type:     schema.TypeList,
 							Required: true,
-							Elem:     &schema.Schema{Type: schema.TypeString},
+							Elem:     &schema.Schema{Type: schema.TypeString, ValidateDiagFunc: validation.ToDiagFunc(validation.IsIPAddress)},
 						},
 						"ttl": {
 							Type:     schema.TypeInt,
@@ -66,6 +72,11 @@ func resourceIfPortUp() *schema.Resource {
 			},
 		},
 	}
+
+	// the records were a list in the version 0
+	r.StateUpgraders = recordStateUpgraders(r.Schema)
+	r.CustomizeDiff = validateRecords(r.Schema, ifPortUpToLuaSnippet)
+	return r
 }
 
 func resourceIfPortUpCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
@@ -81,18 +92,22 @@ func resourceIfPortUpCreate(ctx context.Context, d *schema.ResourceData, m inter
 	recordId := fmt.Sprintf("%s.%s", name, zone)
 
 	// get records and transform to lua snippets
-	records := d.Get("record").([]interface{})
-	rrset := ifPortUpToLuaSnippet(records)
+	records := d.Get("record").(*schema.Set).List()
+	rrset := ifPortUpToLuaSnippet(splitDualStack(records))
+
+	// take over an existing rrset if requested by the provider or the resource
+	adopt := c.AdoptExisting || d.Get("adopt_existing").(bool)
 
 	// make dns update operation
-	_, err := c.doCreate(recordId, rrset)
+	server, err := c.doCreate(ctx, recordId, rrset, adopt)
 	if err != nil {
-		return diag.FromErr(err)
+		return operationDiagnostics(ctx, d, schema.TimeoutCreate, recordId, err)
 	}
 
 	d.SetId(recordId)
 
-	return resourceIfPortUpRead(ctx, d, m)
+	diags := failoverDiagnostics(c, server, "create", recordId)
+	return append(diags, resourceIfPortUpRead(withOperationPhase(ctx, schema.TimeoutCreate), d, m)...)
 }
 
 func resourceIfPortUpRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
@@ -113,67 +128,37 @@ func resourceIfPortUpRead(ctx context.Context, d *schema.ResourceData, m interfa
 	zone := strings.Join(labels[1:], ".") + "."
 	name := labels[0]
 
-	rr_lua, err := c.doTransfer(recordId)
+	rr_lua, server, err := c.doTransfer(ctx, recordId)
 	if err != nil {
-		return diag.FromErr(err)
+		return operationDiagnostics(ctx, d, schema.TimeoutRead, recordId, err)
 	}
+	diags = append(diags, failoverDiagnostics(c, server, "read", recordId)...)
 
 	var records []interface{}
+	var parseErrors []string
 	for _, rr := range rr_lua {
 		// decode lua
-		rrtype_int, _ := strconv.ParseInt(rr.Rdata[0:4], 16, 64)
-		rrtype := dns.TypeToString[uint16(rrtype_int)]
-		snippet, _ := hex.DecodeString(rr.Rdata[6:])
-
-		// search pickrandom function in snippet
-		re := regexp.MustCompile(`ifportup\((?P<port>\d+),\s*{(?P<addrs>.*)},\s*{(?P<options>.*)}\)`)
-		matches_func := re.FindStringSubmatch(string(snippet))
-
-		// no match, ignore record
-		if len(matches_func) == 0 {
-			continue
-		}
-
-		// get port parameter
-		port := matches_func[re.SubexpIndex("port")]
-		port_int, _ := strconv.Atoi(port)
-
-		// ok, continue to decode addresses parameters
-		re2 := regexp.MustCompile(`(?U)'(?P<ip>.*)'`)
-		addrs := matches_func[re.SubexpIndex("addrs")]
-		matches_opt := re2.FindAllStringSubmatch(addrs, -1)
+		rrtype, snippet := decodeLua(rr)
 
-		var addresses []string
-		for _, match := range matches_opt {
-			addresses = append(addresses, match[re2.SubexpIndex("ip")])
-		}
-
-		// continue to decode settings
-		re4 := regexp.MustCompile(`timeout=(?P<timeout>.*)`)
-		options := matches_func[re.SubexpIndex("options")]
-		matches_opts := re4.FindStringSubmatch(options)
-
-		// no match, ignore record
-		if len(matches_opts) == 0 {
+		urr, err := ifPortUpFromLuaSnippet(snippet)
+		if err != nil {
+			// not a ifportup record, ignore record
+			parseErrors = append(parseErrors, err.Error())
 			continue
 		}
-		timeout_str := matches_opts[re4.SubexpIndex("timeout")]
-		timeout, _ := strconv.Atoi(timeout_str)
-
-		urr := make(map[string]interface{})
 		urr["rrtype"] = rrtype
-		urr["addresses"] = addresses
-		urr["port"] = port_int
 		urr["ttl"] = rr.Hdr.Ttl
-		urr["timeout"] = timeout
 
 		records = append(records, urr)
 	}
 
 	if len(records) == 0 {
-		return diag.Errorf("No LUA records detected")
+		return noRecordsDiagnostics(recordId, parseErrors)
 	}
 
+	// merge the A and AAAA records of the dual stack records
+	records = mergeDualStack(records, d.Get("record").(*schema.Set).List())
+
 	d.Set("zone", zone)
 	d.Set("name", name)
 	if err := d.Set("record", records); err != nil {
@@ -190,19 +175,23 @@ func resourceIfPortUpUpdate(ctx context.Context, d *schema.ResourceData, m inter
 	// get ressource id
 	recordId := d.Id()
 
+	// warning or errors can be collected in a slice type
+	var diags diag.Diagnostics
+
 	if d.HasChange("record") {
 		// get records and transform to lua snippets
-		records := d.Get("record").([]interface{})
-		rrset := ifPortUpToLuaSnippet(records)
+		records := d.Get("record").(*schema.Set).List()
+		rrset := ifPortUpToLuaSnippet(splitDualStack(records))
 
 		// make dns update operation
-		_, err := c.doUpdate(recordId, rrset)
+		server, err := c.doUpdate(ctx, recordId, rrset)
 		if err != nil {
-			return diag.FromErr(err)
+			return operationDiagnostics(ctx, d, schema.TimeoutUpdate, recordId, err)
 		}
+		diags = append(diags, failoverDiagnostics(c, server, "update", recordId)...)
 	}
 
-	return resourceIfPortUpRead(ctx, d, m)
+	return append(diags, resourceIfPortUpRead(withOperationPhase(ctx, schema.TimeoutUpdate), d, m)...)
 }
 
 func resourceIfPortUpDelete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
@@ -216,10 +205,11 @@ func resourceIfPortUpDelete(ctx context.Context, d *schema.ResourceData, m inter
 	var diags diag.Diagnostics
 
 	// make dns delete operation
-	_, err := c.doDelete(recordId)
+	server, err := c.doDelete(ctx, recordId)
 	if err != nil {
-		return diag.FromErr(err)
+		return operationDiagnostics(ctx, d, schema.TimeoutDelete, recordId, err)
 	}
+	diags = append(diags, failoverDiagnostics(c, server, "delete", recordId)...)
 
 	// added here for explicitness, automatically called assuming delete returns no errors
 	d.SetId("")
@@ -258,3 +248,43 @@ func ifPortUpToLuaSnippet(records []interface{}) []interface{} {
 	}
 	return rrset
 }
+
+// ifPortUpFromLuaSnippet parses an ifportup snippet into the fields of a
+// record, without the rrtype and the ttl
+func ifPortUpFromLuaSnippet(snippet string) (map[string]interface{}, error) {
+	// search ifportup function in snippet
+	re := regexp.MustCompile(`ifportup\((?P<port>\d+),\s*{(?P<addrs>.*)},\s*{(?P<options>.*)}\)`)
+	matches_func := re.FindStringSubmatch(snippet)
+	if len(matches_func) == 0 {
+		return nil, fmt.Errorf("Error snippet is not an ifportup function: %q", snippet)
+	}
+
+	// get port parameter
+	port := matches_func[re.SubexpIndex("port")]
+	port_int, _ := strconv.Atoi(port)
+
+	// ok, continue to decode addresses parameters
+	re2 := regexp.MustCompile(`(?U)'(?P<ip>.*)'`)
+	addrs := matches_func[re.SubexpIndex("addrs")]
+	matches_opt := re2.FindAllStringSubmatch(addrs, -1)
+
+	addresses := []interface{}{}
+	for _, match := range matches_opt {
+		addresses = append(addresses, match[re2.SubexpIndex("ip")])
+	}
+
+	// continue to decode settings
+	re4 := regexp.MustCompile(`timeout=(?P<timeout>\d+)`)
+	options := matches_func[re.SubexpIndex("options")]
+	matches_opts := re4.FindStringSubmatch(options)
+	if len(matches_opts) == 0 {
+		return nil, fmt.Errorf("Error no timeout option in ifportup snippet: %q", snippet)
+	}
+	timeout, _ := strconv.Atoi(matches_opts[re4.SubexpIndex("timeout")])
+
+	urr := make(map[string]interface{})
+	urr["addresses"] = addresses
+	urr["port"] = port_int
+	urr["timeout"] = timeout
+	return urr, nil
+}
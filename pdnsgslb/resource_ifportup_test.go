@@ -1,6 +1,7 @@
 package pdnsgslb
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
@@ -24,6 +25,26 @@ func TestAccPdnsgslbIfportup_basic(t *testing.T) {
 	})
 }
 
+func TestPdnsgslbIfportup_fake(t *testing.T) {
+	testResourceLifecycle(t, resourceIfPortUp(), map[string]interface{}{
+		"zone": "test.internal.",
+		"name": "ifportup",
+		"record": []interface{}{
+			map[string]interface{}{"rrtype": "A", "ttl": 5, "port": 443, "addresses": []interface{}{"127.0.0.1", "127.0.0.2"}, "timeout": 10},
+		},
+	}, map[string]interface{}{
+		"zone": "test.internal.",
+		"name": "ifportup",
+		"record": []interface{}{
+			map[string]interface{}{"rrtype": "A", "ttl": 5, "port": 8443, "addresses": []interface{}{"127.0.0.1"}, "timeout": 2},
+		},
+	}, []string{
+		"A ifportup(443, {'127.0.0.1','127.0.0.2'},{timeout=10})",
+	}, []string{
+		"A ifportup(8443, {'127.0.0.1'},{timeout=2})",
+	})
+}
+
 func testAccCheckPdnsgslbIfportupDestroy(s *terraform.State) error {
 	c := testAccProvider.Meta().(*Client)
 
@@ -34,7 +55,7 @@ func testAccCheckPdnsgslbIfportupDestroy(s *terraform.State) error {
 
 		recordId := rs.Primary.ID
 
-		_, err := c.doDelete(recordId)
+		_, err := c.doDelete(context.Background(), recordId)
 		if err != nil {
 			return err
 		}
@@ -56,7 +77,7 @@ func testAccCheckPdnsgslbIfportupExists(n string) resource.TestCheckFunc {
 		}
 
 		c := testAccProvider.Meta().(*Client)
-		rr_lua, err := c.doTransfer(rs.Primary.ID)
+		rr_lua, _, err := c.doTransfer(context.Background(), rs.Primary.ID)
 		if err != nil {
 			return err
 		}
@@ -2,7 +2,6 @@ package pdnsgslb
 
 import (
 	"context"
-	"encoding/hex"
 	"fmt"
 	"regexp"
 	"strconv"
@@ -10,18 +9,19 @@ import (
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 	"github.com/miekg/dns"
 )
 
 func resourceIfUrlUp() *schema.Resource {
-	return &schema.Resource{
+	r := &schema.Resource{
 		CreateContext: resourceIfUrlUpCreate,
 		ReadContext:   resourceIfUrlUpRead,
 		UpdateContext: resourceIfUrlUpUpdate,
 		DeleteContext: resourceIfUrlUpDelete,
-		Importer: &schema.ResourceImporter{
-			StateContext: schema.ImportStatePassthroughContext,
-		},
+		Timeouts:      resourceTimeouts(),
+		SchemaVersion: 1,
+		Importer:      importRecord("powerdns-gslb_ifurlup", ifUrlUpFromLuaSnippet),
 		Schema: map[string]*schema.Schema{
 			"zone": {
 				Type:     schema.TypeString,
@@ -33,8 +33,13 @@ func resourceIfUrlUp() *schema.Resource {
 				Required: true,
 				ForceNew: true,
 			},
+			"adopt_existing": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
 			"record": {
-				Type:     schema.TypeList,
+				Type:     schema.TypeSet,
 				Required: true,
 				Elem: &schema.Resource{
 					Schema: map[string]*schema.Schema{
@@ -55,12 +60,12 @@ func resourceIfUrlUp() *schema.Resource {
 									"primary": {
 										Type:     schema.TypeList,
 										Required: true,
-										Elem:     &schema.Schema{Type: schema.TypeString},
+										Elem:     &schema.Schema{Type: schema.TypeString, ValidateDiagFunc: validation.ToDiagFunc(validation.IsIPAddress)},
 									},
 									"backup": {
 										Type:     schema.TypeList,
 										Required: true,
-										Elem:     &schema.Schema{Type: schema.TypeString},
+										Elem:     &schema.Schema{Type: schema.TypeString, ValidateDiagFunc: validation.ToDiagFunc(validation.IsIPAddress)},
 									},
 								},
 							},
@@ -85,6 +90,11 @@ func resourceIfUrlUp() *schema.Resource {
 			},
 		},
 	}
+
+	// the records were a list in the version 0
+	r.StateUpgraders = recordStateUpgraders(r.Schema)
+	r.CustomizeDiff = validateRecords(r.Schema, ifUrlUpToLuaSnippet)
+	return r
 }
 
 func resourceIfUrlUpCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
@@ -100,18 +110,22 @@ func resourceIfUrlUpCreate(ctx context.Context, d *schema.ResourceData, m interf
 	recordId := fmt.Sprintf("%s.%s", name, zone)
 
 	// get records and transform to lua snippets
-	records := d.Get("record").([]interface{})
-	rrset := ifUrlUpToLuaSnippet(records)
+	records := d.Get("record").(*schema.Set).List()
+	rrset := ifUrlUpToLuaSnippet(splitDualStack(records))
+
+	// take over an existing rrset if requested by the provider or the resource
+	adopt := c.AdoptExisting || d.Get("adopt_existing").(bool)
 
 	// make dns update operation
-	_, err := c.doCreate(recordId, rrset)
+	server, err := c.doCreate(ctx, recordId, rrset, adopt)
 	if err != nil {
-		return diag.FromErr(err)
+		return operationDiagnostics(ctx, d, schema.TimeoutCreate, recordId, err)
 	}
 
 	d.SetId(recordId)
 
-	return resourceIfUrlUpRead(ctx, d, m)
+	diags := failoverDiagnostics(c, server, "create", recordId)
+	return append(diags, resourceIfUrlUpRead(withOperationPhase(ctx, schema.TimeoutCreate), d, m)...)
 }
 
 func resourceIfUrlUpRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
@@ -132,86 +146,37 @@ func resourceIfUrlUpRead(ctx context.Context, d *schema.ResourceData, m interfac
 	zone := strings.Join(labels[1:], ".") + "."
 	name := labels[0]
 
-	rr_lua, err := c.doTransfer(recordId)
+	rr_lua, server, err := c.doTransfer(ctx, recordId)
 	if err != nil {
-		return diag.FromErr(err)
+		return operationDiagnostics(ctx, d, schema.TimeoutRead, recordId, err)
 	}
+	diags = append(diags, failoverDiagnostics(c, server, "read", recordId)...)
 
 	var records []interface{}
+	var parseErrors []string
 	for _, rr := range rr_lua {
 		// decode lua
-		rrtype_int, _ := strconv.ParseInt(rr.Rdata[0:4], 16, 64)
-		rrtype := dns.TypeToString[uint16(rrtype_int)]
-		snippet, _ := hex.DecodeString(rr.Rdata[6:])
-
-		// search pickrandom function in snippet
-		re := regexp.MustCompile(`ifurlup\('(?P<url>.*)\',\s*{(?P<addrs>.*)},\s*{(?P<options>.*)}\)`)
-		matches_func := re.FindStringSubmatch(string(snippet))
-
-		// no match, ignore record
-		if len(matches_func) == 0 {
-			continue
-		}
-
-		// get addresses paramters
-		url := matches_func[re.SubexpIndex("url")]
-
-		// continue to decode addresses parameters
-		re2 := regexp.MustCompile(`{(?P<primary_addrs>.*)},\s*{(?P<backup_addrs>.*)}`)
-		addrs := matches_func[re.SubexpIndex("addrs")]
-		matches_addrs := re2.FindStringSubmatch(addrs)
-
-		re3 := regexp.MustCompile(`(?U)'(?P<ip>.*)'`)
-		primary_addrs := matches_addrs[re2.SubexpIndex("primary_addrs")]
-		matches_primary_addrs := re3.FindAllStringSubmatch(primary_addrs, -1)
-
-		backup_addrs := matches_addrs[re2.SubexpIndex("backup_addrs")]
-		matches_backup_addrs := re3.FindAllStringSubmatch(backup_addrs, -1)
-
-		var addrs_primary []string
-		for _, match := range matches_primary_addrs {
-			addrs_primary = append(addrs_primary, match[re3.SubexpIndex("ip")])
-		}
-
-		var addrs_backup []string
-		for _, match := range matches_backup_addrs {
-			addrs_backup = append(addrs_backup, match[re3.SubexpIndex("ip")])
-		}
-
-		var addresses []interface{}
-		map_addrs := make(map[string]interface{})
-		map_addrs["primary"] = addrs_primary
-		map_addrs["backup"] = addrs_backup
-		addresses = append(addresses, map_addrs)
+		rrtype, snippet := decodeLua(rr)
 
-		// continue to decode settings
-		re4 := regexp.MustCompile(`stringmatch='(?P<stringmatch>.*)', timeout=(?P<timeout>.*)`)
-		options := matches_func[re.SubexpIndex("options")]
-		matches_opts := re4.FindStringSubmatch(options)
-
-		// no match, ignore record
-		if len(matches_opts) == 0 {
+		urr, err := ifUrlUpFromLuaSnippet(snippet)
+		if err != nil {
+			// not a ifurlup record, ignore record
+			parseErrors = append(parseErrors, err.Error())
 			continue
 		}
-		stringmatch := matches_opts[re4.SubexpIndex("stringmatch")]
-		timeout_str := matches_opts[re4.SubexpIndex("timeout")]
-		timeout, _ := strconv.Atoi(timeout_str)
-
-		urr := make(map[string]interface{})
 		urr["rrtype"] = rrtype
-		urr["addresses"] = addresses
-		urr["stringmatch"] = stringmatch
-		urr["url"] = url
 		urr["ttl"] = rr.Hdr.Ttl
-		urr["timeout"] = timeout
 
 		records = append(records, urr)
 	}
 
 	if len(records) == 0 {
-		return diag.Errorf("No LUA records detected")
+		return noRecordsDiagnostics(recordId, parseErrors)
 	}
 
+	// merge the A and AAAA records of the dual stack records
+	records = mergeDualStack(records, d.Get("record").(*schema.Set).List())
+
 	d.Set("zone", zone)
 	d.Set("name", name)
 	if err := d.Set("record", records); err != nil {
@@ -230,19 +195,23 @@ func resourceIfUrlUpUpdate(ctx context.Context, d *schema.ResourceData, m interf
 
 	//return diag.Errorf("%s", d)
 
+	// warning or errors can be collected in a slice type
+	var diags diag.Diagnostics
+
 	if d.HasChange("record") {
 		// get records and transform to lua snippets
-		records := d.Get("record").([]interface{})
-		rrset := ifUrlUpToLuaSnippet(records)
+		records := d.Get("record").(*schema.Set).List()
+		rrset := ifUrlUpToLuaSnippet(splitDualStack(records))
 
 		// make dns update operation
-		_, err := c.doUpdate(recordId, rrset)
+		server, err := c.doUpdate(ctx, recordId, rrset)
 		if err != nil {
-			return diag.FromErr(err)
+			return operationDiagnostics(ctx, d, schema.TimeoutUpdate, recordId, err)
 		}
+		diags = append(diags, failoverDiagnostics(c, server, "update", recordId)...)
 	}
 
-	return resourceIfUrlUpRead(ctx, d, m)
+	return append(diags, resourceIfUrlUpRead(withOperationPhase(ctx, schema.TimeoutUpdate), d, m)...)
 }
 
 func resourceIfUrlUpDelete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
@@ -256,10 +225,11 @@ func resourceIfUrlUpDelete(ctx context.Context, d *schema.ResourceData, m interf
 	var diags diag.Diagnostics
 
 	// make dns delete operation
-	_, err := c.doDelete(recordId)
+	server, err := c.doDelete(ctx, recordId)
 	if err != nil {
-		return diag.FromErr(err)
+		return operationDiagnostics(ctx, d, schema.TimeoutDelete, recordId, err)
 	}
+	diags = append(diags, failoverDiagnostics(c, server, "delete", recordId)...)
 
 	// added here for explicitness, automatically called assuming delete returns no errors
 	d.SetId("")
@@ -293,9 +263,9 @@ func ifUrlUpToLuaSnippet(records []interface{}) []interface{} {
 
 		// https://doc.powerdns.com/authoritative/lua-records/functions.html#ifportup
 		snippet_lua := fmt.Sprintf("ifurlup(")
-		snippet_lua += fmt.Sprintf("'%s', ", url)
+		snippet_lua += luaQuote(url) + ", "
 		snippet_lua += "{{" + strings.Join(primary_list, ",") + "}, {" + strings.Join(backup_list, ",") + "} }"
-		snippet_lua += fmt.Sprintf(",{stringmatch='%s', timeout=%s}", stringmatch, strconv.Itoa(timeout))
+		snippet_lua += fmt.Sprintf(",{stringmatch=%s, timeout=%s}", luaQuote(stringmatch), strconv.Itoa(timeout))
 		snippet_lua += ")"
 
 		rr_new := map[string]interface{}{}
@@ -308,3 +278,131 @@ func ifUrlUpToLuaSnippet(records []interface{}) []interface{} {
 	}
 	return rrset
 }
+
+// ifUrlUpFromLuaSnippet parses an ifurlup snippet into the fields of a
+// record, without the rrtype and the ttl
+func ifUrlUpFromLuaSnippet(snippet string) (map[string]interface{}, error) {
+	// search ifurlup function in snippet
+	re := regexp.MustCompile(`ifurlup\('(?P<url>.*)\',\s*{(?P<addrs>.*)},\s*{(?P<options>.*)}\)`)
+	matches_func := re.FindStringSubmatch(snippet)
+	if len(matches_func) == 0 {
+		return nil, fmt.Errorf("Error snippet is not an ifurlup function: %q", snippet)
+	}
+
+	// get addresses paramters
+	url, err := luaUnquote(matches_func[re.SubexpIndex("url")])
+	if err != nil {
+		return nil, fmt.Errorf("Error invalid url in ifurlup snippet %q: %w", snippet, err)
+	}
+
+	// continue to decode addresses parameters
+	re2 := regexp.MustCompile(`{(?P<primary_addrs>.*)},\s*{(?P<backup_addrs>.*)}`)
+	addrs := matches_func[re.SubexpIndex("addrs")]
+	matches_addrs := re2.FindStringSubmatch(addrs)
+	if len(matches_addrs) == 0 {
+		return nil, fmt.Errorf("Error no primary and backup addresses in ifurlup snippet: %q", snippet)
+	}
+
+	re3 := regexp.MustCompile(`(?U)'(?P<ip>.*)'`)
+	addrs_primary := []interface{}{}
+	for _, match := range re3.FindAllStringSubmatch(matches_addrs[re2.SubexpIndex("primary_addrs")], -1) {
+		addrs_primary = append(addrs_primary, match[re3.SubexpIndex("ip")])
+	}
+
+	addrs_backup := []interface{}{}
+	for _, match := range re3.FindAllStringSubmatch(matches_addrs[re2.SubexpIndex("backup_addrs")], -1) {
+		addrs_backup = append(addrs_backup, match[re3.SubexpIndex("ip")])
+	}
+
+	var addresses []interface{}
+	map_addrs := make(map[string]interface{})
+	map_addrs["primary"] = addrs_primary
+	map_addrs["backup"] = addrs_backup
+	addresses = append(addresses, map_addrs)
+
+	// continue to decode settings
+	re4 := regexp.MustCompile(`stringmatch='(?P<stringmatch>.*)', timeout=(?P<timeout>\d+)`)
+	options := matches_func[re.SubexpIndex("options")]
+	matches_opts := re4.FindStringSubmatch(options)
+	if len(matches_opts) == 0 {
+		return nil, fmt.Errorf("Error no stringmatch and timeout options in ifurlup snippet: %q", snippet)
+	}
+	stringmatch, err := luaUnquote(matches_opts[re4.SubexpIndex("stringmatch")])
+	if err != nil {
+		return nil, fmt.Errorf("Error invalid stringmatch in ifurlup snippet %q: %w", snippet, err)
+	}
+	timeout, _ := strconv.Atoi(matches_opts[re4.SubexpIndex("timeout")])
+
+	urr := make(map[string]interface{})
+	urr["addresses"] = addresses
+	urr["stringmatch"] = stringmatch
+	urr["url"] = url
+	urr["timeout"] = timeout
+	return urr, nil
+}
+
+// luaQuote returns the string as a quoted LUA string of a snippet, the quotes
+// and the backslashes escaped, the control characters as \ddd
+func luaQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('\'')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\'' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < ' ' || c == 0x7f:
+			fmt.Fprintf(&b, "\\%03d", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+// luaUnquote returns the string of a quoted LUA string of a snippet, without
+// the quotes, the escapes decoded, an error for a \ddd escape above 255
+func luaUnquote(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		if isDigit(s[i]) {
+			n := 0
+			for j := 0; j < 3 && i < len(s) && isDigit(s[i]); j++ {
+				n = n*10 + int(s[i]-'0')
+				i++
+			}
+			if n > 255 {
+				return "", fmt.Errorf("Error invalid escape \\%d in %q", n, s)
+			}
+			b.WriteByte(byte(n))
+			i--
+			continue
+		}
+		switch s[i] {
+		case 'a':
+			b.WriteByte('\a')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'v':
+			b.WriteByte('\v')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
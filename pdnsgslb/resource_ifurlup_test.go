@@ -1,6 +1,7 @@
 package pdnsgslb
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
@@ -24,6 +25,38 @@ func TestAccPdnsgslbIfurlup_basic(t *testing.T) {
 	})
 }
 
+func TestPdnsgslbIfurlup_fake(t *testing.T) {
+	testResourceLifecycle(t, resourceIfUrlUp(), map[string]interface{}{
+		"zone": "test.internal.",
+		"name": "ifurlup",
+		"record": []interface{}{
+			map[string]interface{}{
+				"rrtype":    "A",
+				"ttl":       300,
+				"url":       "https://www.example.com/",
+				"addresses": []interface{}{map[string]interface{}{"primary": []interface{}{"10.0.0.210", "10.0.0.211"}}},
+				"timeout":   10,
+			},
+		},
+	}, map[string]interface{}{
+		"zone": "test.internal.",
+		"name": "ifurlup",
+		"record": []interface{}{
+			map[string]interface{}{
+				"rrtype":    "A",
+				"ttl":       300,
+				"url":       "https://www.example.com/health",
+				"addresses": []interface{}{map[string]interface{}{"primary": []interface{}{"10.0.0.210"}, "backup": []interface{}{"10.0.0.212"}}},
+				"timeout":   10,
+			},
+		},
+	}, []string{
+		"A ifurlup('https://www.example.com/', {{'10.0.0.210','10.0.0.211'}, {} },{stringmatch='', timeout=10})",
+	}, []string{
+		"A ifurlup('https://www.example.com/health', {{'10.0.0.210'}, {'10.0.0.212'} },{stringmatch='', timeout=10})",
+	})
+}
+
 func testAccCheckPdnsgslbIfurlupDestroy(s *terraform.State) error {
 	c := testAccProvider.Meta().(*Client)
 
@@ -34,7 +67,7 @@ func testAccCheckPdnsgslbIfurlupDestroy(s *terraform.State) error {
 
 		recordId := rs.Primary.ID
 
-		_, err := c.doDelete(recordId)
+		_, err := c.doDelete(context.Background(), recordId)
 		if err != nil {
 			return err
 		}
@@ -56,7 +89,7 @@ func testAccCheckPdnsgslbIfurlupExists(n string) resource.TestCheckFunc {
 		}
 
 		c := testAccProvider.Meta().(*Client)
-		rr_lua, err := c.doTransfer(rs.Primary.ID)
+		rr_lua, _, err := c.doTransfer(context.Background(), rs.Primary.ID)
 		if err != nil {
 			return err
 		}
@@ -2,25 +2,24 @@ package pdnsgslb
 
 import (
 	"context"
-	"encoding/hex"
 	"fmt"
-	"strconv"
 	"strings"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 	"github.com/miekg/dns"
 )
 
 func resourceLua() *schema.Resource {
-	return &schema.Resource{
+	r := &schema.Resource{
 		CreateContext: resourceLuaCreate,
 		ReadContext:   resourceLuaRead,
 		UpdateContext: resourceLuaUpdate,
 		DeleteContext: resourceLuaDelete,
-		Importer: &schema.ResourceImporter{
-			StateContext: schema.ImportStatePassthroughContext,
-		},
+		Timeouts:      resourceTimeouts(),
+		SchemaVersion: 1,
+		Importer:      importRecord("powerdns-gslb_lua", nil),
 		Schema: map[string]*schema.Schema{
 			"zone": {
 				Type:     schema.TypeString,
@@ -32,8 +31,13 @@ func resourceLua() *schema.Resource {
 				Required: true,
 				ForceNew: true,
 			},
+			"adopt_existing": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
 			"record": {
-				Type:     schema.TypeList,
+				Type:     schema.TypeSet,
 				Required: true,
 				Elem: &schema.Resource{
 					Schema: map[string]*schema.Schema{
@@ -42,8 +46,9 @@ func resourceLua() *schema.Resource {
 							Required: true,
 						},
 						"snippet": {
-							Type:     schema.TypeString,
-							Required: true,
+							Type:             schema.TypeString,
+							Required:         true,
+							ValidateDiagFunc: validation.ToDiagFunc(validation.StringLenBetween(0, 255)),
 						},
 						"ttl": {
 							Type:     schema.TypeInt,
@@ -55,6 +60,10 @@ func resourceLua() *schema.Resource {
 			},
 		},
 	}
+
+	// the records were a list in the version 0
+	r.StateUpgraders = recordStateUpgraders(r.Schema)
+	return r
 }
 
 func resourceLuaCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
@@ -69,17 +78,21 @@ func resourceLuaCreate(ctx context.Context, d *schema.ResourceData, m interface{
 	name := d.Get("name").(string)
 	recordId := fmt.Sprintf("%s.%s", name, zone)
 
-	rrset := d.Get("record").([]interface{})
+	rrset := d.Get("record").(*schema.Set).List()
+
+	// take over an existing rrset if requested by the provider or the resource
+	adopt := c.AdoptExisting || d.Get("adopt_existing").(bool)
 
 	// make dns update operation
-	_, err := c.doCreate(recordId, rrset)
+	server, err := c.doCreate(ctx, recordId, rrset, adopt)
 	if err != nil {
-		return diag.FromErr(err)
+		return operationDiagnostics(ctx, d, schema.TimeoutCreate, recordId, err)
 	}
 
 	d.SetId(recordId)
 
-	return resourceLuaRead(ctx, d, m)
+	diags := failoverDiagnostics(c, server, "create", recordId)
+	return append(diags, resourceLuaRead(withOperationPhase(ctx, schema.TimeoutCreate), d, m)...)
 }
 
 func resourceLuaRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
@@ -100,21 +113,20 @@ func resourceLuaRead(ctx context.Context, d *schema.ResourceData, m interface{})
 	zone := strings.Join(labels[1:], ".") + "."
 	name := labels[0]
 
-	rr_lua, err := c.doTransfer(recordId)
+	rr_lua, server, err := c.doTransfer(ctx, recordId)
 	if err != nil {
-		return diag.FromErr(err)
+		return operationDiagnostics(ctx, d, schema.TimeoutRead, recordId, err)
 	}
+	diags = append(diags, failoverDiagnostics(c, server, "read", recordId)...)
 
 	var records []interface{}
 	for _, rr := range rr_lua {
 		// decode lua
-		rrtype_int, _ := strconv.ParseInt(rr.Rdata[0:4], 16, 64)
-		rrtype := dns.TypeToString[uint16(rrtype_int)]
-		snippet, _ := hex.DecodeString(rr.Rdata[6:])
+		rrtype, snippet := decodeLua(rr)
 
 		urr := make(map[string]interface{})
 		urr["rrtype"] = rrtype
-		urr["snippet"] = string(snippet)
+		urr["snippet"] = snippet
 		urr["ttl"] = rr.Hdr.Ttl
 
 		records = append(records, urr)
@@ -136,16 +148,20 @@ func resourceLuaUpdate(ctx context.Context, d *schema.ResourceData, m interface{
 	// get ressource id
 	recordId := d.Id()
 
+	// warning or errors can be collected in a slice type
+	var diags diag.Diagnostics
+
 	if d.HasChange("record") {
-		records := d.Get("record").([]interface{})
+		records := d.Get("record").(*schema.Set).List()
 		// make dns update operation
-		_, err := c.doUpdate(recordId, records)
+		server, err := c.doUpdate(ctx, recordId, records)
 		if err != nil {
-			return diag.FromErr(err)
+			return operationDiagnostics(ctx, d, schema.TimeoutUpdate, recordId, err)
 		}
+		diags = append(diags, failoverDiagnostics(c, server, "update", recordId)...)
 	}
 
-	return resourceLuaRead(ctx, d, m)
+	return append(diags, resourceLuaRead(withOperationPhase(ctx, schema.TimeoutUpdate), d, m)...)
 }
 
 func resourceLuaDelete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
@@ -159,10 +175,11 @@ func resourceLuaDelete(ctx context.Context, d *schema.ResourceData, m interface{
 	var diags diag.Diagnostics
 
 	// make dns delete operation
-	_, err := c.doDelete(recordId)
+	server, err := c.doDelete(ctx, recordId)
 	if err != nil {
-		return diag.FromErr(err)
+		return operationDiagnostics(ctx, d, schema.TimeoutDelete, recordId, err)
 	}
+	diags = append(diags, failoverDiagnostics(c, server, "delete", recordId)...)
 
 	// added here for explicitness, automatically called assuming delete returns no errors
 	d.SetId("")
@@ -1,11 +1,19 @@
 package pdnsgslb
 
 import (
+	"context"
 	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/dmachard/terraform-provider-powerdns-gslb/internal/fakepdns"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"github.com/miekg/dns"
 )
 
 func TestAccPdnsgslbLua_basic(t *testing.T) {
@@ -24,6 +32,111 @@ func TestAccPdnsgslbLua_basic(t *testing.T) {
 	})
 }
 
+func TestAccPdnsgslbLua_adopt(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckPdnsgslbLuaDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config:      testAccCheckPdnsgslbLuaConfig_exists,
+				ExpectError: regexp.MustCompile("already exists"),
+			},
+			{
+				Config: testAccCheckPdnsgslbLuaConfig_adopt,
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckPdnsgslbLuaExists("powerdns-gslb_lua.testadopt"),
+					resource.TestCheckTypeSetElemNestedAttrs("powerdns-gslb_lua.testadopt", "record.*", map[string]string{"snippet": "'adopted'"}),
+				),
+			},
+		},
+	})
+}
+
+func TestPdnsgslbLua_fake(t *testing.T) {
+	testResourceLifecycle(t, resourceLua(), map[string]interface{}{
+		"zone": "test.internal.",
+		"name": "testlua",
+		"record": []interface{}{
+			map[string]interface{}{"rrtype": "TXT", "ttl": 30, "snippet": "os.date()"},
+		},
+	}, map[string]interface{}{
+		"zone": "test.internal.",
+		"name": "testlua",
+		"record": []interface{}{
+			map[string]interface{}{"rrtype": "TXT", "ttl": 30, "snippet": "os.time()"},
+			map[string]interface{}{"rrtype": "A", "ttl": 30, "snippet": "'192.168.1.1'"},
+		},
+	}, []string{
+		"TXT os.date()",
+	}, []string{
+		"A '192.168.1.1'",
+		"TXT os.time()",
+	})
+}
+
+func TestPdnsgslbLua_fakeFaults(t *testing.T) {
+	srv, c := testFakeServer(t)
+	ctx := context.Background()
+	r := resourceLua()
+	raw := map[string]interface{}{
+		"zone": "test.internal.",
+		"name": "testlua",
+		"record": []interface{}{
+			map[string]interface{}{"rrtype": "TXT", "ttl": 30, "snippet": "os.date()"},
+		},
+	}
+
+	// the record created outside of terraform is only taken over on request
+	if err := srv.AddRecord("testlua.test.internal. 30 IN TYPE65402 \\# 12 0010 09 6f732e74696d652829"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	d := schema.TestResourceDataRaw(t, r.Schema, raw)
+	if diags := r.CreateContext(ctx, d, c); !diags.HasError() || !strings.Contains(diags[0].Summary, "already exists") {
+		t.Errorf("expected an already exists error, got %v", diags)
+	}
+	raw["adopt_existing"] = true
+	d = schema.TestResourceDataRaw(t, r.Schema, raw)
+	if diags := r.CreateContext(ctx, d, c); diags.HasError() {
+		t.Fatalf("adopt: %v", diags)
+	}
+	if got := testFakeSnippets(t, srv, d.Id()); !reflect.DeepEqual(got, []string{"TXT os.date()"}) {
+		t.Errorf("adopt: unexpected records %q", got)
+	}
+
+	// SERVFAIL replies are retried
+	srv.InjectFault(fakepdns.Fault{Operation: fakepdns.OpUpdate, Rcode: dns.RcodeServerFailure, Count: 2})
+	before := srv.Requests(fakepdns.OpUpdate)
+	if _, err := c.doDelete(ctx, d.Id()); err != nil {
+		t.Errorf("delete after SERVFAIL: %s", err)
+	}
+	if requests := srv.Requests(fakepdns.OpUpdate) - before; requests != 3 {
+		t.Errorf("expected 3 update requests, got %d", requests)
+	}
+
+	// the read failing after a create is reported in the create phase
+	srv.InjectFault(fakepdns.Fault{Operation: fakepdns.OpAXFR, Rcode: dns.RcodeRefused, Count: 1})
+	raw["name"] = "testluaread"
+	if diags := r.CreateContext(ctx, schema.TestResourceDataRaw(t, r.Schema, raw), c); !diags.HasError() || !strings.Contains(diags[0].Summary, "during the create of testluaread.test.internal.") {
+		t.Errorf("expected an error of the create phase, got %v", diags)
+	}
+
+	// the truncated zone transfer is an error
+	srv.InjectFault(fakepdns.Fault{Operation: fakepdns.OpAXFR, Truncate: true})
+	if _, _, err := c.doTransfer(ctx, d.Id()); err == nil {
+		t.Errorf("expected an error on a truncated transfer")
+	}
+	srv.ClearFaults()
+
+	// the timeout is reported with the timeouts hint
+	c.Retries = 0
+	c.DNSClient.ReadTimeout = 50 * time.Millisecond
+	srv.InjectFault(fakepdns.Fault{Operation: fakepdns.OpAXFR, Delay: time.Second})
+	if diags := r.ReadContext(ctx, d, c); !diags.HasError() || !strings.Contains(diags[0].Summary, "timeout") {
+		t.Errorf("expected a timeout error, got %v", diags)
+	}
+}
+
 func testAccCheckPdnsgslbLuaDestroy(s *terraform.State) error {
 	c := testAccProvider.Meta().(*Client)
 
@@ -34,7 +147,7 @@ func testAccCheckPdnsgslbLuaDestroy(s *terraform.State) error {
 
 		recordId := rs.Primary.ID
 
-		_, err := c.doDelete(recordId)
+		_, err := c.doDelete(context.Background(), recordId)
 		if err != nil {
 			return err
 		}
@@ -56,7 +169,7 @@ func testAccCheckPdnsgslbLuaExists(n string) resource.TestCheckFunc {
 		}
 
 		c := testAccProvider.Meta().(*Client)
-		rr_lua, err := c.doTransfer(rs.Primary.ID)
+		rr_lua, _, err := c.doTransfer(context.Background(), rs.Primary.ID)
 		if err != nil {
 			return err
 		}
@@ -79,3 +192,51 @@ resource "powerdns-gslb_lua" "testlua" {
 	  snippet = "os.date()"
 	}
 }`
+
+const testAccCheckPdnsgslbLuaConfig_exists = `
+resource "powerdns-gslb_lua" "testexisting" {
+	zone = "test.internal."
+	name = "testadopt"
+	record {
+	  rrtype = "TXT"
+	  ttl = 30
+	  snippet = "'existing'"
+	}
+}
+
+resource "powerdns-gslb_lua" "testadopt" {
+	zone = "test.internal."
+	name = "testadopt"
+	record {
+	  rrtype = "TXT"
+	  ttl = 30
+	  snippet = "'adopted'"
+	}
+	depends_on = [powerdns-gslb_lua.testexisting]
+}`
+
+const testAccCheckPdnsgslbLuaConfig_adopt = `
+resource "powerdns-gslb_lua" "testexisting" {
+	zone = "test.internal."
+	name = "testadopt"
+	record {
+	  rrtype = "TXT"
+	  ttl = 30
+	  snippet = "'existing'"
+	}
+	lifecycle {
+	  ignore_changes = [record]
+	}
+}
+
+resource "powerdns-gslb_lua" "testadopt" {
+	zone = "test.internal."
+	name = "testadopt"
+	adopt_existing = true
+	record {
+	  rrtype = "TXT"
+	  ttl = 30
+	  snippet = "'adopted'"
+	}
+	depends_on = [powerdns-gslb_lua.testexisting]
+}`
@@ -2,26 +2,25 @@ package pdnsgslb
 
 import (
 	"context"
-	"encoding/hex"
 	"fmt"
 	"regexp"
-	"strconv"
 	"strings"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 	"github.com/miekg/dns"
 )
 
 func resourcePickRandom() *schema.Resource {
-	return &schema.Resource{
+	r := &schema.Resource{
 		CreateContext: resourcePickRandomCreate,
 		ReadContext:   resourcePickRandomRead,
 		UpdateContext: resourcePickRandomUpdate,
 		DeleteContext: resourcePickRandomDelete,
-		Importer: &schema.ResourceImporter{
-			StateContext: schema.ImportStatePassthroughContext,
-		},
+		Timeouts:      resourceTimeouts(),
+		SchemaVersion: 1,
+		Importer:      importRecord("powerdns-gslb_pickrandom", pickRandomFromLuaSnippet),
 		Schema: map[string]*schema.Schema{
 			"zone": {
 				Type:     schema.TypeString,
@@ -33,8 +32,13 @@ func resourcePickRandom() *schema.Resource {
 				Required: true,
 				ForceNew: true,
 			},
+			"adopt_existing": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
 			"record": {
-				Type:     schema.TypeList,
+				Type:     schema.TypeSet,
 				Required: true,
 				Elem: &schema.Resource{
 					Schema: map[string]*schema.Schema{
@@ -45,7 +49,7 @@ func resourcePickRandom() *schema.Resource {
 						"addresses": {
 							Type:     schema.TypeList,
 							Required: true,
-							Elem:     &schema.Schema{Type: schema.TypeString},
+							Elem:     &schema.Schema{Type: schema.TypeString, ValidateDiagFunc: validation.ToDiagFunc(validation.IsIPAddress)},
 						},
 						"ttl": {
 							Type:     schema.TypeInt,
@@ -57,6 +61,11 @@ func resourcePickRandom() *schema.Resource {
 			},
 		},
 	}
+
+	// the records were a list in the version 0
+	r.StateUpgraders = recordStateUpgraders(r.Schema)
+	r.CustomizeDiff = validateRecords(r.Schema, pickRandomToLuaSnippet)
+	return r
 }
 
 func resourcePickRandomCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
@@ -72,18 +81,22 @@ func resourcePickRandomCreate(ctx context.Context, d *schema.ResourceData, m int
 	recordId := fmt.Sprintf("%s.%s", name, zone)
 
 	// get records and transform to lua snippets
-	records := d.Get("record").([]interface{})
-	rrset := pickRandomToLuaSnippet(records)
+	records := d.Get("record").(*schema.Set).List()
+	rrset := pickRandomToLuaSnippet(splitDualStack(records))
+
+	// take over an existing rrset if requested by the provider or the resource
+	adopt := c.AdoptExisting || d.Get("adopt_existing").(bool)
 
 	// make dns update operation
-	_, err := c.doCreate(recordId, rrset)
+	server, err := c.doCreate(ctx, recordId, rrset, adopt)
 	if err != nil {
-		return diag.FromErr(err)
+		return operationDiagnostics(ctx, d, schema.TimeoutCreate, recordId, err)
 	}
 
 	d.SetId(recordId)
 
-	return resourcePickRandomRead(ctx, d, m)
+	diags := failoverDiagnostics(c, server, "create", recordId)
+	return append(diags, resourcePickRandomRead(withOperationPhase(ctx, schema.TimeoutCreate), d, m)...)
 }
 
 func resourcePickRandomRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
@@ -104,52 +117,37 @@ func resourcePickRandomRead(ctx context.Context, d *schema.ResourceData, m inter
 	zone := strings.Join(labels[1:], ".") + "."
 	name := labels[0]
 
-	rr_lua, err := c.doTransfer(recordId)
+	rr_lua, server, err := c.doTransfer(ctx, recordId)
 	if err != nil {
-		return diag.FromErr(err)
+		return operationDiagnostics(ctx, d, schema.TimeoutRead, recordId, err)
 	}
+	diags = append(diags, failoverDiagnostics(c, server, "read", recordId)...)
 
 	var records []interface{}
+	var parseErrors []string
 	for _, rr := range rr_lua {
 		// decode lua
-		rrtype_int, _ := strconv.ParseInt(rr.Rdata[0:4], 16, 64)
-		rrtype := dns.TypeToString[uint16(rrtype_int)]
-		snippet, _ := hex.DecodeString(rr.Rdata[6:])
-
-		// search pickrandom function in snippet
-		re := regexp.MustCompile(`pickrandom\({(?P<param1>.*)}\)$`)
-		matches_func := re.FindStringSubmatch(string(snippet))
+		rrtype, snippet := decodeLua(rr)
 
-		// no match, ignore record
-		if len(matches_func) == 0 {
+		urr, err := pickRandomFromLuaSnippet(snippet)
+		if err != nil {
+			// not a pickrandom record, ignore record
+			parseErrors = append(parseErrors, err.Error())
 			continue
 		}
-
-		// get addresses paramters
-		param1 := matches_func[re.SubexpIndex("param1")]
-
-		// ok, continue to decode addresses parameters
-		re2 := regexp.MustCompile(`(?U)'(?P<ip>.*)'`)
-		matches_opt := re2.FindAllStringSubmatch(param1, -1)
-
-		var addresses []string
-		for _, match := range matches_opt {
-			ip := match[re2.SubexpIndex("ip")]
-			addresses = append(addresses, ip)
-		}
-
-		urr := make(map[string]interface{})
 		urr["rrtype"] = rrtype
-		urr["addresses"] = addresses
 		urr["ttl"] = rr.Hdr.Ttl
 
 		records = append(records, urr)
 	}
 
 	if len(records) == 0 {
-		return diag.Errorf("No LUA records detected")
+		return noRecordsDiagnostics(recordId, parseErrors)
 	}
 
+	// merge the A and AAAA records of the dual stack records
+	records = mergeDualStack(records, d.Get("record").(*schema.Set).List())
+
 	d.Set("zone", zone)
 	d.Set("name", name)
 	if err := d.Set("record", records); err != nil {
@@ -166,19 +164,23 @@ func resourcePickRandomUpdate(ctx context.Context, d *schema.ResourceData, m int
 	// get ressource id
 	recordId := d.Id()
 
+	// warning or errors can be collected in a slice type
+	var diags diag.Diagnostics
+
 	if d.HasChange("record") {
 		// get records and transform to lua snippets
-		records := d.Get("record").([]interface{})
-		rrset := pickRandomToLuaSnippet(records)
+		records := d.Get("record").(*schema.Set).List()
+		rrset := pickRandomToLuaSnippet(splitDualStack(records))
 
 		// make dns update operation
-		_, err := c.doUpdate(recordId, rrset)
+		server, err := c.doUpdate(ctx, recordId, rrset)
 		if err != nil {
-			return diag.FromErr(err)
+			return operationDiagnostics(ctx, d, schema.TimeoutUpdate, recordId, err)
 		}
+		diags = append(diags, failoverDiagnostics(c, server, "update", recordId)...)
 	}
 
-	return resourcePickRandomRead(ctx, d, m)
+	return append(diags, resourcePickRandomRead(withOperationPhase(ctx, schema.TimeoutUpdate), d, m)...)
 }
 
 func resourcePickRandomDelete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
@@ -192,10 +194,11 @@ func resourcePickRandomDelete(ctx context.Context, d *schema.ResourceData, m int
 	var diags diag.Diagnostics
 
 	// make dns delete operation
-	_, err := c.doDelete(recordId)
+	server, err := c.doDelete(ctx, recordId)
 	if err != nil {
-		return diag.FromErr(err)
+		return operationDiagnostics(ctx, d, schema.TimeoutDelete, recordId, err)
 	}
+	diags = append(diags, failoverDiagnostics(c, server, "delete", recordId)...)
 
 	// added here for explicitness, automatically called assuming delete returns no errors
 	d.SetId("")
@@ -229,3 +232,30 @@ func pickRandomToLuaSnippet(records []interface{}) []interface{} {
 	}
 	return rrset
 }
+
+// pickRandomFromLuaSnippet parses a pickrandom snippet into the fields of a
+// record, without the rrtype and the ttl
+func pickRandomFromLuaSnippet(snippet string) (map[string]interface{}, error) {
+	// search pickrandom function in snippet
+	re := regexp.MustCompile(`pickrandom\({(?P<param1>.*)}\)$`)
+	matches_func := re.FindStringSubmatch(snippet)
+	if len(matches_func) == 0 {
+		return nil, fmt.Errorf("Error snippet is not a pickrandom function: %q", snippet)
+	}
+
+	// get addresses paramters
+	param1 := matches_func[re.SubexpIndex("param1")]
+
+	// ok, continue to decode addresses parameters
+	re2 := regexp.MustCompile(`(?U)'(?P<ip>.*)'`)
+	matches_opt := re2.FindAllStringSubmatch(param1, -1)
+
+	addresses := []interface{}{}
+	for _, match := range matches_opt {
+		addresses = append(addresses, match[re2.SubexpIndex("ip")])
+	}
+
+	urr := make(map[string]interface{})
+	urr["addresses"] = addresses
+	return urr, nil
+}
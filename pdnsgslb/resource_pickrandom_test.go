@@ -1,6 +1,7 @@
 package pdnsgslb
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
@@ -24,6 +25,26 @@ func TestAccPdnsgslbPickrandom_basic(t *testing.T) {
 	})
 }
 
+func TestPdnsgslbPickrandom_fake(t *testing.T) {
+	testResourceLifecycle(t, resourcePickRandom(), map[string]interface{}{
+		"zone": "test.internal.",
+		"name": "testpickrandom",
+		"record": []interface{}{
+			map[string]interface{}{"rrtype": "A", "ttl": 5, "addresses": []interface{}{"127.0.0.1", "127.0.0.7"}},
+		},
+	}, map[string]interface{}{
+		"zone": "test.internal.",
+		"name": "testpickrandom",
+		"record": []interface{}{
+			map[string]interface{}{"rrtype": "AAAA", "ttl": 5, "addresses": []interface{}{"::1", "::7"}},
+		},
+	}, []string{
+		"A pickrandom({'127.0.0.1','127.0.0.7'})",
+	}, []string{
+		"AAAA pickrandom({'::1','::7'})",
+	})
+}
+
 func testAccCheckPdnsgslbPickrandomDestroy(s *terraform.State) error {
 	c := testAccProvider.Meta().(*Client)
 
@@ -34,7 +55,7 @@ func testAccCheckPdnsgslbPickrandomDestroy(s *terraform.State) error {
 
 		recordId := rs.Primary.ID
 
-		_, err := c.doDelete(recordId)
+		_, err := c.doDelete(context.Background(), recordId)
 		if err != nil {
 			return err
 		}
@@ -56,7 +77,7 @@ func testAccCheckPdnsgslbPickrandomExists(n string) resource.TestCheckFunc {
 		}
 
 		c := testAccProvider.Meta().(*Client)
-		rr_lua, err := c.doTransfer(rs.Primary.ID)
+		rr_lua, _, err := c.doTransfer(context.Background(), rs.Primary.ID)
 		if err != nil {
 			return err
 		}
@@ -2,7 +2,6 @@ package pdnsgslb
 
 import (
 	"context"
-	"encoding/hex"
 	"fmt"
 	"regexp"
 	"strconv"
@@ -10,18 +9,19 @@ import (
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 	"github.com/miekg/dns"
 )
 
 func resourcePickWrandom() *schema.Resource {
-	return &schema.Resource{
+	r := &schema.Resource{
 		CreateContext: resourcePickWrandomCreate,
 		ReadContext:   resourcePickWrandomRead,
 		UpdateContext: resourcePickWrandomUpdate,
 		DeleteContext: resourcePickWrandomDelete,
-		Importer: &schema.ResourceImporter{
-			StateContext: schema.ImportStatePassthroughContext,
-		},
+		Timeouts:      resourceTimeouts(),
+		SchemaVersion: 1,
+		Importer:      importRecord("powerdns-gslb_pickwrandom", pickWrandomFromLuaSnippet),
 		Schema: map[string]*schema.Schema{
 			"zone": {
 				Type:     schema.TypeString,
@@ -33,8 +33,13 @@ func resourcePickWrandom() *schema.Resource {
 				Required: true,
 				ForceNew: true,
 			},
+			"adopt_existing": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
 			"record": {
-				Type:     schema.TypeList,
+				Type:     schema.TypeSet,
 				Required: true,
 				Elem: &schema.Resource{
 					Schema: map[string]*schema.Schema{
@@ -53,12 +58,14 @@ func resourcePickWrandom() *schema.Resource {
 							Elem: &schema.Resource{
 								Schema: map[string]*schema.Schema{
 									"weight": {
-										Type:     schema.TypeInt,
-										Required: true,
+										Type:             schema.TypeInt,
+										Required:         true,
+										ValidateDiagFunc: validation.ToDiagFunc(validation.IntAtLeast(1)),
 									},
 									"ip": {
-										Type:     schema.TypeString,
-										Required: true,
+										Type:             schema.TypeString,
+										Required:         true,
+										ValidateDiagFunc: validation.ToDiagFunc(validation.IsIPAddress),
 									},
 								},
 							},
@@ -68,6 +75,11 @@ func resourcePickWrandom() *schema.Resource {
 			},
 		},
 	}
+
+	// the records were a list in the version 0
+	r.StateUpgraders = recordStateUpgraders(r.Schema)
+	r.CustomizeDiff = validateRecords(r.Schema, PickWrandomToLuaSnippet)
+	return r
 }
 
 func resourcePickWrandomCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
@@ -83,18 +95,22 @@ func resourcePickWrandomCreate(ctx context.Context, d *schema.ResourceData, m in
 	recordId := fmt.Sprintf("%s.%s", name, zone)
 
 	// get records and transform to lua snippets
-	records := d.Get("record").([]interface{})
-	rrset := PickWrandomToLuaSnippet(records)
+	records := d.Get("record").(*schema.Set).List()
+	rrset := PickWrandomToLuaSnippet(splitDualStack(records))
+
+	// take over an existing rrset if requested by the provider or the resource
+	adopt := c.AdoptExisting || d.Get("adopt_existing").(bool)
 
 	// make dns update operation
-	_, err := c.doCreate(recordId, rrset)
+	server, err := c.doCreate(ctx, recordId, rrset, adopt)
 	if err != nil {
-		return diag.FromErr(err)
+		return operationDiagnostics(ctx, d, schema.TimeoutCreate, recordId, err)
 	}
 
 	d.SetId(recordId)
 
-	return resourcePickWrandomRead(ctx, d, m)
+	diags := failoverDiagnostics(c, server, "create", recordId)
+	return append(diags, resourcePickWrandomRead(withOperationPhase(ctx, schema.TimeoutCreate), d, m)...)
 }
 
 func resourcePickWrandomRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
@@ -115,59 +131,37 @@ func resourcePickWrandomRead(ctx context.Context, d *schema.ResourceData, m inte
 	zone := strings.Join(labels[1:], ".") + "."
 	name := labels[0]
 
-	rr_lua, err := c.doTransfer(recordId)
+	rr_lua, server, err := c.doTransfer(ctx, recordId)
 	if err != nil {
-		return diag.FromErr(err)
+		return operationDiagnostics(ctx, d, schema.TimeoutRead, recordId, err)
 	}
+	diags = append(diags, failoverDiagnostics(c, server, "read", recordId)...)
 
 	var records []interface{}
+	var parseErrors []string
 	for _, rr := range rr_lua {
 		// decode lua
-		rrtype_int, _ := strconv.ParseInt(rr.Rdata[0:4], 16, 64)
-		rrtype := dns.TypeToString[uint16(rrtype_int)]
-		snippet, _ := hex.DecodeString(rr.Rdata[6:])
+		rrtype, snippet := decodeLua(rr)
 
-		// search PickWrandom function in snippet
-		re := regexp.MustCompile(`pickwrandom\({(?P<weightparams>.*)}\)$`)
-		matches_func := re.FindStringSubmatch(string(snippet))
-
-		// no match, ignore record
-		if len(matches_func) == 0 {
+		urr, err := pickWrandomFromLuaSnippet(snippet)
+		if err != nil {
+			// not a pickwrandom record, ignore record
+			parseErrors = append(parseErrors, err.Error())
 			continue
 		}
-
-		// get weightparams
-		weightparams := matches_func[re.SubexpIndex("weightparams")]
-
-		// ok, continue to decode list of addresses with weight and ip
-		re2 := regexp.MustCompile(`(?U){(?P<weight>\d+),\s*'(?P<ip>.*)'}`)
-		matches_opt := re2.FindAllStringSubmatch(weightparams, -1)
-
-		var addresses []interface{}
-		for _, match := range matches_opt {
-			wp := make(map[string]interface{})
-
-			ip := match[re2.SubexpIndex("ip")]
-			weight_str := match[re2.SubexpIndex("weight")]
-			weight, _ := strconv.Atoi(weight_str)
-			wp["ip"] = ip
-			wp["weight"] = weight
-
-			addresses = append(addresses, wp)
-		}
-
-		urr := make(map[string]interface{})
 		urr["rrtype"] = rrtype
-		urr["ipaddress"] = addresses
 		urr["ttl"] = rr.Hdr.Ttl
 
 		records = append(records, urr)
 	}
 
 	if len(records) == 0 {
-		return diag.Errorf("No LUA records detected")
+		return noRecordsDiagnostics(recordId, parseErrors)
 	}
 
+	// merge the A and AAAA records of the dual stack records
+	records = mergeDualStack(records, d.Get("record").(*schema.Set).List())
+
 	d.Set("zone", zone)
 	d.Set("name", name)
 	if err := d.Set("record", records); err != nil {
@@ -184,19 +178,23 @@ func resourcePickWrandomUpdate(ctx context.Context, d *schema.ResourceData, m in
 	// get ressource id
 	recordId := d.Id()
 
+	// warning or errors can be collected in a slice type
+	var diags diag.Diagnostics
+
 	if d.HasChange("record") {
 		// get records and transform to lua snippets
-		records := d.Get("record").([]interface{})
-		rrset := PickWrandomToLuaSnippet(records)
+		records := d.Get("record").(*schema.Set).List()
+		rrset := PickWrandomToLuaSnippet(splitDualStack(records))
 
 		// make dns update operation
-		_, err := c.doUpdate(recordId, rrset)
+		server, err := c.doUpdate(ctx, recordId, rrset)
 		if err != nil {
-			return diag.FromErr(err)
+			return operationDiagnostics(ctx, d, schema.TimeoutUpdate, recordId, err)
 		}
+		diags = append(diags, failoverDiagnostics(c, server, "update", recordId)...)
 	}
 
-	return resourcePickWrandomRead(ctx, d, m)
+	return append(diags, resourcePickWrandomRead(withOperationPhase(ctx, schema.TimeoutUpdate), d, m)...)
 }
 
 func resourcePickWrandomDelete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
@@ -210,10 +208,11 @@ func resourcePickWrandomDelete(ctx context.Context, d *schema.ResourceData, m in
 	var diags diag.Diagnostics
 
 	// make dns delete operation
-	_, err := c.doDelete(recordId)
+	server, err := c.doDelete(ctx, recordId)
 	if err != nil {
-		return diag.FromErr(err)
+		return operationDiagnostics(ctx, d, schema.TimeoutDelete, recordId, err)
 	}
+	diags = append(diags, failoverDiagnostics(c, server, "delete", recordId)...)
 
 	// added here for explicitness, automatically called assuming delete returns no errors
 	d.SetId("")
@@ -252,3 +251,38 @@ func PickWrandomToLuaSnippet(records []interface{}) []interface{} {
 	}
 	return rrset
 }
+
+// pickWrandomFromLuaSnippet parses a pickwrandom snippet into the fields of a
+// record, without the rrtype and the ttl
+func pickWrandomFromLuaSnippet(snippet string) (map[string]interface{}, error) {
+	// search PickWrandom function in snippet
+	re := regexp.MustCompile(`pickwrandom\({(?P<weightparams>.*)}\)$`)
+	matches_func := re.FindStringSubmatch(snippet)
+	if len(matches_func) == 0 {
+		return nil, fmt.Errorf("Error snippet is not a pickwrandom function: %q", snippet)
+	}
+
+	// get weightparams
+	weightparams := matches_func[re.SubexpIndex("weightparams")]
+
+	// ok, continue to decode list of addresses with weight and ip
+	re2 := regexp.MustCompile(`(?U){(?P<weight>\d+),\s*'(?P<ip>.*)'}`)
+	matches_opt := re2.FindAllStringSubmatch(weightparams, -1)
+
+	addresses := []interface{}{}
+	for _, match := range matches_opt {
+		wp := make(map[string]interface{})
+
+		ip := match[re2.SubexpIndex("ip")]
+		weight_str := match[re2.SubexpIndex("weight")]
+		weight, _ := strconv.Atoi(weight_str)
+		wp["ip"] = ip
+		wp["weight"] = weight
+
+		addresses = append(addresses, wp)
+	}
+
+	urr := make(map[string]interface{})
+	urr["ipaddress"] = addresses
+	return urr, nil
+}
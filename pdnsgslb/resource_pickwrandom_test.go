@@ -1,6 +1,7 @@
 package pdnsgslb
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
@@ -24,6 +25,31 @@ func TestAccPdnsgslbPickwrandom_basic(t *testing.T) {
 	})
 }
 
+func TestPdnsgslbPickwrandom_fake(t *testing.T) {
+	testResourceLifecycle(t, resourcePickWrandom(), map[string]interface{}{
+		"zone": "test.internal.",
+		"name": "testpickwrandom",
+		"record": []interface{}{
+			map[string]interface{}{"rrtype": "A", "ttl": 5, "ipaddress": []interface{}{
+				map[string]interface{}{"weight": 10, "ip": "192.168.1.1"},
+				map[string]interface{}{"weight": 100, "ip": "192.168.1.2"},
+			}},
+		},
+	}, map[string]interface{}{
+		"zone": "test.internal.",
+		"name": "testpickwrandom",
+		"record": []interface{}{
+			map[string]interface{}{"rrtype": "A", "ttl": 5, "ipaddress": []interface{}{
+				map[string]interface{}{"weight": 50, "ip": "192.168.1.1"},
+			}},
+		},
+	}, []string{
+		"A pickwrandom({{10, '192.168.1.1'},{100, '192.168.1.2'}})",
+	}, []string{
+		"A pickwrandom({{50, '192.168.1.1'}})",
+	})
+}
+
 func testAccCheckPdnsgslbPickwrandomDestroy(s *terraform.State) error {
 	c := testAccProvider.Meta().(*Client)
 
@@ -34,7 +60,7 @@ func testAccCheckPdnsgslbPickwrandomDestroy(s *terraform.State) error {
 
 		recordId := rs.Primary.ID
 
-		_, err := c.doDelete(recordId)
+		_, err := c.doDelete(context.Background(), recordId)
 		if err != nil {
 			return err
 		}
@@ -56,7 +82,7 @@ func testAccCheckPdnsgslbPickwrandomExists(n string) resource.TestCheckFunc {
 		}
 
 		c := testAccProvider.Meta().(*Client)
-		rr_lua, err := c.doTransfer(rs.Primary.ID)
+		rr_lua, _, err := c.doTransfer(context.Background(), rs.Primary.ID)
 		if err != nil {
 			return err
 		}
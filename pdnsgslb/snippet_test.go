@@ -0,0 +1,149 @@
+package pdnsgslb
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files of the snippets")
+
+// testSnippetCases are the records of the typed resources with the options
+// combinations, the rendered snippets are in testdata/snippets/<name>.golden
+var testSnippetCases = []struct {
+	name   string
+	render func([]interface{}) []interface{}
+	parse  func(string) (map[string]interface{}, error)
+	record map[string]interface{}
+}{
+	{"ifportup_one_address", ifPortUpToLuaSnippet, ifPortUpFromLuaSnippet, map[string]interface{}{
+		"port": 443, "addresses": []interface{}{"192.168.1.1"}, "timeout": 2,
+	}},
+	{"ifportup_addresses", ifPortUpToLuaSnippet, ifPortUpFromLuaSnippet, map[string]interface{}{
+		"port": 443, "addresses": []interface{}{"192.168.1.1", "192.168.1.2", "192.168.1.3"}, "timeout": 10,
+	}},
+	{"ifportup_ipv6", ifPortUpToLuaSnippet, ifPortUpFromLuaSnippet, map[string]interface{}{
+		"port": 65535, "addresses": []interface{}{"2001:db8::1", "2001:db8::2"}, "timeout": 1,
+	}},
+	{"ifurlup_primary", ifUrlUpToLuaSnippet, ifUrlUpFromLuaSnippet, map[string]interface{}{
+		"url": "https://www.example.com/", "stringmatch": "", "timeout": 10,
+		"addresses": []interface{}{map[string]interface{}{"primary": []interface{}{"10.0.0.210", "10.0.0.211"}, "backup": []interface{}{}}},
+	}},
+	{"ifurlup_backup", ifUrlUpToLuaSnippet, ifUrlUpFromLuaSnippet, map[string]interface{}{
+		"url": "https://www.example.com/", "stringmatch": "", "timeout": 10,
+		"addresses": []interface{}{map[string]interface{}{"primary": []interface{}{"10.0.0.210"}, "backup": []interface{}{"10.0.0.212", "10.0.0.213"}}},
+	}},
+	{"ifurlup_stringmatch", ifUrlUpToLuaSnippet, ifUrlUpFromLuaSnippet, map[string]interface{}{
+		"url": "http://www.example.com:8080/health?full=1", "stringmatch": "status: ok", "timeout": 5,
+		"addresses": []interface{}{map[string]interface{}{"primary": []interface{}{"2001:db8::1"}, "backup": []interface{}{"2001:db8::2"}}},
+	}},
+	{"ifurlup_escapes", ifUrlUpToLuaSnippet, ifUrlUpFromLuaSnippet, map[string]interface{}{
+		"url": "https://www.example.com/it's", "stringmatch": "a\\b\tc'", "timeout": 5,
+		"addresses": []interface{}{map[string]interface{}{"primary": []interface{}{"10.0.0.210"}, "backup": []interface{}{}}},
+	}},
+	{"pickrandom_one_address", pickRandomToLuaSnippet, pickRandomFromLuaSnippet, map[string]interface{}{
+		"addresses": []interface{}{"127.0.0.1"},
+	}},
+	{"pickrandom_addresses", pickRandomToLuaSnippet, pickRandomFromLuaSnippet, map[string]interface{}{
+		"addresses": []interface{}{"127.0.0.1", "127.0.0.7", "127.0.0.8"},
+	}},
+	{"pickrandom_ipv6", pickRandomToLuaSnippet, pickRandomFromLuaSnippet, map[string]interface{}{
+		"addresses": []interface{}{"::1", "2001:db8::7"},
+	}},
+	{"pickwrandom_one_address", PickWrandomToLuaSnippet, pickWrandomFromLuaSnippet, map[string]interface{}{
+		"ipaddress": []interface{}{map[string]interface{}{"weight": 100, "ip": "192.168.1.1"}},
+	}},
+	{"pickwrandom_addresses", PickWrandomToLuaSnippet, pickWrandomFromLuaSnippet, map[string]interface{}{
+		"ipaddress": []interface{}{
+			map[string]interface{}{"weight": 10, "ip": "192.168.1.1"},
+			map[string]interface{}{"weight": 100, "ip": "192.168.1.2"},
+			map[string]interface{}{"weight": 1000, "ip": "192.168.1.3"},
+		},
+	}},
+	{"pickwrandom_ipv6", PickWrandomToLuaSnippet, pickWrandomFromLuaSnippet, map[string]interface{}{
+		"ipaddress": []interface{}{
+			map[string]interface{}{"weight": 1, "ip": "2001:db8::1"},
+			map[string]interface{}{"weight": 2, "ip": "2001:db8::2"},
+		},
+	}},
+}
+
+// testRenderSnippet renders the record with the rrtype and ttl of a resource
+func testRenderSnippet(render func([]interface{}) []interface{}, fields map[string]interface{}) string {
+	record := map[string]interface{}{"rrtype": "A", "ttl": 30}
+	for k, v := range fields {
+		record[k] = v
+	}
+	rrset := render([]interface{}{record})
+	return rrset[0].(map[string]interface{})["snippet"].(string)
+}
+
+func TestSnippetGolden(t *testing.T) {
+	for _, tc := range testSnippetCases {
+		snippet := testRenderSnippet(tc.render, tc.record)
+
+		golden := filepath.Join("testdata", "snippets", tc.name+".golden")
+		if *updateGolden {
+			if err := os.WriteFile(golden, []byte(snippet+"\n"), 0644); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+		}
+		expected, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatalf("%s: %s, run go test -update to create the golden file", tc.name, err)
+		}
+		if snippet != strings.TrimSuffix(string(expected), "\n") {
+			t.Errorf("%s: rendered snippet differs from %s\n got: %s\nwant: %s", tc.name, golden, snippet, expected)
+		}
+	}
+}
+
+func TestSnippetRoundTrip(t *testing.T) {
+	for _, tc := range testSnippetCases {
+		snippet := testRenderSnippet(tc.render, tc.record)
+
+		fields, err := tc.parse(snippet)
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(fields, tc.record) {
+			t.Errorf("%s: parsed fields differ\n got: %v\nwant: %v", tc.name, fields, tc.record)
+		}
+
+		// render -> parse -> render is stable
+		if again := testRenderSnippet(tc.render, fields); again != snippet {
+			t.Errorf("%s: snippet not stable\n got: %s\nwant: %s", tc.name, again, snippet)
+		}
+	}
+}
+
+func TestSnippetParseErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		parse   func(string) (map[string]interface{}, error)
+		snippet string
+	}{
+		"ifportup other function":    {ifPortUpFromLuaSnippet, "pickrandom({'192.168.1.1'})"},
+		"ifportup no timeout":        {ifPortUpFromLuaSnippet, "ifportup(443, {'192.168.1.1'},{})"},
+		"ifurlup other function":     {ifUrlUpFromLuaSnippet, "ifportup(443, {'192.168.1.1'},{timeout=2})"},
+		"ifurlup no backup":          {ifUrlUpFromLuaSnippet, "ifurlup('https://www.example.com/', {'10.0.0.210'},{stringmatch='', timeout=10})"},
+		"ifurlup no options":         {ifUrlUpFromLuaSnippet, "ifurlup('https://www.example.com/', {{'10.0.0.210'}, {} },{})"},
+		"ifurlup url escape":         {ifUrlUpFromLuaSnippet, `ifurlup('https://www.example.com/\300', {{'10.0.0.210'}, {} },{stringmatch='', timeout=10})`},
+		"ifurlup stringmatch escape": {ifUrlUpFromLuaSnippet, `ifurlup('https://www.example.com/', {{'10.0.0.210'}, {} },{stringmatch='\256', timeout=10})`},
+		"pickrandom other function":  {pickRandomFromLuaSnippet, "pickwrandom({{10, '192.168.1.1'}})"},
+		"pickwrandom free snippet":   {pickWrandomFromLuaSnippet, "os.date()"},
+	} {
+		_, err := tc.parse(tc.snippet)
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+			continue
+		}
+		if !strings.Contains(err.Error(), fmt.Sprintf("%q", tc.snippet)) {
+			t.Errorf("%s: the error does not name the snippet: %s", name, err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+# test.internal. not exported, the name of a resource is a single label of the zone test.internal.
+# www.sub.test.internal. not exported, the name of a resource is a single label of the zone test.internal.
+
+resource "powerdns-gslb_ifportup" "www" {
+  zone = "test.internal."
+  name = "www"
+  record {
+    rrtype    = "A"
+    ttl       = 30
+    addresses = ["192.168.1.1", "192.168.1.2"]
+    port      = 443
+    timeout   = 2
+  }
+  record {
+    rrtype    = "AAAA"
+    ttl       = 30
+    addresses = ["2001:db8::1"]
+    port      = 443
+    timeout   = 2
+  }
+}
+
+import {
+  to = powerdns-gslb_ifportup.www
+  id = "test.internal/www"
+}
+
+resource "powerdns-gslb_ifurlup" "app" {
+  zone = "test.internal."
+  name = "app"
+  record {
+    rrtype      = "A"
+    ttl         = 60
+    stringmatch = "ok"
+    timeout     = 5
+    url         = "https://app.test.internal/"
+    addresses {
+      backup  = ["10.0.0.2"]
+      primary = ["10.0.0.1"]
+    }
+  }
+}
+
+import {
+  to = powerdns-gslb_ifurlup.app
+  id = "test.internal/app"
+}
+
+resource "powerdns-gslb_pickrandom" "random" {
+  zone = "test.internal."
+  name = "random"
+  record {
+    rrtype    = "A"
+    ttl       = 30
+    addresses = ["127.0.0.1", "127.0.0.2"]
+  }
+}
+
+import {
+  to = powerdns-gslb_pickrandom.random
+  id = "test.internal/random"
+}
+
+resource "powerdns-gslb_pickwrandom" "weighted" {
+  zone = "test.internal."
+  name = "weighted"
+  record {
+    rrtype = "A"
+    ttl    = 30
+    ipaddress {
+      ip     = "192.168.1.1"
+      weight = 10
+    }
+    ipaddress {
+      ip     = "192.168.1.2"
+      weight = 90
+    }
+  }
+}
+
+import {
+  to = powerdns-gslb_pickwrandom.weighted
+  id = "test.internal/weighted"
+}
+
+resource "powerdns-gslb_lua" "spaced" {
+  zone = "test.internal."
+  name = "spaced"
+  record {
+    rrtype  = "A"
+    ttl     = 30
+    snippet = "pickrandom({'127.0.0.1', '127.0.0.2'})"
+  }
+}
+
+import {
+  to = powerdns-gslb_lua.spaced
+  id = "test.internal/spaced"
+}
+
+resource "powerdns-gslb_lua" "date" {
+  zone = "test.internal."
+  name = "date"
+  record {
+    rrtype  = "TXT"
+    ttl     = 30
+    snippet = "os.date()"
+  }
+}
+
+import {
+  to = powerdns-gslb_lua.date
+  id = "test.internal/date"
+}
+
+resource "powerdns-gslb_pickrandom" "wildcard" {
+  zone = "test.internal."
+  name = "*"
+  record {
+    rrtype    = "A"
+    ttl       = 30
+    addresses = ["127.0.0.1"]
+  }
+}
+
+import {
+  to = powerdns-gslb_pickrandom.wildcard
+  id = "test.internal/*"
+}
+
+resource "powerdns-gslb_pickrandom" "r_1st" {
+  zone = "test.internal."
+  name = "1st"
+  record {
+    rrtype    = "A"
+    ttl       = 30
+    addresses = ["127.0.0.1"]
+  }
+}
+
+import {
+  to = powerdns-gslb_pickrandom.r_1st
+  id = "test.internal/1st"
+}
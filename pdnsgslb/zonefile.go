@@ -0,0 +1,323 @@
+package pdnsgslb
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// LUA is the rdata of a PowerDNS LUA record, the rrtype answered and the
+// snippet. On the wire the rrtype is followed by the snippet as a
+// character-string. The type is not registered in the dns package, the
+// records of the zone files are read and written as generic records.
+type LUA struct {
+	Rrtype  uint16
+	Snippet string
+}
+
+// String returns the presentation of the rdata, the rrtype and the quoted
+// snippet
+func (l *LUA) String() string {
+	return dns.Type(l.Rrtype).String() + " " + quoteSnippet(l.Snippet)
+}
+
+// Parse reads the rrtype and the snippet of the presentation, the unquoted
+// words of a snippet are joined by a space
+func (l *LUA) Parse(txt []string) error {
+	if len(txt) < 2 {
+		return fmt.Errorf("Error LUA record expects a rrtype and a snippet")
+	}
+	rrtype, ok := dns.StringToType[strings.ToUpper(txt[0])]
+	if !ok {
+		return fmt.Errorf("Error unknown rrtype %s of LUA record", txt[0])
+	}
+
+	var words []string
+	for _, word := range txt[1:] {
+		unquoted, err := unquoteSnippet(word)
+		if err != nil {
+			return err
+		}
+		words = append(words, unquoted)
+	}
+	snippet := strings.Join(words, " ")
+	if err := checkSnippet(snippet); err != nil {
+		return err
+	}
+
+	l.Rrtype = rrtype
+	l.Snippet = snippet
+	return nil
+}
+
+// checkSnippet returns an error when the snippet does not fit in the rdata of
+// a LUA record, its length is written in a single byte
+func checkSnippet(snippet string) error {
+	if len(snippet) > 255 {
+		return fmt.Errorf("Error LUA snippet of %d bytes longer than 255 bytes: %q", len(snippet), truncateSnippet(snippet))
+	}
+	return nil
+}
+
+// rdata returns the rdata of the generic record, in hex
+func (l *LUA) rdata() string {
+	return fmt.Sprintf("%04x%02x", l.Rrtype, len(l.Snippet)) + hex.EncodeToString([]byte(l.Snippet))
+}
+
+// quoteSnippet returns the snippet as a quoted character-string, the quotes
+// and the backslashes escaped, the non printable characters as \DDD
+func quoteSnippet(snippet string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(snippet); i++ {
+		c := snippet[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < ' ' || c > '~':
+			fmt.Fprintf(&b, "\\%03d", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// unquoteSnippet returns a word of a snippet read by the zone parser, the
+// quotes are already removed and the escapes are decoded
+func unquoteSnippet(word string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(word); i++ {
+		if word[i] != '\\' {
+			b.WriteByte(word[i])
+			continue
+		}
+		i++
+		if i == len(word) {
+			return "", fmt.Errorf("Error invalid escape at the end of %q", word)
+		}
+		if i+2 < len(word) && isDigit(word[i]) && isDigit(word[i+1]) && isDigit(word[i+2]) {
+			n, _ := strconv.Atoi(word[i : i+3])
+			if n > 255 {
+				return "", fmt.Errorf("Error invalid escape \\%s in %q", word[i:i+3], word)
+			}
+			b.WriteByte(byte(n))
+			i += 2
+			continue
+		}
+		b.WriteByte(word[i])
+	}
+	return b.String(), nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// ParseZoneFile returns the LUA records of the text of a zone file, the other
+// records are skipped. The relative names are names of the zone, the records
+// must be in the zone.
+func ParseZoneFile(text string, zone string) ([]Record, error) {
+	zone = dns.Fqdn(zone)
+	text, err := genericLuaRecords(text, zone)
+	if err != nil {
+		return nil, err
+	}
+	zp := dns.NewZoneParser(strings.NewReader(text), zone, "")
+
+	var records []Record
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		if rr.Header().Rrtype != TYPE_LUA {
+			continue
+		}
+		if !dns.IsSubDomain(zone, rr.Header().Name) {
+			return nil, fmt.Errorf("Error %s is not a name of the zone %s", rr.Header().Name, zone)
+		}
+		generic, ok := rr.(*dns.RFC3597)
+		if !ok {
+			return nil, fmt.Errorf("Error LUA record of %s without rdata", rr.Header().Name)
+		}
+		records = append(records, toRecords([]*dns.RFC3597{generic}, "")...)
+	}
+	if err := zp.Err(); err != nil {
+		return nil, fmt.Errorf("Error parsing the zone file of %s: %w", zone, err)
+	}
+	return records, nil
+}
+
+// zoneEntry is a record or a directive of a zone file, over several lines
+// within parentheses
+type zoneEntry struct {
+	text  string
+	line  int
+	words []string
+	// the owner is omitted, the entry starts with a blank
+	blank bool
+}
+
+// zoneEntries splits the text of a zone file in entries, the words are
+// without the comments and the quotes of the quoted strings, the escapes are
+// kept as read by the zone parser
+func zoneEntries(text string) ([]zoneEntry, error) {
+	var entries []zoneEntry
+	var word strings.Builder
+	inWord := false
+	flush := func(entry *zoneEntry) {
+		if inWord {
+			entry.words = append(entry.words, word.String())
+			word.Reset()
+			inWord = false
+		}
+	}
+
+	entry := zoneEntry{line: 1}
+	start, line, depth := 0, 1, 0
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; c {
+		case '"':
+			flush(&entry)
+			j := i + 1
+			for ; j < len(text) && text[j] != '"'; j++ {
+				if text[j] == '\\' {
+					j++
+				}
+				if j < len(text) && text[j] == '\n' {
+					line++
+				}
+			}
+			if j >= len(text) {
+				return nil, fmt.Errorf("Error unterminated quoted string at line %d", line)
+			}
+			entry.words = append(entry.words, text[i+1:j])
+			i = j
+		case '\\':
+			word.WriteByte(c)
+			if i+1 < len(text) {
+				i++
+				word.WriteByte(text[i])
+			}
+			inWord = true
+		case ';':
+			flush(&entry)
+			for i+1 < len(text) && text[i+1] != '\n' {
+				i++
+			}
+		case '(':
+			flush(&entry)
+			depth++
+		case ')':
+			flush(&entry)
+			if depth == 0 {
+				return nil, fmt.Errorf("Error unbalanced parenthesis at line %d", line)
+			}
+			depth--
+		case ' ', '\t', '\r':
+			flush(&entry)
+		case '\n':
+			flush(&entry)
+			line++
+			if depth == 0 {
+				entry.text = text[start : i+1]
+				entries = append(entries, entry)
+				start = i + 1
+				entry = zoneEntry{line: line}
+			}
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	flush(&entry)
+	if start < len(text) {
+		entry.text = text[start:]
+		entries = append(entries, entry)
+	}
+
+	for i := range entries {
+		entries[i].blank = entries[i].text[0] == ' ' || entries[i].text[0] == '\t'
+	}
+	return entries, nil
+}
+
+// luaTypeIndex returns the index of the LUA type in the words of a record,
+// after the optional owner, ttl and class, -1 for the other entries
+func (e zoneEntry) luaTypeIndex() int {
+	if len(e.words) == 0 || (!e.blank && strings.HasPrefix(e.words[0], "$")) {
+		return -1
+	}
+	i := 0
+	if !e.blank {
+		i = 1
+	}
+	for n := 0; n < 2 && i < len(e.words); n++ {
+		word := strings.ToUpper(e.words[i])
+		_, class := dns.StringToClass[word]
+		if !class && !strings.HasPrefix(word, "CLASS") && !isDigit(word[0]) {
+			break
+		}
+		i++
+	}
+	if i < len(e.words) && strings.EqualFold(e.words[i], "LUA") {
+		return i
+	}
+	return -1
+}
+
+// genericLuaRecords returns the text of the zone file with the LUA records
+// in the generic presentation of RFC 3597, the rdata is read by LUA.Parse.
+// The records keep their lines, the other entries are unchanged.
+func genericLuaRecords(text string, zone string) (string, error) {
+	entries, err := zoneEntries(text)
+	if err != nil {
+		return "", fmt.Errorf("Error parsing the zone file of %s: %w", zone, err)
+	}
+
+	var b strings.Builder
+	for _, e := range entries {
+		i := e.luaTypeIndex()
+		if i < 0 {
+			b.WriteString(e.text)
+			continue
+		}
+		lua := new(LUA)
+		if err := lua.Parse(e.words[i+1:]); err != nil {
+			return "", fmt.Errorf("Error parsing the zone file of %s at line %d: %w", zone, e.line, err)
+		}
+		rdata := lua.rdata()
+		if e.blank {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%s TYPE%d \\# %d %s", strings.Join(e.words[:i], " "), TYPE_LUA, len(rdata)/2, rdata)
+		b.WriteString(strings.Repeat("\n", max(1, strings.Count(e.text, "\n"))))
+	}
+	return b.String(), nil
+}
+
+// FormatZoneFile returns the records as the lines of a zone file
+func FormatZoneFile(records []Record) string {
+	var b strings.Builder
+	for _, r := range records {
+		b.WriteString(r.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// RenderZoneFile returns the records of a typed resource of the name as the
+// lines of a zone file, the records are the fields of the resource schema
+func RenderZoneFile(name string, function string, records []map[string]interface{}) (string, error) {
+	var rendered []Record
+	for _, fields := range records {
+		rrset, err := RenderRecords(dns.Fqdn(name), function, fields)
+		if err != nil {
+			return "", err
+		}
+		rendered = append(rendered, rrset...)
+	}
+	return FormatZoneFile(rendered), nil
+}
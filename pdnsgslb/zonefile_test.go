@@ -0,0 +1,134 @@
+package pdnsgslb
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+const testZoneFile = `$ORIGIN test.internal.
+$TTL 60
+@       IN SOA ns.test.internal. hostmaster.test.internal. 1 3600 600 86400 60
+www     IN LUA A "ifportup(443, {'192.168.1.1','192.168.1.2'},{timeout=2})"
+        IN LUA AAAA "ifportup(443, {'2001:db8::1'},{timeout=2})"
+random  30 IN LUA A "pickrandom({'127.0.0.1','127.0.0.2'})"
+date    IN LUA TXT "os.date(\"%Y\") .. \"\\\\\""
+static  IN A 192.168.1.10
+@       IN LUA A "pickrandom({'127.0.0.1'})"
+multi   IN LUA ( A ; the answered rrtype
+        "pickrandom({'127.0.0.3'})" )
+text    IN TXT "IN LUA A"
+`
+
+func TestParseZoneFile(t *testing.T) {
+	records, err := ParseZoneFile(testZoneFile, "test.internal")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := []Record{
+		{"www.test.internal.", "A", 60, "ifportup(443, {'192.168.1.1','192.168.1.2'},{timeout=2})"},
+		{"www.test.internal.", "AAAA", 60, "ifportup(443, {'2001:db8::1'},{timeout=2})"},
+		{"random.test.internal.", "A", 30, "pickrandom({'127.0.0.1','127.0.0.2'})"},
+		{"date.test.internal.", "TXT", 60, `os.date("%Y") .. "\\"`},
+		{"test.internal.", "A", 60, "pickrandom({'127.0.0.1'})"},
+		{"multi.test.internal.", "A", 60, "pickrandom({'127.0.0.3'})"},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Fatalf("unexpected records %v", records)
+	}
+
+	// the rendered records are parsed back the same
+	parsed, err := ParseZoneFile(FormatZoneFile(records), "test.internal.")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(parsed, records) {
+		t.Errorf("unexpected records after a round trip %v", parsed)
+	}
+
+	for text, expected := range map[string]string{
+		"www.example.com. IN LUA A \"os.date()\"": "not a name of the zone",
+		"www IN LUA NOTATYPE \"os.date()\"":       "unknown rrtype NOTATYPE",
+		"www IN LUA A":                            "expects a rrtype and a snippet",
+		"www IN LUA A \"os.date()\" \"\\999\"":    "invalid escape",
+		"www IN LUA A \"os.date()\"\nwww IN LUA":  "at line 2",
+		"www IN LUA A \"os.date()":                "unterminated quoted string",
+		"$INCLUDE other.zone":                     "not allowed",
+	} {
+		if _, err := ParseZoneFile(text, "test.internal."); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected an error with %q, got %v", text, expected, err)
+		}
+	}
+}
+
+func TestLuaRdata(t *testing.T) {
+	for text, expected := range map[string]string{
+		"www.test.internal. IN LUA NOTATYPE \"os.date()\"": "unknown rrtype",
+		"www.test.internal. IN LUA A":                      "expects a rrtype and a snippet",
+	} {
+		lua := new(LUA)
+		if err := lua.Parse(strings.Fields(text)[3:]); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected an error with %q, got %v", text, expected, err)
+		}
+	}
+
+	// the generic record is the one sent by the client
+	record := Record{"www.test.internal.", "TXT", 30, "os.date()"}
+	generic := record.RR().(*dns.RFC3597)
+	if generic.Rdata != "001009"+"6f732e646174652829" {
+		t.Errorf("unexpected rdata %s", generic.Rdata)
+	}
+	if record.String() != "www.test.internal.\t30\tIN\tLUA\tTXT \"os.date()\"" {
+		t.Errorf("unexpected record %s", record)
+	}
+	if _, ok := dns.StringToType["LUA"]; ok {
+		t.Error("LUA type registered in the dns package")
+	}
+}
+
+func TestRenderZoneFile(t *testing.T) {
+	text, err := RenderZoneFile("www.test.internal", "pickrandom", []map[string]interface{}{
+		{"rrtype": rrtypeDualStack, "ttl": 30, "addresses": []interface{}{"127.0.0.1", "::1"}},
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := "www.test.internal.\t30\tIN\tLUA\tA \"pickrandom({'127.0.0.1'})\"\n" +
+		"www.test.internal.\t30\tIN\tLUA\tAAAA \"pickrandom({'::1'})\"\n"
+	if text != expected {
+		t.Errorf("unexpected zone file %q", text)
+	}
+}
+
+func TestDataSourceZoneFile(t *testing.T) {
+	r := dataSourceZoneFile()
+	d := r.TestResourceData()
+	d.Set("zone", "test.internal.")
+	d.Set("content", testZoneFile)
+	if diags := r.ReadContext(context.Background(), d, nil); diags.HasError() {
+		t.Fatalf("read: %v", diags)
+	}
+
+	if d.Id() != "test.internal" || d.Get("records.#") != 6 {
+		t.Errorf("unexpected id %s and records %v", d.Id(), d.Get("records"))
+	}
+	for attribute, expected := range map[string]interface{}{
+		"ifportup.#":                    1,
+		"ifportup.0.name":               "www",
+		"ifportup.0.record.#":           2,
+		"ifportup.0.record.0.port":      443,
+		"ifportup.0.record.0.addresses": []interface{}{"192.168.1.1", "192.168.1.2"},
+		"pickrandom.#":                  2,
+		"pickrandom.0.record.0.ttl":     30,
+		"lua.0.name":                    "date",
+		"lua.0.record.0.snippet":        `os.date("%Y") .. "\\"`,
+		"pickwrandom.#":                 0,
+	} {
+		if value := d.Get(attribute); !reflect.DeepEqual(value, expected) {
+			t.Errorf("%s: expected %v, got %v", attribute, expected, value)
+		}
+	}
+}